@@ -22,7 +22,7 @@ func main() {
 	}
 	defer service.Close()
 
-	if _, err := export.NewGSheetExporter(service.Config, service.Store); err != nil {
+	if _, err := export.NewGSheetExporter(service.Config, service.Store, service.Snapshots); err != nil {
 		logger.Error.Fatalf("Failed to initialize Google Sheets exporter: %v", err)
 	}
 
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/shrimpsizemoose/trekker/logger"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+)
+
+// migrate-tokens replaces plaintext tokens left in redis by older versions
+// with salted hashes and indexes chats associated with courses before the
+// chat indexes existed. Running it twice is harmless.
+func main() {
+	var configPath = flag.String("config", "config.toml", "Path to config file")
+	flag.Parse()
+
+	config, err := app.LoadConfig(*configPath)
+	if err != nil {
+		logger.Error.Fatalf("Failed to load config: %v", err)
+	}
+
+	if config.Auth.Backend == app.TokenBackendSQL {
+		logger.Info.Println("Tokens are kept in sql, nothing to migrate")
+		return
+	}
+
+	opt, err := redis.ParseURL(config.Auth.RedisURL)
+	if err != nil {
+		logger.Error.Fatalf("Failed to parse redis URL: %v", err)
+	}
+
+	tokenManager := app.NewRedisTokenManager(redis.NewClient(opt), config.Auth.TokenKeyTemplate)
+	defer tokenManager.Close()
+
+	migrated, err := tokenManager.MigratePlaintextTokens(context.Background())
+	if err != nil {
+		logger.Error.Fatalf("Token migration stopped after %d tokens: %v", migrated, err)
+	}
+
+	logger.Info.Printf("Hashed %d plaintext tokens", migrated)
+
+	indexed, err := tokenManager.IndexChatMappings(context.Background())
+	if err != nil {
+		logger.Error.Fatalf("Chat indexing stopped after %d chats: %v", indexed, err)
+	}
+
+	logger.Info.Printf("Indexed %d course chats", indexed)
+}
@@ -24,16 +24,35 @@ func main() {
 	}
 	defer service.Close()
 
-	if _, err := export.NewGSheetExporter(service.Config, service.Store); err != nil {
+	if _, err := export.NewGSheetExporter(service.Config, service.Store, service.Snapshots); err != nil {
 		log.Fatalf("Failed to initialize Google Sheets exporter: %v", err)
 	}
 
+	if err := service.StartAnomalyJob(); err != nil {
+		log.Fatalf("Failed to start anomaly job: %v", err)
+	}
+
 	entryHandler := handlers.NewEntryHandler(service)
 
-	http.HandleFunc("POST /api/v1/{course}/analytics", entryHandler.HandleLabEvent)
-	http.HandleFunc("GET /api/v1/{course}/analytics", entryHandler.HandleLabInfo)
-	http.HandleFunc("GET /api/v1/{course}/analytics/finish", entryHandler.HandleLabFinishInfo)
-	http.HandleFunc("GET /api/v1/{course}/scoring", entryHandler.HandleScoring)
+	// every route needs an api key with its scope, see app.Scopes
+	http.HandleFunc("POST /api/v1/{course}/analytics", entryHandler.Require(app.ScopeIngest, entryHandler.HandleLabEvent))
+	http.HandleFunc("GET /api/v1/{course}/analytics", entryHandler.Require(app.ScopeReadStats, entryHandler.HandleLabInfo))
+	http.HandleFunc("GET /api/v1/{course}/analytics/finish", entryHandler.Require(app.ScopeReadStats, entryHandler.HandleLabFinishInfo))
+	http.HandleFunc("GET /api/v1/{course}/anomalies", entryHandler.Require(app.ScopeReadStats, entryHandler.HandleAnomalies))
+	http.HandleFunc("GET /api/v1/{course}/scoring", entryHandler.Require(app.ScopeReadScores, entryHandler.HandleScoring))
+	http.HandleFunc("GET /api/v1/{course}/scoring/explain", entryHandler.Require(app.ScopeReadScores, entryHandler.HandleScoringExplain))
+	http.HandleFunc("POST /api/v1/{course}/scoring/simulate", entryHandler.Require(app.ScopeReadScores, entryHandler.HandleScoringSimulate))
+	http.HandleFunc("POST /api/v1/{course}/snapshots", entryHandler.Require(app.ScopeManageLabs, entryHandler.HandleSnapshotCreate))
+	http.HandleFunc("GET /api/v1/{course}/snapshots", entryHandler.Require(app.ScopeReadScores, entryHandler.HandleSnapshotList))
+	http.HandleFunc("GET /api/v1/{course}/snapshots/compare", entryHandler.Require(app.ScopeReadScores, entryHandler.HandleSnapshotCompare))
+	http.HandleFunc("GET /api/v1/{course}/snapshots/{name}", entryHandler.Require(app.ScopeReadScores, entryHandler.HandleSnapshotGet))
+	http.HandleFunc("POST /api/v1/{course}/teams", entryHandler.Require(app.ScopeManageLabs, entryHandler.HandleTeamCreate))
+	http.HandleFunc("GET /api/v1/{course}/teams", entryHandler.Require(app.ScopeReadStats, entryHandler.HandleTeamList))
+	http.HandleFunc("DELETE /api/v1/{course}/teams/{lab}/{team}", entryHandler.Require(app.ScopeManageLabs, entryHandler.HandleTeamDelete))
+
+	http.HandleFunc("POST /api/v1/{course}/tokens/rotate", entryHandler.Require(app.ScopeManageTokens, entryHandler.HandleTokenRotate))
+	http.HandleFunc("GET /api/v1/{course}/tokens/{student}", entryHandler.Require(app.ScopeManageTokens, entryHandler.HandleTokenInfo))
+	http.HandleFunc("POST /api/v1/{course}/tokens/{student}/revoke", entryHandler.Require(app.ScopeManageTokens, entryHandler.HandleTokenRevoke))
 
 	http.HandleFunc("GET /admin", func(w http.ResponseWriter, r *http.Request) {
 		http.ServeFile(w, r, "static/index.html")
@@ -0,0 +1,319 @@
+package anomaly
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+type Kind string
+
+const (
+	KindFinishWithoutStart  Kind = "finish_without_start"
+	KindShortDelta          Kind = "short_delta"
+	KindIdenticalPayload    Kind = "identical_payload"
+	KindBurst               Kind = "burst"
+	KindFinishAfterRotation Kind = "finish_after_rotation"
+	KindChallengeFailed     Kind = "challenge_failed"
+)
+
+var Kinds = []Kind{
+	KindFinishWithoutStart,
+	KindShortDelta,
+	KindIdenticalPayload,
+	KindBurst,
+	KindFinishAfterRotation,
+	KindChallengeFailed,
+}
+
+type Anomaly struct {
+	Kind      Kind     `json:"kind"`
+	Lab       string   `json:"lab,omitempty"`
+	Students  []string `json:"students"`
+	Timestamp int64    `json:"timestamp"`
+	Detail    string   `json:"detail"`
+}
+
+type Config struct {
+	// Schedule is a cron expression for the background job, empty disables it
+	Schedule string   `toml:"schedule"`
+	Courses  []string `toml:"courses"`
+	// a start->finish delta below median * ShortDeltaRatio is suspicious
+	ShortDeltaRatio float64 `toml:"short_delta_ratio"`
+	// labs with fewer finished students don't have a meaningful median
+	MinSamples         int   `toml:"min_samples"`
+	BurstEvents        int   `toml:"burst_events"`
+	BurstWindowSeconds int64 `toml:"burst_window_seconds"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.ShortDeltaRatio <= 0 {
+		c.ShortDeltaRatio = 0.1
+	}
+	if c.MinSamples <= 0 {
+		c.MinSamples = 5
+	}
+	if c.BurstEvents <= 0 {
+		c.BurstEvents = 10
+	}
+	if c.BurstWindowSeconds <= 0 {
+		c.BurstWindowSeconds = 60
+	}
+	return c
+}
+
+type Input struct {
+	Entries     []models.Entry
+	StartEvent  string
+	FinishEvent string
+	// Rotations is student -> unix time of the last token rotation
+	Rotations map[string]int64
+	// ChallengeFailures are finishes flagged when they came in
+	ChallengeFailures []models.ChallengeFailure
+}
+
+// Detect runs every check over the course events, results are ordered by time.
+// A token belongs to exactly one student, so per-token checks group by student.
+func Detect(in Input, cfg Config) []Anomaly {
+	cfg = cfg.withDefaults()
+
+	entries := make([]models.Entry, len(in.Entries))
+	copy(entries, in.Entries)
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp < entries[j].Timestamp
+	})
+
+	var found []Anomaly
+	found = append(found, detectLabTimings(entries, in, cfg)...)
+	found = append(found, detectIdenticalPayloads(entries)...)
+	found = append(found, detectBursts(entries, cfg)...)
+	found = append(found, challengeFailures(in.ChallengeFailures)...)
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].Timestamp != found[j].Timestamp {
+			return found[i].Timestamp < found[j].Timestamp
+		}
+		return found[i].Kind < found[j].Kind
+	})
+	return found
+}
+
+type labRun struct {
+	student     string
+	lab         string
+	firstStart  *int64
+	firstFinish *int64
+}
+
+func detectLabTimings(entries []models.Entry, in Input, cfg Config) []Anomaly {
+	runs := make(map[string]*labRun)
+	var order []string
+	for i := range entries {
+		e := &entries[i]
+		if e.EventType != in.StartEvent && e.EventType != in.FinishEvent {
+			continue
+		}
+		key := e.Lab + "/" + e.Student
+		run, ok := runs[key]
+		if !ok {
+			run = &labRun{student: e.Student, lab: e.Lab}
+			runs[key] = run
+			order = append(order, key)
+		}
+		switch {
+		case e.EventType == in.StartEvent && run.firstStart == nil:
+			run.firstStart = &e.Timestamp
+		case e.EventType == in.FinishEvent && run.firstFinish == nil:
+			run.firstFinish = &e.Timestamp
+		}
+	}
+
+	var found []Anomaly
+	deltas := make(map[string][]int64)
+	for _, key := range order {
+		run := runs[key]
+		if run.firstFinish == nil {
+			continue
+		}
+
+		if run.firstStart == nil || *run.firstStart > *run.firstFinish {
+			found = append(found, Anomaly{
+				Kind:      KindFinishWithoutStart,
+				Lab:       run.lab,
+				Students:  []string{run.student},
+				Timestamp: *run.firstFinish,
+				Detail:    "finish arrived before any start event",
+			})
+		} else {
+			deltas[run.lab] = append(deltas[run.lab], *run.firstFinish-*run.firstStart)
+		}
+
+		if rotated, ok := in.Rotations[run.student]; ok && *run.firstFinish > rotated &&
+			run.firstStart != nil && *run.firstStart < rotated {
+			found = append(found, Anomaly{
+				Kind:      KindFinishAfterRotation,
+				Lab:       run.lab,
+				Students:  []string{run.student},
+				Timestamp: *run.firstFinish,
+				Detail: fmt.Sprintf("lab started before the token was rotated at %s",
+					time.Unix(rotated, 0).UTC().Format(time.RFC3339)),
+			})
+		}
+	}
+
+	medians := make(map[string]int64)
+	for lab, d := range deltas {
+		if len(d) < cfg.MinSamples {
+			continue
+		}
+		medians[lab] = median(d)
+	}
+
+	for _, key := range order {
+		run := runs[key]
+		m, ok := medians[run.lab]
+		if !ok || run.firstStart == nil || run.firstFinish == nil || *run.firstStart > *run.firstFinish {
+			continue
+		}
+		delta := *run.firstFinish - *run.firstStart
+		if float64(delta) < float64(m)*cfg.ShortDeltaRatio {
+			found = append(found, Anomaly{
+				Kind:      KindShortDelta,
+				Lab:       run.lab,
+				Students:  []string{run.student},
+				Timestamp: *run.firstFinish,
+				Detail: fmt.Sprintf("finished in %s, lab median is %s",
+					time.Duration(delta)*time.Second,
+					time.Duration(m)*time.Second,
+				),
+			})
+		}
+	}
+
+	return found
+}
+
+func median(values []int64) int64 {
+	sorted := make([]int64, len(values))
+	copy(sorted, values)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// payloadKey strips the fields every client sends the same way, bodies that
+// carry nothing else can't tell students apart and are skipped
+func payloadKey(e models.Entry) string {
+	var body map[string]interface{}
+	if err := json.Unmarshal([]byte(e.Comment), &body); err != nil {
+		return e.Comment
+	}
+	delete(body, "event_type")
+	if len(body) == 0 {
+		return ""
+	}
+	// map keys are sorted by encoding/json, so equal bodies encode equally
+	key, _ := json.Marshal(body)
+	return string(key)
+}
+
+func detectIdenticalPayloads(entries []models.Entry) []Anomaly {
+	type group struct {
+		lab      string
+		first    int64
+		students []string
+		seen     map[string]bool
+	}
+	groups := make(map[string]*group)
+	var order []string
+
+	for _, e := range entries {
+		payload := payloadKey(e)
+		if payload == "" {
+			continue
+		}
+		key := e.Lab + "\x00" + e.EventType + "\x00" + payload
+		g, ok := groups[key]
+		if !ok {
+			g = &group{lab: e.Lab, first: e.Timestamp, seen: make(map[string]bool)}
+			groups[key] = g
+			order = append(order, key)
+		}
+		if !g.seen[e.Student] {
+			g.seen[e.Student] = true
+			g.students = append(g.students, e.Student)
+		}
+	}
+
+	var found []Anomaly
+	for _, key := range order {
+		g := groups[key]
+		if len(g.students) < 2 {
+			continue
+		}
+		found = append(found, Anomaly{
+			Kind:      KindIdenticalPayload,
+			Lab:       g.lab,
+			Students:  g.students,
+			Timestamp: g.first,
+			Detail:    fmt.Sprintf("%d students sent the same payload", len(g.students)),
+		})
+	}
+	return found
+}
+
+func detectBursts(entries []models.Entry, cfg Config) []Anomaly {
+	byStudent := make(map[string][]int64)
+	var students []string
+	for _, e := range entries {
+		if _, ok := byStudent[e.Student]; !ok {
+			students = append(students, e.Student)
+		}
+		byStudent[e.Student] = append(byStudent[e.Student], e.Timestamp)
+	}
+
+	var found []Anomaly
+	for _, student := range students {
+		ts := byStudent[student]
+		for start := 0; start < len(ts); {
+			end := start
+			for end < len(ts) && ts[end]-ts[start] < cfg.BurstWindowSeconds {
+				end++
+			}
+			if end-start < cfg.BurstEvents {
+				start++
+				continue
+			}
+			found = append(found, Anomaly{
+				Kind:      KindBurst,
+				Students:  []string{student},
+				Timestamp: ts[start],
+				Detail:    fmt.Sprintf("%d events within %ds", end-start, cfg.BurstWindowSeconds),
+			})
+			// one report per burst, continue after it
+			start = end
+		}
+	}
+	return found
+}
+
+// challengeFailures were already judged at ingestion, they only need reporting
+func challengeFailures(failures []models.ChallengeFailure) []Anomaly {
+	var found []Anomaly
+	for _, f := range failures {
+		found = append(found, Anomaly{
+			Kind:      KindChallengeFailed,
+			Lab:       f.Lab,
+			Students:  []string{f.Student},
+			Timestamp: f.Timestamp,
+			Detail:    fmt.Sprintf("finish didn't answer a start challenge: %s", f.Reason),
+		})
+	}
+	return found
+}
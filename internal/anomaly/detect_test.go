@@ -0,0 +1,97 @@
+package anomaly
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+const (
+	start  = "000_lab_start"
+	finish = "100_lab_finish"
+)
+
+func kinds(found []Anomaly) map[Kind][]Anomaly {
+	byKind := make(map[Kind][]Anomaly)
+	for _, a := range found {
+		byKind[a.Kind] = append(byKind[a.Kind], a)
+	}
+	return byKind
+}
+
+func TestDetect(t *testing.T) {
+	base := time.Date(2024, 4, 1, 10, 0, 0, 0, time.UTC).Unix()
+	hour := int64(time.Hour / time.Second)
+
+	var entries []models.Entry
+	add := func(student, lab, eventType string, ts int64, body string) {
+		entries = append(entries, models.Entry{
+			Student: student, Lab: lab, Course: "c1", EventType: eventType, Timestamp: ts, Comment: body,
+		})
+	}
+
+	// five students take about two hours on l1
+	for i, student := range []string{"a.a", "b.b", "c.c", "d.d", "e.e"} {
+		add(student, "l1", start, base, `{"event_type":"000_lab_start"}`)
+		add(student, "l1", finish, base+2*hour+int64(i)*60, `{"event_type":"100_lab_finish"}`)
+	}
+	// f.f is done in five minutes
+	add("f.f", "l1", start, base, `{"event_type":"000_lab_start"}`)
+	add("f.f", "l1", finish, base+300, `{"event_type":"100_lab_finish"}`)
+	// g.g never started, h.h replays g.g's request
+	add("g.g", "l2", finish, base+hour, `{"event_type":"100_lab_finish","answer":"42"}`)
+	add("h.h", "l2", start, base, `{"event_type":"000_lab_start"}`)
+	add("h.h", "l2", finish, base+hour+5, `{"answer":"42","event_type":"100_lab_finish"}`)
+	// i.i hammers the API
+	for i := int64(0); i < 12; i++ {
+		add("i.i", "l3", start, base+3*hour+i, `{"event_type":"000_lab_start"}`)
+	}
+	// j.j started with the old token
+	add("j.j", "l3", start, base, `{"event_type":"000_lab_start"}`)
+	add("j.j", "l3", finish, base+2*hour, `{"event_type":"100_lab_finish"}`)
+
+	found := kinds(Detect(Input{
+		Entries:     entries,
+		StartEvent:  start,
+		FinishEvent: finish,
+		Rotations:   map[string]int64{"j.j": base + hour, "a.a": base + 5*hour},
+		ChallengeFailures: []models.ChallengeFailure{
+			{Course: "c1", Lab: "l2", Student: "g.g", Timestamp: base + hour, Reason: "no_start"},
+		},
+	}, Config{}))
+
+	require.Len(t, found[KindShortDelta], 1)
+	assert.Equal(t, []string{"f.f"}, found[KindShortDelta][0].Students)
+
+	require.Len(t, found[KindFinishWithoutStart], 1)
+	assert.Equal(t, []string{"g.g"}, found[KindFinishWithoutStart][0].Students)
+
+	require.Len(t, found[KindIdenticalPayload], 1)
+	assert.Equal(t, []string{"g.g", "h.h"}, found[KindIdenticalPayload][0].Students)
+
+	require.Len(t, found[KindBurst], 1)
+	assert.Equal(t, []string{"i.i"}, found[KindBurst][0].Students)
+
+	require.Len(t, found[KindFinishAfterRotation], 1)
+	assert.Equal(t, []string{"j.j"}, found[KindFinishAfterRotation][0].Students)
+
+	require.Len(t, found[KindChallengeFailed], 1)
+	assert.Equal(t, []string{"g.g"}, found[KindChallengeFailed][0].Students)
+	assert.Contains(t, found[KindChallengeFailed][0].Detail, "no_start")
+}
+
+func TestDetect_SmallLabHasNoMedian(t *testing.T) {
+	entries := []models.Entry{
+		{Student: "a.a", Lab: "l1", EventType: start, Timestamp: 0},
+		{Student: "a.a", Lab: "l1", EventType: finish, Timestamp: 10000},
+		{Student: "b.b", Lab: "l1", EventType: start, Timestamp: 0},
+		{Student: "b.b", Lab: "l1", EventType: finish, Timestamp: 10},
+	}
+
+	found := Detect(Input{Entries: entries, StartEvent: start, FinishEvent: finish}, Config{})
+	assert.Empty(t, found)
+}
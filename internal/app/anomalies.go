@@ -0,0 +1,102 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"github.com/shrimpsizemoose/trekker/logger"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/anomaly"
+	"github.com/shrimpsizemoose/kanelbulle/internal/metrics"
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+func (s *Service) DetectAnomalies(course string) ([]anomaly.Anomaly, error) {
+	entries, err := s.Store.ListEntries(course)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get entries: %w", err)
+	}
+
+	var rotations map[string]int64
+	if s.Tokens != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), rosterTimeout)
+		defer cancel()
+
+		// rotation checks are skipped rather than failing the whole report
+		rotations, err = s.Tokens.FetchTokenRotations(ctx, course)
+		if err != nil {
+			logger.Error.Printf("Failed to fetch token rotations for course %s: %v", course, err)
+		}
+	}
+
+	var failures []models.ChallengeFailure
+	if s.Challenges != nil {
+		failures, err = s.Challenges.ListFailures(course)
+		if err != nil {
+			logger.Error.Printf("Failed to fetch challenge failures for course %s: %v", course, err)
+		}
+	}
+
+	return anomaly.Detect(anomaly.Input{
+		Entries:           entries,
+		StartEvent:        s.Config.Events.Start,
+		FinishEvent:       s.Config.Events.Finish,
+		Rotations:         rotations,
+		ChallengeFailures: failures,
+	}, s.Config.Anomalies), nil
+}
+
+// StartAnomalyJob periodically checks courses and publishes counts per kind as metrics
+// until the service is closed. Courses come from [anomalies] courses or, if empty,
+// from [courses].
+func (s *Service) StartAnomalyJob() error {
+	cfg := s.Config.Anomalies
+	if cfg.Schedule == "" {
+		return nil
+	}
+
+	courses := cfg.Courses
+	if len(courses) == 0 {
+		for course := range s.Config.Courses {
+			courses = append(courses, course)
+		}
+		sort.Strings(courses)
+	}
+	if len(courses) == 0 {
+		logger.Info.Printf("Anomaly job has no courses to check, not starting")
+		return nil
+	}
+
+	scheduler := gocron.NewScheduler(time.UTC)
+	_, err := scheduler.Cron(cfg.Schedule).Do(func() {
+		for _, course := range courses {
+			found, err := s.DetectAnomalies(course)
+			if err != nil {
+				logger.Error.Printf("Anomaly check for %s failed: %v", course, err)
+				continue
+			}
+
+			counts := make(map[anomaly.Kind]int)
+			for _, a := range found {
+				counts[a.Kind]++
+			}
+			for _, kind := range anomaly.Kinds {
+				metrics.LabAnomalies.WithLabelValues(course, string(kind)).Set(float64(counts[kind]))
+			}
+			if len(found) > 0 {
+				logger.Info.Printf("Anomaly check for %s found %d suspicious events", course, len(found))
+			}
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("failed to schedule anomaly job on schedule '%s': %w", cfg.Schedule, err)
+	}
+
+	logger.Info.Printf("Registering anomaly job for %v with schedule %s", courses, cfg.Schedule)
+	scheduler.StartAsync()
+	s.anomalyJob = scheduler
+	return nil
+}
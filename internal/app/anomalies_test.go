@@ -0,0 +1,27 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/store/sqlite"
+)
+
+func TestService_AnomalyJobStopsOnClose(t *testing.T) {
+	s, err := sqlite.NewSQLiteStore(":memory:", "../../migrations")
+	require.NoError(t, err)
+
+	config := &Config{}
+	config.Anomalies.Schedule = "*/5 * * * *"
+	config.Anomalies.Courses = []string{"DE15"}
+	service := &Service{Config: config, Store: s}
+
+	require.NoError(t, service.StartAnomalyJob())
+	require.NotNil(t, service.anomalyJob)
+	assert.True(t, service.anomalyJob.IsRunning())
+
+	require.NoError(t, service.Close())
+	assert.False(t, service.anomalyJob.IsRunning())
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+	"github.com/shrimpsizemoose/kanelbulle/internal/store"
+)
+
+// Scope is what an API key is allowed to do. Every route takes exactly one:
+//
+//   - ingest: lab clients posting events
+//   - read-stats: analytics, anomalies and teams
+//   - read-scores: scores, explanations, simulations and snapshots
+//   - manage-labs: the course setup graded against, teams and frozen snapshots
+//   - manage-overrides: manual scores, only the bot sets them for now
+//   - manage-tokens: token usage with client ips, rotating and revoking student tokens
+type Scope string
+
+const (
+	ScopeIngest          Scope = "ingest"
+	ScopeReadStats       Scope = "read-stats"
+	ScopeReadScores      Scope = "read-scores"
+	ScopeManageLabs      Scope = "manage-labs"
+	ScopeManageOverrides Scope = "manage-overrides"
+	ScopeManageTokens    Scope = "manage-tokens"
+)
+
+var Scopes = []Scope{ScopeIngest, ScopeReadStats, ScopeReadScores, ScopeManageLabs, ScopeManageOverrides, ScopeManageTokens}
+
+// HeaderScopes are what the required headers alone still open without an api
+// key, the routes that existed before keys. manage-* always needs a named key.
+var HeaderScopes = []Scope{ScopeIngest, ScopeReadStats, ScopeReadScores}
+
+const (
+	DefaultAPIKeyHeader = "X-API-Key"
+	apiKeyPrefix        = "kb-key-"
+)
+
+var (
+	ErrAPIKeyMissing   = errors.New("api key required")
+	ErrAPIKeyInvalid   = errors.New("invalid api key")
+	ErrAPIKeyForbidden = errors.New("api key not allowed here")
+)
+
+var apiKeyNameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// ParseScopes reads a comma separated scope list, "all" means every scope
+func ParseScopes(value string) ([]string, error) {
+	if value == "all" {
+		scopes := make([]string, 0, len(Scopes))
+		for _, scope := range Scopes {
+			scopes = append(scopes, string(scope))
+		}
+		return scopes, nil
+	}
+
+	var scopes []string
+	for _, scope := range strings.Split(value, ",") {
+		if !slices.Contains(Scopes, Scope(scope)) {
+			return nil, fmt.Errorf("unknown scope %q", scope)
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// APIKeys issues and checks named API keys kept in the scores database.
+// Keys are long random strings, so an unsalted hash is safe to store and
+// lets a key be found by its hash alone.
+type APIKeys struct {
+	store store.APIKeyStore
+}
+
+func NewAPIKeys(scores store.ScoreStore) (*APIKeys, error) {
+	keys, ok := scores.(store.APIKeyStore)
+	if !ok {
+		return nil, fmt.Errorf("store %T can't keep api keys", scores)
+	}
+	return &APIKeys{store: keys}, nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Create returns the plaintext key, it can't be recovered later
+func (k *APIKeys) Create(name string, scopes, courses []string, actor string) (string, *models.APIKey, error) {
+	if !apiKeyNameRe.MatchString(name) {
+		return "", nil, fmt.Errorf("invalid key name %q, use letters, digits, dots, dashes and underscores", name)
+	}
+	if len(scopes) == 0 {
+		return "", nil, fmt.Errorf("key %s needs at least one scope", name)
+	}
+
+	randomBytes := make([]byte, 24)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", nil, fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	plaintext := apiKeyPrefix + hex.EncodeToString(randomBytes)
+
+	key := &models.APIKey{
+		Name:      name,
+		Hint:      apiKeyPrefix + "…" + plaintext[len(plaintext)-4:],
+		Scopes:    scopes,
+		Courses:   courses,
+		CreatedAt: time.Now().UTC(),
+		CreatedBy: actor,
+	}
+	if err := k.store.CreateAPIKey(*key, hashAPIKey(plaintext)); err != nil {
+		return "", nil, err
+	}
+	return plaintext, key, nil
+}
+
+func (k *APIKeys) List() ([]models.APIKey, error) {
+	return k.store.ListAPIKeys()
+}
+
+func (k *APIKeys) Revoke(name string) error {
+	revoked, err := k.store.RevokeAPIKey(name, time.Now().UTC().Unix())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return fmt.Errorf("no active api key named %s", name)
+	}
+	return nil
+}
+
+// Authorize returns the key when it has the scope and covers the course,
+// otherwise ErrAPIKeyInvalid or ErrAPIKeyForbidden
+func (k *APIKeys) Authorize(plaintext string, scope Scope, course string) (*models.APIKey, error) {
+	key, err := k.store.GetAPIKeyByHash(hashAPIKey(plaintext))
+	if err != nil {
+		return nil, err
+	}
+	if key == nil || key.RevokedAt != nil {
+		return nil, ErrAPIKeyInvalid
+	}
+	if !slices.Contains(key.Scopes, string(scope)) {
+		return key, fmt.Errorf("%w: key %s has no %s scope", ErrAPIKeyForbidden, key.Name, scope)
+	}
+	if len(key.Courses) > 0 && !slices.Contains(key.Courses, course) {
+		return key, fmt.Errorf("%w: key %s is not valid for course %s", ErrAPIKeyForbidden, key.Name, course)
+	}
+	return key, nil
+}
@@ -0,0 +1,110 @@
+package app
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+type memoryAPIKeys map[string]models.APIKey
+
+func (m memoryAPIKeys) CreateAPIKey(key models.APIKey, keyHash string) error {
+	for _, existing := range m {
+		if existing.Name == key.Name {
+			return errors.New("duplicate name")
+		}
+	}
+	m[keyHash] = key
+	return nil
+}
+
+func (m memoryAPIKeys) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	key, ok := m[keyHash]
+	if !ok {
+		return nil, nil
+	}
+	return &key, nil
+}
+
+func (m memoryAPIKeys) ListAPIKeys() ([]models.APIKey, error) {
+	var keys []models.APIKey
+	for _, key := range m {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (m memoryAPIKeys) RevokeAPIKey(name string, at int64) (bool, error) {
+	for hash, key := range m {
+		if key.Name == name && key.RevokedAt == nil {
+			revoked := time.Unix(at, 0)
+			key.RevokedAt = &revoked
+			m[hash] = key
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("read-stats,read-scores,read-stats")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"read-stats", "read-scores"}, scopes)
+
+	scopes, err = ParseScopes("all")
+	require.NoError(t, err)
+	assert.Len(t, scopes, len(Scopes))
+
+	_, err = ParseScopes("read-stats,admin")
+	assert.Error(t, err)
+}
+
+func TestAPIKeys_Authorize(t *testing.T) {
+	keys := &APIKeys{store: memoryAPIKeys{}}
+
+	stats, key, err := keys.Create("grafana", []string{"read-stats"}, []string{"DE15"}, "@admin")
+	require.NoError(t, err)
+	assert.Equal(t, apiKeyPrefix+"…"+stats[len(stats)-4:], key.Hint)
+
+	ingest, _, err := keys.Create("labs", []string{"ingest"}, nil, "@admin")
+	require.NoError(t, err)
+
+	_, _, err = keys.Create("grafana", []string{"ingest"}, nil, "@admin")
+	assert.Error(t, err)
+	_, _, err = keys.Create("bad name", []string{"ingest"}, nil, "@admin")
+	assert.Error(t, err)
+
+	tests := []struct {
+		name   string
+		key    string
+		scope  Scope
+		course string
+		want   error
+	}{
+		{"scope and course match", stats, ScopeReadStats, "DE15", nil},
+		{"other course", stats, ScopeReadStats, "DE16", ErrAPIKeyForbidden},
+		{"missing scope", stats, ScopeReadScores, "DE15", ErrAPIKeyForbidden},
+		{"any course", ingest, ScopeIngest, "DE16", nil},
+		{"unknown key", "kb-key-nope", ScopeIngest, "DE15", ErrAPIKeyInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keys.Authorize(tt.key, tt.scope, tt.course)
+			if tt.want == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tt.want)
+			}
+		})
+	}
+
+	require.NoError(t, keys.Revoke("labs"))
+	_, err = keys.Authorize(ingest, ScopeIngest, "DE15")
+	assert.ErrorIs(t, err, ErrAPIKeyInvalid)
+	assert.Error(t, keys.Revoke("labs"))
+}
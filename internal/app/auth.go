@@ -3,10 +3,10 @@ package app
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"strings"
+	"time"
 
-	"github.com/redis/go-redis/v9"
 	"github.com/shrimpsizemoose/trekker/logger"
 )
 
@@ -17,73 +17,123 @@ import (
 // 	CreatedDttm     string `json:"created_dttm_utc"`
 // }
 
+var (
+	ErrTokenNotFound = errors.New("token not found")
+	ErrTokenInvalid  = errors.New("invalid token")
+	ErrTokenRevoked  = errors.New("token revoked")
+	ErrTokenExpired  = errors.New("token expired")
+
+	ErrStudentMissing  = errors.New("student not specified")
+	ErrStudentMismatch = errors.New("student doesn't match the token")
+	ErrCourseMismatch  = errors.New("token belongs to another course")
+)
+
 type Auth struct {
 	enabled     bool
-	redis       *redis.Client
-	keyTemplate string
+	tokens      TokenManager
 	tokenHeader string
+	lifetime    *TokenLifetime
 }
 
-func NewAuth(config *Config) (*Auth, error) {
+// NewAuth validates tokens kept by tokens, which may be nil when auth is disabled
+func NewAuth(config *Config, tokens TokenManager) (*Auth, error) {
 	if !config.Auth.Enabled {
 		return &Auth{enabled: false}, nil
 	}
 
-	opt, err := redis.ParseURL(config.Auth.RedisURL)
+	lifetime, err := NewTokenLifetime(config.Auth.TokenTTL, config.Courses)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse redis URL: %w", err)
-	}
-
-	client := redis.NewClient(opt)
-	if err := client.Ping(context.Background()).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+		return nil, err
 	}
 
 	return &Auth{
 		enabled:     true,
-		redis:       client,
-		keyTemplate: config.Auth.TokenKeyTemplate,
+		tokens:      tokens,
 		tokenHeader: config.Auth.TokenHeader,
+		lifetime:    lifetime,
 	}, nil
 }
 
-func (a *Auth) Close() error {
-	if a.redis != nil {
-		return a.redis.Close()
+// ResolveStudent finds the owner of the token. Tokens from before the token
+// index aren't found, for them the claimed student is trusted until
+// ValidateToken checks the token against it.
+func (a *Auth) ResolveStudent(ctx context.Context, course, claimed, token string) (string, error) {
+	if !a.enabled {
+		return claimed, nil
 	}
-	return nil
+
+	owner, err := a.tokens.ResolveToken(ctx, token)
+	if errors.Is(err, ErrTokenNotFound) {
+		if claimed == "" {
+			return "", fmt.Errorf("%w: unknown token, tokens issued before the token index need the student header", ErrStudentMissing)
+		}
+		return claimed, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if owner.Course != course {
+		return "", fmt.Errorf("%w: the token is for course %s, not %s", ErrCourseMismatch, owner.Course, course)
+	}
+	if claimed != "" && claimed != owner.StudentID {
+		return "", fmt.Errorf("%w: student header says %s, the token belongs to %s", ErrStudentMismatch, claimed, owner.StudentID)
+	}
+	return owner.StudentID, nil
 }
 
+// ValidateToken returns one of the ErrToken* errors, possibly wrapped, when the token can't be used
 func (a *Auth) ValidateToken(ctx context.Context, course, student, token string) error {
 	if !a.enabled {
 		return nil
 	}
 
-	key := strings.NewReplacer(
-		"{course}", course,
-		"{student}", student,
-	).Replace(a.keyTemplate)
-
-	fields, err := a.redis.HGetAll(ctx, key).Result()
-	if err == redis.Nil {
-		logger.Debug.Printf("Token not found for key: %s", key)
-		return fmt.Errorf("token not found")
+	secret, err := a.tokens.FetchTokenSecret(ctx, course, student)
+	if errors.Is(err, ErrTokenNotFound) {
+		logger.Debug.Printf("Token not found for course/student=%s/%s", course, student)
+		return ErrTokenNotFound
 	}
 	if err != nil {
-		logger.Debug.Printf("Redis error: %v", err)
-		return fmt.Errorf("redis error: %w", err)
+		logger.Debug.Printf("Token store error: %v", err)
+		return err
+	}
+
+	if !secret.matches(token) {
+		logger.Debug.Printf("Token mismatch for course/student=%s/%s", course, student)
+		return ErrTokenInvalid
 	}
 
-	if fields["token"] != token {
-		logger.Debug.Printf(
-			"Token mismatch for course/student/token=%s/%s/%s and what's found in %s",
-			course,
-			student,
-			token,
-			key,
-		)
-		return fmt.Errorf("invalid token")
+	if secret.Revoked {
+		logger.Debug.Printf("Revoked token used for %s/%s", course, student)
+		return ErrTokenRevoked
+	}
+
+	if expiry := a.lifetime.Expiry(course, secret.Issued); !expiry.IsZero() && time.Now().After(expiry) {
+		logger.Debug.Printf("Expired token used for %s/%s", course, student)
+		return fmt.Errorf("%w at %s", ErrTokenExpired, expiry.UTC().Format(time.RFC3339))
 	}
 
 	return nil
 }
+
+// authFailureReason is the metric label for a ValidateToken error
+func authFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrTokenNotFound):
+		return "not_found"
+	case errors.Is(err, ErrTokenInvalid):
+		return "invalid"
+	case errors.Is(err, ErrTokenRevoked):
+		return "revoked"
+	case errors.Is(err, ErrTokenExpired):
+		return "expired"
+	case errors.Is(err, ErrStudentMissing):
+		return "student_missing"
+	case errors.Is(err, ErrStudentMismatch):
+		return "student_mismatch"
+	case errors.Is(err, ErrCourseMismatch):
+		return "course_mismatch"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,93 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+	"github.com/shrimpsizemoose/kanelbulle/internal/store/sqlite"
+)
+
+func newSQLAuth(t *testing.T) (*Auth, *SQLTokenManager) {
+	s, err := sqlite.NewSQLiteStore(":memory:", "../../migrations")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+
+	tokens := NewSQLTokenManager(s)
+	config := &Config{}
+	config.Auth.Enabled = true
+	auth, err := NewAuth(config, tokens)
+	require.NoError(t, err)
+	return auth, tokens
+}
+
+func TestAuth_ResolveStudent(t *testing.T) {
+	ctx := context.Background()
+	auth, tokens := newSQLAuth(t)
+
+	info, created, err := tokens.FetchOrCreateStudentToken(ctx, "DE15", "alice.a")
+	require.NoError(t, err)
+	require.True(t, created)
+	token := info.Token
+
+	student, err := auth.ResolveStudent(ctx, "DE15", "", token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice.a", student)
+	assert.NoError(t, auth.ValidateToken(ctx, "DE15", student, token))
+
+	student, err = auth.ResolveStudent(ctx, "DE15", "alice.a", token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice.a", student)
+
+	_, err = auth.ResolveStudent(ctx, "DE15", "bob.b", token)
+	assert.ErrorIs(t, err, ErrStudentMismatch)
+
+	_, err = auth.ResolveStudent(ctx, "DE16", "", token)
+	assert.ErrorIs(t, err, ErrCourseMismatch)
+
+	// unknown tokens fall back to the claimed student and fail validation
+	_, err = auth.ResolveStudent(ctx, "DE15", "", "sk-knlbll-nope")
+	assert.ErrorIs(t, err, ErrStudentMissing)
+	student, err = auth.ResolveStudent(ctx, "DE15", "alice.a", "sk-knlbll-nope")
+	require.NoError(t, err)
+	assert.ErrorIs(t, auth.ValidateToken(ctx, "DE15", student, "sk-knlbll-nope"), ErrTokenInvalid)
+
+	// rotation moves the index to the new token
+	rotated, err := tokens.RotateStudentToken(ctx, "DE15", "alice.a", "test")
+	require.NoError(t, err)
+	_, err = auth.ResolveStudent(ctx, "DE15", "", token)
+	assert.ErrorIs(t, err, ErrStudentMissing)
+	student, err = auth.ResolveStudent(ctx, "DE15", "", rotated.Token)
+	require.NoError(t, err)
+	assert.Equal(t, "alice.a", student)
+}
+
+func TestSQLTokenManager_TokenUsage(t *testing.T) {
+	ctx := context.Background()
+	_, tokens := newSQLAuth(t)
+
+	_, _, err := tokens.FetchOrCreateStudentToken(ctx, "DE15", "alice.a")
+	require.NoError(t, err)
+
+	usage, err := tokens.FetchTokenUsage(ctx, "DE15", "alice.a")
+	require.NoError(t, err)
+	assert.Zero(t, usage.Count)
+
+	use := models.TokenUse{Lab: "01s", EventType: "finish", IP: "10.0.0.1", UserAgent: "checker", Time: time.Now()}
+	require.NoError(t, tokens.RecordTokenUse(ctx, "DE15", "alice.a", use))
+	usage, err = tokens.FetchTokenUsage(ctx, "DE15", "alice.a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), usage.Count)
+	assert.Equal(t, map[string]int64{"01s/finish": 1}, usage.Events)
+
+	// a new token starts with clean usage
+	_, err = tokens.RotateStudentToken(ctx, "DE15", "alice.a", "test")
+	require.NoError(t, err)
+	usage, err = tokens.FetchTokenUsage(ctx, "DE15", "alice.a")
+	require.NoError(t, err)
+	assert.Zero(t, usage.Count)
+}
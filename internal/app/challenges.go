@@ -0,0 +1,272 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/shrimpsizemoose/trekker/logger"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/metrics"
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+	"github.com/shrimpsizemoose/kanelbulle/internal/store"
+)
+
+// A start event of a course with challenges gets a fresh nonce back in the
+// X-Challenge response header. The matching finish has to send it back in
+// X-Challenge, as is in echo mode or as hex HMAC-SHA256 of the nonce with
+// the signing secret of the lab in hmac mode.
+
+const (
+	ChallengeHeader = "X-Challenge"
+
+	ChallengeModeEcho = "echo"
+	ChallengeModeHMAC = "hmac"
+
+	ChallengeOnFailureFlag   = "flag"
+	ChallengeOnFailureReject = "reject"
+
+	defaultChallengeTTL = 7 * 24 * time.Hour
+)
+
+var (
+	ErrChallengeMissing  = errors.New("finish without challenge response")
+	ErrChallengeNoStart  = errors.New("no open challenge for this lab")
+	ErrChallengeMismatch = errors.New("challenge response doesn't match")
+)
+
+type CourseChallenge struct {
+	Mode string `toml:"mode"`
+	// OnFailure is "flag" (default) to accept the finish and report it, or "reject"
+	OnFailure string `toml:"on_failure"`
+}
+
+type ChallengeConfig struct {
+	// TTL like "168h" is how long a started lab can wait for its finish
+	TTL     string                     `toml:"ttl"`
+	Courses map[string]CourseChallenge `toml:"courses"`
+}
+
+// Validate needs the signing config because hmac mode uses its secrets
+func (c ChallengeConfig) Validate(signing SigningConfig) error {
+	if c.TTL != "" {
+		if d, err := time.ParseDuration(c.TTL); err != nil || d <= 0 {
+			return fmt.Errorf("challenges: invalid ttl %q, use a duration like 168h", c.TTL)
+		}
+	}
+	for course, cc := range c.Courses {
+		switch cc.Mode {
+		case ChallengeModeEcho:
+		case ChallengeModeHMAC:
+			if signing.Courses[course].Secret == "" && len(signing.Courses[course].Labs) == 0 {
+				return fmt.Errorf("challenges: course %s uses hmac but has no [signing] secret", course)
+			}
+		default:
+			return fmt.Errorf("challenges: unknown mode %q for course %s, expected %q or %q",
+				cc.Mode, course, ChallengeModeEcho, ChallengeModeHMAC)
+		}
+		switch cc.OnFailure {
+		case "", ChallengeOnFailureFlag, ChallengeOnFailureReject:
+		default:
+			return fmt.Errorf("challenges: unknown on_failure %q for course %s, expected %q or %q",
+				cc.OnFailure, course, ChallengeOnFailureFlag, ChallengeOnFailureReject)
+		}
+	}
+	return nil
+}
+
+func (c ChallengeConfig) ttl() time.Duration {
+	if d, err := time.ParseDuration(c.TTL); err == nil && d > 0 {
+		return d
+	}
+	return defaultChallengeTTL
+}
+
+// Challenges issues start nonces in redis and records finishes that failed them
+type Challenges struct {
+	config   ChallengeConfig
+	signing  SigningConfig
+	redis    *redis.Client
+	failures store.ChallengeStore
+}
+
+func NewChallenges(config ChallengeConfig, signing SigningConfig, client *redis.Client, failures store.ChallengeStore) *Challenges {
+	return &Challenges{config: config, signing: signing, redis: client, failures: failures}
+}
+
+// newServiceChallenges is nil unless some course has challenges
+func newServiceChallenges(config *Config, scores store.ScoreStore) (*Challenges, error) {
+	if len(config.Challenges.Courses) == 0 {
+		return nil, nil
+	}
+
+	failures, ok := scores.(store.ChallengeStore)
+	if !ok {
+		return nil, fmt.Errorf("store %T can't keep challenge failures", scores)
+	}
+
+	opt, err := redis.ParseURL(config.Auth.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redis URL: %w", err)
+	}
+	client := redis.NewClient(opt)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	return NewChallenges(config.Challenges, config.Signing, client, failures), nil
+}
+
+func (c *Challenges) Close() error {
+	return c.redis.Close()
+}
+
+func (c *Challenges) Enabled(course string) bool {
+	_, ok := c.config.Courses[course]
+	return ok
+}
+
+// Rejects tells whether a failed finish is refused instead of flagged
+func (c *Challenges) Rejects(course string) bool {
+	return c.config.Courses[course].OnFailure == ChallengeOnFailureReject
+}
+
+func challengeKey(course, lab, student string) string {
+	return fmt.Sprintf("challenge:%s:%s:%s", course, lab, student)
+}
+
+// Issue opens a challenge for a start, every start gets its own nonce and
+// any open one answers the finish
+func (c *Challenges) Issue(ctx context.Context, course, lab, student string) (string, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("failed to generate challenge: %w", err)
+	}
+	nonce := hex.EncodeToString(randomBytes)
+
+	key := challengeKey(course, lab, student)
+	pipe := c.redis.TxPipeline()
+	pipe.SAdd(ctx, key, nonce)
+	pipe.Expire(ctx, key, c.config.ttl())
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", fmt.Errorf("failed to store challenge: %w", err)
+	}
+	return nonce, nil
+}
+
+// Check closes the challenge answered by response, or returns one of the ErrChallenge* errors
+func (c *Challenges) Check(ctx context.Context, course, lab, student, response string) error {
+	if response == "" {
+		return ErrChallengeMissing
+	}
+
+	key := challengeKey(course, lab, student)
+	nonces, err := c.redis.SMembers(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to fetch challenges: %w", err)
+	}
+	if len(nonces) == 0 {
+		return ErrChallengeNoStart
+	}
+
+	for _, nonce := range nonces {
+		if hmac.Equal([]byte(c.expected(course, lab, nonce)), []byte(response)) {
+			if err := c.redis.SRem(ctx, key, nonce).Err(); err != nil {
+				return fmt.Errorf("failed to close challenge: %w", err)
+			}
+			return nil
+		}
+	}
+	return ErrChallengeMismatch
+}
+
+func (c *Challenges) expected(course, lab, nonce string) string {
+	if c.config.Courses[course].Mode != ChallengeModeHMAC {
+		return nonce
+	}
+	mac := hmac.New(sha256.New, []byte(c.signing.Secret(course, lab)))
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (c *Challenges) RecordFailure(failure models.ChallengeFailure) error {
+	return c.failures.CreateChallengeFailure(failure)
+}
+
+func (c *Challenges) ListFailures(course string) ([]models.ChallengeFailure, error) {
+	return c.failures.ListChallengeFailures(course)
+}
+
+// isChallengeError is false for failures of redis rather than of the client
+func isChallengeError(err error) bool {
+	return challengeFailureReason(err) != "error"
+}
+
+// challengeFailureReason is the metric label and the recorded reason for a Check error
+func challengeFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrChallengeMissing):
+		return "missing"
+	case errors.Is(err, ErrChallengeNoStart):
+		return "no_start"
+	case errors.Is(err, ErrChallengeMismatch):
+		return "mismatch"
+	default:
+		return "error"
+	}
+}
+
+// CheckChallenge passes every event but finishes of courses with challenges.
+// A failed finish is recorded and let through unless the course rejects it,
+// then the error is returned. Redis trouble never blocks a finish.
+func (s *Service) CheckChallenge(r *http.Request, entry *models.Entry) error {
+	if s.Challenges == nil || entry.EventType != s.Config.Events.Finish || !s.Challenges.Enabled(entry.Course) {
+		return nil
+	}
+
+	err := s.Challenges.Check(r.Context(), entry.Course, entry.Lab, entry.Student, r.Header.Get(ChallengeHeader))
+	if err == nil {
+		return nil
+	}
+	if !isChallengeError(err) {
+		logger.Error.Printf("Challenge check failed, letting the finish through: %v", err)
+		return nil
+	}
+
+	reason := challengeFailureReason(err)
+	metrics.ChallengeFailuresTotal.WithLabelValues(entry.Course, reason).Inc()
+	if s.Challenges.Rejects(entry.Course) {
+		return err
+	}
+
+	if err := s.Challenges.RecordFailure(models.ChallengeFailure{
+		Course:    entry.Course,
+		Lab:       entry.Lab,
+		Student:   entry.Student,
+		Timestamp: entry.Timestamp,
+		Reason:    reason,
+	}); err != nil {
+		logger.Error.Printf("Failed to record challenge failure: %v", err)
+	}
+	return nil
+}
+
+// IssueChallenge returns the nonce for a start event, empty when there is nothing to answer
+func (s *Service) IssueChallenge(ctx context.Context, entry *models.Entry) string {
+	if s.Challenges == nil || entry.EventType != s.Config.Events.Start || !s.Challenges.Enabled(entry.Course) {
+		return ""
+	}
+
+	nonce, err := s.Challenges.Issue(ctx, entry.Course, entry.Lab, entry.Student)
+	if err != nil {
+		logger.Error.Printf("Failed to issue challenge for %s/%s/%s: %v", entry.Course, entry.Lab, entry.Student, err)
+		return ""
+	}
+	return nonce
+}
@@ -0,0 +1,50 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChallengeConfig_Validate(t *testing.T) {
+	signing := SigningConfig{Courses: map[string]CourseSigning{
+		"DE15": {Mode: SigningModeSigned, Secret: "s3cret"},
+	}}
+
+	valid := ChallengeConfig{TTL: "24h", Courses: map[string]CourseChallenge{
+		"DE15": {Mode: ChallengeModeHMAC, OnFailure: ChallengeOnFailureReject},
+		"DE16": {Mode: ChallengeModeEcho},
+	}}
+	require.NoError(t, valid.Validate(signing))
+
+	for name, cfg := range map[string]ChallengeConfig{
+		"hmac without secret": {Courses: map[string]CourseChallenge{"DE16": {Mode: ChallengeModeHMAC}}},
+		"unknown mode":        {Courses: map[string]CourseChallenge{"DE15": {Mode: "reverse"}}},
+		"unknown on_failure":  {Courses: map[string]CourseChallenge{"DE15": {Mode: ChallengeModeEcho, OnFailure: "ignore"}}},
+		"bad ttl":             {TTL: "a week"},
+	} {
+		assert.Error(t, cfg.Validate(signing), name)
+	}
+}
+
+func TestChallenges_Expected(t *testing.T) {
+	signing := SigningConfig{Courses: map[string]CourseSigning{
+		"DE15": {Mode: SigningModeSigned, Secret: "s3cret", Labs: map[string]string{"02s": "lab"}},
+	}}
+	c := NewChallenges(ChallengeConfig{Courses: map[string]CourseChallenge{
+		"DE15": {Mode: ChallengeModeHMAC},
+		"DE16": {Mode: ChallengeModeEcho},
+	}}, signing, nil, nil)
+
+	assert.True(t, c.Enabled("DE15"))
+	assert.False(t, c.Enabled("DE17"))
+	assert.False(t, c.Rejects("DE15"))
+
+	assert.Equal(t, "n1", c.expected("DE16", "01s", "n1"))
+
+	hmac := c.expected("DE15", "01s", "n1")
+	assert.Len(t, hmac, 64)
+	assert.NotEqual(t, hmac, c.expected("DE15", "01s", "n2"))
+	assert.NotEqual(t, hmac, c.expected("DE15", "02s", "n1"), "lab secret wins over the course one")
+}
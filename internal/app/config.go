@@ -8,6 +8,9 @@ import (
 	"github.com/pelletier/go-toml/v2"
 
 	"github.com/shrimpsizemoose/trekker/logger"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/anomaly"
+	"github.com/shrimpsizemoose/kanelbulle/internal/scoring"
 )
 
 type HeaderConfig struct {
@@ -26,25 +29,36 @@ type GSheetConfig struct {
 	TimestampRange  string   `toml:"timestamp_range"`
 	Scoring         bool     `toml:"scoring"`
 	LabsList        []string `toml:"labs_list"`
+	// Snapshot publishes a frozen gradebook instead of live data, implies scoring
+	Snapshot string `toml:"snapshot"`
 }
 
 type Config struct {
 	Server struct {
 		Port string `toml:"port"`
 	} `toml:"server"`
-	GSheet map[string][]GSheetConfig `toml:"gsheet"`
+	GSheet  map[string][]GSheetConfig `toml:"gsheet"`
+	Courses Courses                   `toml:"courses"`
 
 	Auth struct {
-		Enabled          bool   `toml:"enabled"`
+		Enabled bool `toml:"enabled"`
+		// Backend keeps tokens and telegram mappings in "redis" (default) or "sql"
+		Backend          string `toml:"backend"`
 		RedisURL         string `toml:"redis_url"`
 		TokenHeader      string `toml:"token_header"`
 		TokenKeyTemplate string `toml:"token_key_template"`
+		// TokenTTL like "2160h", empty means tokens live until the course end_date
+		TokenTTL string `toml:"token_ttl"`
 	} `toml:"auth"`
 
 	API struct {
 		StudentIDHeader string         `toml:"student_id_header"`
 		LabIDHeader     string         `toml:"lab_id_header"`
 		RequiredHeaders []HeaderConfig `toml:"required_headers"`
+		// KeyHeader carries a named api key, see APIKeys
+		KeyHeader string `toml:"key_header"`
+		// RequireKeys stops accepting required_headers in place of an api key
+		RequireKeys bool `toml:"require_keys"`
 	} `toml:"api"`
 
 	Database struct {
@@ -52,16 +66,19 @@ type Config struct {
 	} `toml:"database"`
 
 	Display struct {
-		TimestampFormat string   `toml:"timestamp_format"`
-		EmojiVariants   []string `toml:"emoji_variants"`
+		GoTimestampFormat string   `toml:"go_timestamp_format"`
+		EmojiVariants     []string `toml:"emoji_variants"`
 	} `toml:"display"`
 
-	Scoring struct {
-		LateDaysModifiers  map[int]int `toml:"late_days_modifiers"`
-		DefaultLatePenalty float64     `toml:"default_late_penalty"`
-		MaxLateDays        int         `toml:"max_late_days"`
-		ExtraLatePenalty   int         `toml:"extra_late_penalty"`
-	} `toml:"scoring"`
+	Scoring scoring.Policy `toml:"scoring"`
+
+	Anomalies anomaly.Config `toml:"anomalies"`
+
+	RateLimit RateLimitConfig `toml:"rate_limit"`
+
+	Signing SigningConfig `toml:"signing"`
+
+	Challenges ChallengeConfig `toml:"challenges"`
 
 	Events struct {
 		Start  string `toml:"start"`
@@ -99,6 +116,30 @@ func LoadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("Server port is not specified in config, use a value like :9999")
 	}
 
+	if err := config.Courses.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := config.RateLimit.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := config.Signing.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := config.Challenges.Validate(config.Signing); err != nil {
+		return nil, err
+	}
+
+	if config.API.KeyHeader == "" {
+		config.API.KeyHeader = DefaultAPIKeyHeader
+	}
+
+	if config.Display.GoTimestampFormat == "" {
+		config.Display.GoTimestampFormat = DefaultDisplayFormat
+	}
+
 	logger.Debug.Printf("Loaded scoring config: %+v", config.Scoring)
 
 	return &config, nil
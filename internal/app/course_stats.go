@@ -0,0 +1,129 @@
+package app
+
+import (
+	"sort"
+	"time"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/scoring"
+	"github.com/shrimpsizemoose/kanelbulle/internal/store"
+)
+
+// LabSummary is the overview of one lab across the course
+type LabSummary struct {
+	Lab        string
+	Students   int
+	Finished   int
+	Overridden int
+	InProgress int
+	// Late counts finished labs whose first finish, or the team's, was after
+	// the deadline. Overridden labs are not late.
+	Late int
+	// MedianToFinish is from the first run to the first finish, nil when nobody finished
+	MedianToFinish *time.Duration
+	// Scores is score -> number of students, for finished and overridden labs
+	Scores map[int]int
+	// Slowest are finishers ordered by time to finish, slowest first
+	Slowest []StudentDuration
+	// Stuck are students who started and never finished, most starts first
+	Stuck []StudentAttempts
+}
+
+type StudentDuration struct {
+	Student  string
+	Duration time.Duration
+}
+
+type StudentAttempts struct {
+	Student string
+	Starts  int64
+}
+
+// CompletionRate is the share of students with a finished or overridden lab
+func (s LabSummary) CompletionRate() float64 {
+	if s.Students == 0 {
+		return 0
+	}
+	return float64(s.Finished+s.Overridden) / float64(s.Students)
+}
+
+// SummarizeLabs builds per lab summaries ordered by lab. Statuses, scores and
+// lateness come from the matrix so teams count for every member, times come from stats.
+func SummarizeLabs(matrix scoring.Matrix, stats []store.StatResult) []LabSummary {
+	timings := make(map[string]map[string]store.StatResult)
+	for _, stat := range stats {
+		if timings[stat.Lab] == nil {
+			timings[stat.Lab] = make(map[string]store.StatResult)
+		}
+		timings[stat.Lab][stat.Student] = stat
+	}
+
+	summaries := make(map[string]*LabSummary)
+	for student, results := range matrix {
+		for lab, result := range results {
+			summary := summaries[lab]
+			if summary == nil {
+				summary = &LabSummary{Lab: lab, Scores: make(map[int]int)}
+				summaries[lab] = summary
+			}
+			summary.Students++
+
+			timing, started := timings[lab][student]
+			switch result.Status {
+			case scoring.StatusFinished:
+				summary.Finished++
+				summary.Scores[result.Score]++
+				if result.Late {
+					summary.Late++
+				}
+			case scoring.StatusOverridden:
+				summary.Overridden++
+				summary.Scores[result.Score]++
+			case scoring.StatusInProgress:
+				summary.InProgress++
+				if started {
+					summary.Stuck = append(summary.Stuck, StudentAttempts{Student: student, Starts: timing.StartCount})
+				}
+			}
+
+			if started && timing.FirstFinish != nil && timing.DeltaSeconds != nil {
+				summary.Slowest = append(summary.Slowest, StudentDuration{
+					Student:  student,
+					Duration: time.Duration(*timing.DeltaSeconds) * time.Second,
+				})
+			}
+		}
+	}
+
+	result := make([]LabSummary, 0, len(summaries))
+	for _, summary := range summaries {
+		sort.Slice(summary.Slowest, func(i, j int) bool {
+			if summary.Slowest[i].Duration != summary.Slowest[j].Duration {
+				return summary.Slowest[i].Duration > summary.Slowest[j].Duration
+			}
+			return summary.Slowest[i].Student < summary.Slowest[j].Student
+		})
+		sort.Slice(summary.Stuck, func(i, j int) bool {
+			if summary.Stuck[i].Starts != summary.Stuck[j].Starts {
+				return summary.Stuck[i].Starts > summary.Stuck[j].Starts
+			}
+			return summary.Stuck[i].Student < summary.Stuck[j].Student
+		})
+		summary.MedianToFinish = medianDuration(summary.Slowest)
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Lab < result[j].Lab })
+	return result
+}
+
+// medianDuration expects durations sorted in either direction
+func medianDuration(durations []StudentDuration) *time.Duration {
+	n := len(durations)
+	if n == 0 {
+		return nil
+	}
+	median := durations[n/2].Duration
+	if n%2 == 0 {
+		median = (durations[n/2-1].Duration + durations[n/2].Duration) / 2
+	}
+	return &median
+}
@@ -0,0 +1,55 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/scoring"
+	"github.com/shrimpsizemoose/kanelbulle/internal/store"
+)
+
+func TestSummarizeLabs(t *testing.T) {
+	finish := func(ts int64) *int64 { return &ts }
+
+	matrix := scoring.Matrix{
+		"alice.a": {"01s": {Status: scoring.StatusFinished, Score: 10}, "02s": {Status: scoring.StatusInProgress}},
+		"bob.b":   {"01s": {Status: scoring.StatusFinished, Score: 8, Late: true}, "02s": {Status: scoring.StatusNotStarted}},
+		"carl.c":  {"01s": {Status: scoring.StatusOverridden, Score: 10}, "02s": {Status: scoring.StatusInProgress}},
+		"dana.d":  {"01s": {Status: scoring.StatusFinished, Score: 10}, "02s": {Status: scoring.StatusNotStarted}},
+		// credited by bob.b's team, never sent a finish of their own
+		"erin.e": {"01s": {Status: scoring.StatusFinished, Score: 8, Late: true}, "02s": {Status: scoring.StatusNotStarted}},
+	}
+	stats := []store.StatResult{
+		{Student: "alice.a", Lab: "01s", StartCount: 1, FirstRun: 0, FirstFinish: finish(3600), DeltaSeconds: finish(3600)},
+		{Student: "bob.b", Lab: "01s", StartCount: 2, FirstRun: 0, FirstFinish: finish(7200 + 100), DeltaSeconds: finish(7200 + 100)},
+		// late, but the override wins
+		{Student: "carl.c", Lab: "01s", StartCount: 1, FirstRun: 0, FirstFinish: finish(9000), DeltaSeconds: finish(9000)},
+		{Student: "dana.d", Lab: "01s", StartCount: 1, FirstRun: 0, FirstFinish: finish(1800), DeltaSeconds: finish(1800)},
+		{Student: "alice.a", Lab: "02s", StartCount: 5, FirstRun: 0},
+		{Student: "carl.c", Lab: "02s", StartCount: 2, FirstRun: 0},
+	}
+
+	summaries := SummarizeLabs(matrix, stats)
+	require.Len(t, summaries, 2)
+
+	first := summaries[0]
+	assert.Equal(t, "01s", first.Lab)
+	assert.Equal(t, 5, first.Students)
+	assert.Equal(t, 4, first.Finished)
+	assert.Equal(t, 1, first.Overridden)
+	assert.Equal(t, 1.0, first.CompletionRate())
+	assert.Equal(t, 2, first.Late)
+	assert.Equal(t, map[int]int{10: 3, 8: 2}, first.Scores)
+	require.NotNil(t, first.MedianToFinish)
+	assert.Equal(t, time.Hour+30*time.Minute+50*time.Second, *first.MedianToFinish)
+	assert.Equal(t, "carl.c", first.Slowest[0].Student)
+
+	second := summaries[1]
+	assert.Equal(t, 2, second.InProgress)
+	assert.Zero(t, second.CompletionRate())
+	assert.Nil(t, second.MedianToFinish)
+	assert.Equal(t, []StudentAttempts{{"alice.a", 5}, {"carl.c", 2}}, second.Stuck)
+}
@@ -0,0 +1,88 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/scoring"
+	"github.com/shrimpsizemoose/kanelbulle/internal/store"
+)
+
+type CourseConfig struct {
+	Timezone string `toml:"timezone"`
+	// EndDate stops every token of the course from validating after it,
+	// same format as lab deadlines in the course timezone
+	EndDate string `toml:"end_date"`
+	// Scoring replaces the global [scoring] policy for this course
+	Scoring *scoring.Policy `toml:"scoring"`
+}
+
+// Courses holds per-course settings keyed by course code
+type Courses map[string]CourseConfig
+
+// Validate checks that all configured timezones and scoring strategies can be loaded
+func (c Courses) Validate() error {
+	for course, cfg := range c {
+		if cfg.Timezone != "" {
+			if _, err := time.LoadLocation(cfg.Timezone); err != nil {
+				return fmt.Errorf("invalid timezone %q for course %s: %w", cfg.Timezone, course, err)
+			}
+		}
+		if cfg.EndDate != "" {
+			if _, err := ParseDeadline(cfg.EndDate, c.Location(course)); err != nil {
+				return fmt.Errorf("invalid end_date %q for course %s: %w", cfg.EndDate, course, err)
+			}
+		}
+		if cfg.Scoring != nil {
+			if _, err := scoring.NewStrategy(*cfg.Scoring); err != nil {
+				return fmt.Errorf("invalid scoring for course %s: %w", course, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Location returns course timezone, UTC if nothing is configured
+func (c Courses) Location(course string) *time.Location {
+	cfg, ok := c[course]
+	if !ok || cfg.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(cfg.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// EndTime returns when the course is over, ok is false for courses without an end date
+func (c Courses) EndTime(course string) (time.Time, bool) {
+	cfg, ok := c[course]
+	if !ok || cfg.EndDate == "" {
+		return time.Time{}, false
+	}
+	end, err := ParseDeadline(cfg.EndDate, c.Location(course))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return end, true
+}
+
+// NewCourseGrader builds a grader with the default policy and per-course overrides
+func NewCourseGrader(store store.ScoreStore, defaults scoring.Policy, courses Courses) (*scoring.Grader, error) {
+	grader, err := scoring.NewGraderWithPolicy(store, defaults)
+	if err != nil {
+		return nil, fmt.Errorf("invalid default scoring policy: %w", err)
+	}
+
+	for course, cfg := range courses {
+		if cfg.Scoring == nil {
+			continue
+		}
+		if err := grader.SetCoursePolicy(course, *cfg.Scoring); err != nil {
+			return nil, err
+		}
+	}
+
+	return grader, nil
+}
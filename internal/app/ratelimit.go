@@ -0,0 +1,239 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type RateLimit struct {
+	Limit  int    `toml:"limit"`
+	Window string `toml:"window"`
+}
+
+// RateLimitRule limits events per student token and per client IP, nil means no limit on that side
+type RateLimitRule struct {
+	Student *RateLimit `toml:"student"`
+	IP      *RateLimit `toml:"ip"`
+}
+
+type CourseRateLimits struct {
+	RateLimitRule
+	// Events overrides course limits for a single event type
+	Events map[string]RateLimitRule `toml:"events"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool `toml:"enabled"`
+	// TrustForwardedFor takes the client IP from X-Forwarded-For, only safe behind a proxy
+	TrustForwardedFor bool                        `toml:"trust_forwarded_for"`
+	Default           RateLimitRule               `toml:"default"`
+	Courses           map[string]CourseRateLimits `toml:"courses"`
+}
+
+const (
+	RateScopeStudent = "student"
+	RateScopeIP      = "ip"
+)
+
+// Rule picks the most specific limit for each scope: course and event type,
+// then course, then the default
+func (c RateLimitConfig) Rule(course, eventType string) RateLimitRule {
+	rule := c.Default
+	courseLimits, ok := c.Courses[course]
+	if !ok {
+		return rule
+	}
+
+	for _, r := range []RateLimitRule{courseLimits.RateLimitRule, courseLimits.Events[eventType]} {
+		if r.Student != nil {
+			rule.Student = r.Student
+		}
+		if r.IP != nil {
+			rule.IP = r.IP
+		}
+	}
+	return rule
+}
+
+func (c RateLimitConfig) Validate() error {
+	check := func(where string, r RateLimitRule) error {
+		for _, l := range []*RateLimit{r.Student, r.IP} {
+			if l == nil {
+				continue
+			}
+			if l.Limit <= 0 {
+				return fmt.Errorf("rate limit %s: limit must be positive", where)
+			}
+			if d, err := time.ParseDuration(l.Window); err != nil || d < time.Millisecond {
+				return fmt.Errorf("rate limit %s: invalid window %q, use a duration like 1m", where, l.Window)
+			}
+		}
+		return nil
+	}
+
+	if err := check("default", c.Default); err != nil {
+		return err
+	}
+	for course, cl := range c.Courses {
+		if err := check(course, cl.RateLimitRule); err != nil {
+			return err
+		}
+		for event, r := range cl.Events {
+			if err := check(course+"/"+event, r); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sliding window over a sorted set of request times in ms, returns 0 when the request
+// fits or how many ms to wait until the oldest one leaves the window
+var slidingWindowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+if redis.call('ZCARD', KEYS[1]) < limit then
+	redis.call('ZADD', KEYS[1], now, ARGV[4])
+	redis.call('PEXPIRE', KEYS[1], window)
+	return 0
+end
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+return tonumber(oldest[2]) + window - now
+`)
+
+// EventLimiter decides whether an event may go through, RateLimiter is the
+// redis backed one
+type EventLimiter interface {
+	Allow(ctx context.Context, course, eventType, student, ip string) error
+	ClientIP(r *http.Request) string
+	Close() error
+}
+
+type RateLimiter struct {
+	config RateLimitConfig
+	redis  *redis.Client
+	// ownsRedis is set when the limiter had to open its own connection
+	ownsRedis bool
+}
+
+func NewRateLimiter(config RateLimitConfig, client *redis.Client) *RateLimiter {
+	return &RateLimiter{config: config, redis: client}
+}
+
+// newServiceRateLimiter connects to auth.redis_url, the limiter needs redis
+// whatever the token backend is
+func newServiceRateLimiter(config *Config) (*RateLimiter, error) {
+	if !config.RateLimit.Enabled {
+		return nil, nil
+	}
+
+	opt, err := redis.ParseURL(config.Auth.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redis URL: %w", err)
+	}
+	client := redis.NewClient(opt)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+
+	limiter := NewRateLimiter(config.RateLimit, client)
+	limiter.ownsRedis = true
+	return limiter, nil
+}
+
+func (l *RateLimiter) Close() error {
+	if l.ownsRedis {
+		return l.redis.Close()
+	}
+	return nil
+}
+
+// RateLimited is returned when a request is over the limit
+type RateLimited struct {
+	Scope      string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimited) Error() string {
+	return fmt.Sprintf("rate limited by %s, retry after %s", e.Scope, e.RetryAfter)
+}
+
+// Allow records the request against both scopes and returns *RateLimited when
+// one is exhausted. An empty student or ip skips that scope, so the ip can be
+// checked before the student is known.
+func (l *RateLimiter) Allow(ctx context.Context, course, eventType, student, ip string) error {
+	rule := l.config.Rule(course, eventType)
+
+	checks := []struct {
+		scope string
+		id    string
+		limit *RateLimit
+	}{
+		{RateScopeIP, ip, rule.IP},
+		{RateScopeStudent, student, rule.Student},
+	}
+	for _, c := range checks {
+		if c.limit == nil || c.id == "" {
+			continue
+		}
+		key := fmt.Sprintf("ratelimit:%s:%s:%s:%s", course, eventType, c.scope, c.id)
+		wait, err := l.hit(ctx, key, c.limit)
+		if err != nil {
+			return fmt.Errorf("failed to check %s rate limit: %w", c.scope, err)
+		}
+		if wait > 0 {
+			return &RateLimited{Scope: c.scope, RetryAfter: wait}
+		}
+	}
+	return nil
+}
+
+func (l *RateLimiter) hit(ctx context.Context, key string, limit *RateLimit) (time.Duration, error) {
+	window, err := time.ParseDuration(limit.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return 0, fmt.Errorf("failed to generate request id: %w", err)
+	}
+	now := time.Now().UnixMilli()
+	member := fmt.Sprintf("%d-%s", now, hex.EncodeToString(suffix))
+
+	wait, err := slidingWindowScript.Run(ctx, l.redis, []string{key},
+		now, window.Milliseconds(), limit.Limit, member,
+	).Int64()
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(wait) * time.Millisecond, nil
+}
+
+// ClientIP is the remote address, or the first X-Forwarded-For hop when trusted
+func (l *RateLimiter) ClientIP(r *http.Request) string {
+	return clientIP(r, l.config.TrustForwardedFor)
+}
+
+func clientIP(r *http.Request, trustForwarded bool) string {
+	if trustForwarded {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
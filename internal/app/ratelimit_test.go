@@ -0,0 +1,112 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+func TestRateLimitConfig_Rule(t *testing.T) {
+	perMinute := &RateLimit{Limit: 60, Window: "1m"}
+	finishes := &RateLimit{Limit: 3, Window: "1m"}
+	courseIP := &RateLimit{Limit: 500, Window: "1m"}
+
+	cfg := RateLimitConfig{
+		Default: RateLimitRule{Student: perMinute, IP: perMinute},
+		Courses: map[string]CourseRateLimits{
+			"DE15": {
+				RateLimitRule: RateLimitRule{IP: courseIP},
+				Events: map[string]RateLimitRule{
+					"100_lab_finish": {Student: finishes},
+				},
+			},
+		},
+	}
+	assert.NoError(t, cfg.Validate())
+
+	assert.Equal(t, RateLimitRule{Student: perMinute, IP: perMinute}, cfg.Rule("DE16", "100_lab_finish"))
+	assert.Equal(t, RateLimitRule{Student: perMinute, IP: courseIP}, cfg.Rule("DE15", "000_lab_start"))
+	assert.Equal(t, RateLimitRule{Student: finishes, IP: courseIP}, cfg.Rule("DE15", "100_lab_finish"))
+
+	cfg.Default.IP = &RateLimit{Limit: 1, Window: "soon"}
+	assert.Error(t, cfg.Validate())
+}
+
+func TestRateLimiter_ClientIP(t *testing.T) {
+	r := httptest.NewRequest("POST", "/api/v1/DE15/analytics", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4, 10.0.0.1")
+
+	assert.Equal(t, "10.0.0.1", NewRateLimiter(RateLimitConfig{}, nil).ClientIP(r))
+	assert.Equal(t, "1.2.3.4", NewRateLimiter(RateLimitConfig{TrustForwardedFor: true}, nil).ClientIP(r))
+}
+
+// newTestRedis starts redis in docker like the postgres store tests
+func newTestRedis(t *testing.T) *redis.Client {
+	if testing.Short() {
+		t.Skip("needs docker")
+	}
+	ctx := context.Background()
+
+	container, err := testcontainers.GenericContainer(ctx, testcontainers.GenericContainerRequest{
+		ContainerRequest: testcontainers.ContainerRequest{
+			Image:        "redis:7-alpine",
+			ExposedPorts: []string{"6379/tcp"},
+			WaitingFor:   wait.ForLog("Ready to accept connections").WithStartupTimeout(10 * time.Second),
+		},
+		Started: true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { container.Terminate(ctx) })
+
+	endpoint, err := container.PortEndpoint(ctx, "6379/tcp", "")
+	require.NoError(t, err)
+
+	client := redis.NewClient(&redis.Options{Addr: endpoint})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewRateLimiter(RateLimitConfig{
+		Default: RateLimitRule{
+			IP:      &RateLimit{Limit: 2, Window: "1m"},
+			Student: &RateLimit{Limit: 1, Window: "1m"},
+		},
+	}, newTestRedis(t))
+
+	scopeOf := func(err error) string {
+		var limited *RateLimited
+		if !errors.As(err, &limited) {
+			return ""
+		}
+		assert.Greater(t, limited.RetryAfter, time.Duration(0))
+		assert.LessOrEqual(t, limited.RetryAfter, time.Minute)
+		return limited.Scope
+	}
+
+	require.NoError(t, limiter.Allow(ctx, "DE15", "100_lab_finish", "alice.a", "10.0.0.1"))
+	assert.Equal(t, RateScopeStudent, scopeOf(limiter.Allow(ctx, "DE15", "100_lab_finish", "alice.a", "10.0.0.1")))
+
+	// the ip is checked first, a request it stops never counts against the student
+	assert.Equal(t, RateScopeIP, scopeOf(limiter.Allow(ctx, "DE15", "100_lab_finish", "bob.b", "10.0.0.1")))
+	require.NoError(t, limiter.Allow(ctx, "DE15", "100_lab_finish", "bob.b", "10.0.0.2"))
+
+	// every event type and course has counters of its own
+	require.NoError(t, limiter.Allow(ctx, "DE15", "000_lab_start", "alice.a", "10.0.0.3"))
+	require.NoError(t, limiter.Allow(ctx, "DE16", "100_lab_finish", "alice.a", "10.0.0.3"))
+
+	// without a student only the ip is limited
+	require.NoError(t, limiter.Allow(ctx, "DE15", "100_lab_finish", "", "10.0.0.4"))
+	require.NoError(t, limiter.Allow(ctx, "DE15", "100_lab_finish", "", "10.0.0.4"))
+	assert.Equal(t, RateScopeIP, scopeOf(limiter.Allow(ctx, "DE15", "100_lab_finish", "", "10.0.0.4")))
+}
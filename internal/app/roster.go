@@ -0,0 +1,48 @@
+package app
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/shrimpsizemoose/trekker/logger"
+)
+
+const rosterTimeout = 5 * time.Second
+
+// Roster lists student IDs enrolled in a course
+type Roster interface {
+	CourseRoster(ctx context.Context, course string) ([]string, error)
+}
+
+// fetchRoster never fails, without a roster only students with activity are scored
+func fetchRoster(roster Roster, course string) []string {
+	if roster == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), rosterTimeout)
+	defer cancel()
+
+	students, err := roster.CourseRoster(ctx, course)
+	if err != nil {
+		logger.Error.Printf("Failed to fetch roster for course %s: %v", course, err)
+		return nil
+	}
+	return students
+}
+
+// CourseRoster returns student IDs from the telegram lookup hash filled by /new_course and /map_student
+func (tm *RedisTokenManager) CourseRoster(ctx context.Context, course string) ([]string, error) {
+	mappings, err := tm.FetchCourseStudents(ctx, course)
+	if err != nil {
+		return nil, err
+	}
+
+	students := make([]string, 0, len(mappings))
+	for _, studentID := range mappings {
+		students = append(students, studentID)
+	}
+	sort.Strings(students)
+	return students, nil
+}
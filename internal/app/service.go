@@ -1,23 +1,42 @@
 package app
 
 import (
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/go-co-op/gocron"
 	"github.com/shrimpsizemoose/trekker/logger"
 
 	"github.com/shrimpsizemoose/kanelbulle/internal/metrics"
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
 	"github.com/shrimpsizemoose/kanelbulle/internal/scoring"
 	"github.com/shrimpsizemoose/kanelbulle/internal/store"
 )
 
 type Service struct {
-	Config *Config
-	Store  store.ScoreStore
-	Auth   *Auth
-	Grader *scoring.Grader
+	Config    *Config
+	Store     store.ScoreStore
+	Auth      *Auth
+	Grader    *scoring.Grader
+	Snapshots *Snapshots
+	Roster    Roster
+	// Tokens is nil when auth is disabled and tokens live in redis
+	Tokens  TokenManager
+	APIKeys *APIKeys
+	// RateLimiter is nil unless [rate_limit] is enabled
+	RateLimiter EventLimiter
+	// Signatures is nil unless some course is in signed mode
+	Signatures *SignatureVerifier
+	// Challenges is nil unless some course links starts and finishes with a nonce
+	Challenges *Challenges
+
+	// anomalyJob is set by StartAnomalyJob and stopped on Close
+	anomalyJob *gocron.Scheduler
 }
 
 func NewService(configPath string) (*Service, error) {
@@ -31,24 +50,68 @@ func NewService(configPath string) (*Service, error) {
 		return nil, fmt.Errorf("failed to init store: %w", err)
 	}
 
-	auth, err := NewAuth(config)
+	apiKeys, err := NewAPIKeys(store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init api keys: %w", err)
+	}
+
+	// the sql backend is free to run, so the roster is available even without auth
+	var tokens TokenManager
+	if config.Auth.Enabled || config.Auth.Backend == TokenBackendSQL {
+		tokens, err = NewTokenManager(config.Auth.Backend, config.Auth.RedisURL, config.Auth.TokenKeyTemplate, store)
+		if err != nil {
+			return nil, fmt.Errorf("failed to init tokens: %w", err)
+		}
+	}
+
+	auth, err := NewAuth(config, tokens)
 	if err != nil {
 		return nil, fmt.Errorf("failed to init auth: %w", err)
 	}
 
-	grader := scoring.NewGrader(
-		store,
-		config.Scoring.LateDaysModifiers,
-		config.Scoring.DefaultLatePenalty,
-		config.Scoring.MaxLateDays,
-		config.Scoring.ExtraLatePenalty,
-	)
+	grader, err := NewCourseGrader(store, config.Scoring, config.Courses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init grader: %w", err)
+	}
+
+	limiter, err := newServiceRateLimiter(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init rate limiter: %w", err)
+	}
+
+	signatures, err := newServiceSignatureVerifier(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init signature verifier: %w", err)
+	}
+
+	challenges, err := newServiceChallenges(config, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init challenges: %w", err)
+	}
+
+	// roster lives next to the tokens, a nil TokenManager must not become a non-nil Roster
+	var roster Roster
+	if tokens != nil {
+		roster = tokens
+	}
+	// same for a disabled limiter
+	var eventLimiter EventLimiter
+	if limiter != nil {
+		eventLimiter = limiter
+	}
 
 	return &Service{
-		Config: config,
-		Store:  store,
-		Auth:   auth,
-		Grader: grader,
+		Config:      config,
+		Store:       store,
+		Auth:        auth,
+		Grader:      grader,
+		Snapshots:   NewSnapshots(store, grader, roster),
+		Roster:      roster,
+		Tokens:      tokens,
+		APIKeys:     apiKeys,
+		RateLimiter: eventLimiter,
+		Signatures:  signatures,
+		Challenges:  challenges,
 	}, nil
 }
 
@@ -64,72 +127,153 @@ type LabStats struct {
 	} `json:"human_dttms,omitempty"`
 }
 
-func (s *Service) ValidateAuthAndStudent(r *http.Request, course, student string) error {
+// AuthenticateStudent returns who the event is from. With auth enabled the
+// bearer token names the student and the claimed one, from the student header,
+// only has to agree with it. Without auth the claimed student is taken as is.
+func (s *Service) AuthenticateStudent(r *http.Request, course, claimed string) (string, error) {
 	if !s.Config.Auth.Enabled {
-		return nil
+		if claimed == "" {
+			return "", ErrStudentMissing
+		}
+		return claimed, nil
 	}
 
 	authHeader := r.Header.Get(s.Auth.tokenHeader)
 	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return fmt.Errorf("Invalid authorization header format")
+		metrics.AuthFailuresTotal.WithLabelValues(course, "missing_header").Inc()
+		return "", fmt.Errorf("Invalid authorization header format")
 	}
 	token := strings.TrimPrefix(authHeader, "Bearer ")
 
-	return s.Auth.ValidateToken(r.Context(), course, student, token)
+	student, err := s.Auth.ResolveStudent(r.Context(), course, claimed, token)
+	if err == nil {
+		err = s.Auth.ValidateToken(r.Context(), course, student, token)
+	}
+	if err != nil {
+		metrics.AuthFailuresTotal.WithLabelValues(course, authFailureReason(err)).Inc()
+		return "", err
+	}
+	return student, nil
+}
+
+// RecordTokenUse counts a request accepted with the student token. Usage is
+// recorded once the request did what it asked for, failures only log.
+func (s *Service) RecordTokenUse(r *http.Request, course, student, lab, eventType string) {
+	if !s.Config.Auth.Enabled || s.Tokens == nil {
+		return
+	}
+
+	use := models.TokenUse{
+		Lab:       lab,
+		EventType: eventType,
+		IP:        clientIP(r, s.Config.RateLimit.TrustForwardedFor),
+		UserAgent: r.UserAgent(),
+		Time:      time.Now().UTC(),
+	}
+	if err := s.Tokens.RecordTokenUse(r.Context(), course, student, use); err != nil {
+		logger.Error.Printf("Failed to record token use of %s/%s: %v", course, student, err)
+	}
+}
+
+// VerifySignature checks the HMAC of an event for courses in signed mode
+func (s *Service) VerifySignature(r *http.Request, course, lab, student string, body []byte) error {
+	if s.Signatures == nil {
+		return nil
+	}
+	if err := s.Signatures.Verify(r.Context(), r, course, lab, student, body); err != nil {
+		metrics.AuthFailuresTotal.WithLabelValues(course, signatureFailureReason(err)).Inc()
+		return err
+	}
+	return nil
+}
+
+// Authorize checks the api key of the request against the scope of the route
+// and returns the key. Requests without a key still get into HeaderScopes with
+// the required headers unless api.require_keys is set, the key is nil then.
+func (s *Service) Authorize(r *http.Request, scope Scope, course string) (*models.APIKey, error) {
+	if plaintext := r.Header.Get(s.Config.API.KeyHeader); plaintext != "" {
+		key, err := s.APIKeys.Authorize(plaintext, scope, course)
+		if err != nil {
+			metrics.AuthFailuresTotal.WithLabelValues(course, apiKeyFailureReason(err)).Inc()
+			return nil, err
+		}
+		return key, nil
+	}
+
+	if s.Config.API.RequireKeys || !s.ValidateHeaders(r.Header) {
+		metrics.AuthFailuresTotal.WithLabelValues(course, "api_key_missing").Inc()
+		return nil, ErrAPIKeyMissing
+	}
+	if !slices.Contains(HeaderScopes, scope) {
+		metrics.AuthFailuresTotal.WithLabelValues(course, "api_key_forbidden").Inc()
+		return nil, fmt.Errorf("%w: scope %s needs a named key", ErrAPIKeyForbidden, scope)
+	}
+	return nil, nil
 }
 
+func apiKeyFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrAPIKeyInvalid):
+		return "api_key_invalid"
+	case errors.Is(err, ErrAPIKeyForbidden):
+		return "api_key_forbidden"
+	default:
+		return "error"
+	}
+}
+
+// ValidateHeaders compares the required headers exactly and in constant time
 func (s *Service) ValidateHeaders(headers map[string][]string) bool {
 	for _, required := range s.Config.API.RequiredHeaders {
 		value := headers[http.CanonicalHeaderKey(required.Name)]
-		if len(value) == 0 || !strings.EqualFold(value[0], required.Value) {
+		if len(value) == 0 || subtle.ConstantTimeCompare([]byte(value[0]), []byte(required.Value)) != 1 {
 			return false
 		}
 	}
 	return true
 }
 
-func (s *Service) GetScoring(course string) (map[string]map[string]int, error) {
-	finishEvents, err := s.Store.GetCourseEventsByType(course, "100_lab_finish")
+// GetScoring returns a result for every student on the roster and every lab of the course
+func (s *Service) GetScoring(course string) (scoring.Matrix, error) {
+	matrix, err := s.Grader.CourseMatrix(course, fetchRoster(s.Roster, course))
 	if err != nil {
-		return nil, fmt.Errorf("failed to get entries: %w", err)
+		return nil, err
 	}
 
-	scores := make(map[string]map[string]int)
-
-	for _, entry := range finishEvents {
-		if scores[entry.Student] == nil {
-			scores[entry.Student] = make(map[string]int)
-		}
-		score, err := s.Grader.ScoreForStudent(course, entry.Lab, entry.Student)
-		if err != nil {
-			logger.Error.Printf("failed to calculate score for student %s lab %s: %v",
-				entry.Student,
-				entry.Lab,
-				err,
-			)
-			continue
+	for _, labs := range matrix {
+		for lab, result := range labs {
+			if result.Status == scoring.StatusFinished || result.Status == scoring.StatusOverridden {
+				metrics.LabScoreHistogram.WithLabelValues(course, lab).Observe(float64(result.Score))
+			}
 		}
-
-		scores[entry.Student][entry.Lab] = score
-
-		metrics.LabScoreHistogram.WithLabelValues(course, entry.Lab).Observe(float64(score))
 	}
 
-	return scores, nil
+	return matrix, nil
 }
 
-func (s *Service) GetDetailedStats(course string, includeHumanDttm bool) (map[string]map[string]*LabStats, error) {
+// GetDetailedStats returns stats per student, or per team when byTeam is set.
+// Students without a team for a lab stay under their own name.
+func (s *Service) GetDetailedStats(course string, includeHumanDttm, byTeam bool) (map[string]map[string]*LabStats, error) {
 	results, err := s.Store.GetDetailedStats(
 		course,
 		s.Config.Events.Start,
 		s.Config.Events.Finish,
-		s.Config.Display.TimestampFormat,
-		includeHumanDttm,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	if byTeam {
+		members, err := s.Store.ListTeamMembers(course)
+		if err != nil {
+			return nil, err
+		}
+		results = aggregateTeamStats(results, members)
+	}
+
+	loc := s.Config.Courses.Location(course)
+	layout := s.Config.Display.GoTimestampFormat
+
 	stats := make(map[string]map[string]*LabStats)
 	for _, r := range results {
 		if stats[r.Student] == nil {
@@ -150,13 +294,17 @@ func (s *Service) GetDetailedStats(course string, includeHumanDttm bool) (map[st
 				FirstFinish *string `json:"first_finish,omitempty"`
 				Delta       string  `json:"delta_first_run_first_finish,omitempty"`
 			}{
-				FirstRun:    *r.HumanFirstRun,
-				FirstFinish: r.HumanFirstFinish,
+				FirstRun: FormatTimestamp(r.FirstRun, loc, layout),
+			}
+
+			if r.FirstFinish != nil {
+				firstFinish := FormatTimestamp(*r.FirstFinish, loc, layout)
+				stat.HumanDttms.FirstFinish = &firstFinish
 			}
 
 			if r.DeltaSeconds != nil {
 				duration := time.Duration(*r.DeltaSeconds) * time.Second
-				stat.HumanDttms.Delta = s.formatDuration(duration)
+				stat.HumanDttms.Delta = FormatDuration(duration)
 			}
 		}
 
@@ -166,27 +314,36 @@ func (s *Service) GetDetailedStats(course string, includeHumanDttm bool) (map[st
 	return stats, nil
 }
 
-func (s *Service) formatDuration(d time.Duration) string {
-	days := d / (24 * time.Hour)
-	d = d % (24 * time.Hour)
-	hours := d / time.Hour
-	d = d % time.Hour
-	minutes := d / time.Minute
-
-	if days > 0 {
-		return fmt.Sprintf("%dd%dh%dm", days, hours, minutes)
-	}
-	return fmt.Sprintf("%dh%dm", hours, minutes)
-}
-
 func (s *Service) Close() error {
 	var errs []error
 
+	// the job uses the store, so it goes first
+	if s.anomalyJob != nil {
+		s.anomalyJob.Stop()
+	}
+
 	if err := s.Store.Close(); err != nil {
 		errs = append(errs, fmt.Errorf("store: %w", err))
 	}
-	if err := s.Auth.Close(); err != nil {
-		errs = append(errs, fmt.Errorf("auth: %w", err))
+	if s.Tokens != nil {
+		if err := s.Tokens.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("tokens: %w", err))
+		}
+	}
+	if s.RateLimiter != nil {
+		if err := s.RateLimiter.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("rate limiter: %w", err))
+		}
+	}
+	if s.Signatures != nil {
+		if err := s.Signatures.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("signatures: %w", err))
+		}
+	}
+	if s.Challenges != nil {
+		if err := s.Challenges.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("challenges: %w", err))
+		}
 	}
 
 	if len(errs) > 0 {
@@ -194,3 +351,47 @@ func (s *Service) Close() error {
 	}
 	return nil
 }
+
+func aggregateTeamStats(results []store.StatResult, members []models.TeamMember) []store.StatResult {
+	teams := make(map[string]string)
+	for _, m := range members {
+		teams[m.Lab+"/"+m.Student] = TeamKey(m.Team)
+	}
+
+	var aggregated []store.StatResult
+	index := make(map[string]int)
+	for _, r := range results {
+		team, ok := teams[r.Lab+"/"+r.Student]
+		if !ok {
+			aggregated = append(aggregated, r)
+			continue
+		}
+
+		key := r.Course + "/" + r.Lab + "/" + team
+		i, seen := index[key]
+		if !seen {
+			r.Student = team
+			index[key] = len(aggregated)
+			aggregated = append(aggregated, r)
+			continue
+		}
+
+		agg := &aggregated[i]
+		agg.StartCount += r.StartCount
+		if r.FirstRun < agg.FirstRun {
+			agg.FirstRun = r.FirstRun
+		}
+		if r.FirstFinish != nil && (agg.FirstFinish == nil || *r.FirstFinish < *agg.FirstFinish) {
+			agg.FirstFinish = r.FirstFinish
+		}
+	}
+
+	for i := range aggregated {
+		agg := &aggregated[i]
+		if _, ok := index[agg.Course+"/"+agg.Lab+"/"+agg.Student]; ok && agg.FirstFinish != nil {
+			delta := *agg.FirstFinish - agg.FirstRun
+			agg.DeltaSeconds = &delta
+		}
+	}
+	return aggregated
+}
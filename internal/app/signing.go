@@ -0,0 +1,211 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	SigningModeBearer = "bearer"
+	SigningModeSigned = "signed"
+
+	SignatureHeader = "X-Signature"
+	TimestampHeader = "X-Timestamp"
+	NonceHeader     = "X-Nonce"
+
+	defaultMaxSkew = 5 * time.Minute
+)
+
+var (
+	ErrSignatureMissing = errors.New("signature required")
+	ErrSignatureInvalid = errors.New("invalid signature")
+	ErrSignatureStale   = errors.New("signature timestamp out of range")
+	ErrNonceReused      = errors.New("nonce already used")
+)
+
+// CourseSigning switches a course between bearer-only and signed ingestion.
+// Labs with their own secret ignore the course secret.
+type CourseSigning struct {
+	Mode   string            `toml:"mode"`
+	Secret string            `toml:"secret"`
+	Labs   map[string]string `toml:"labs"`
+}
+
+type SigningConfig struct {
+	// MaxSkew like "5m" is how far the checker clock may drift, nonces are kept twice as long
+	MaxSkew string                   `toml:"max_skew"`
+	Courses map[string]CourseSigning `toml:"courses"`
+}
+
+func (c SigningConfig) Validate() error {
+	if c.MaxSkew != "" {
+		if d, err := time.ParseDuration(c.MaxSkew); err != nil || d <= 0 {
+			return fmt.Errorf("signing: invalid max_skew %q, use a duration like 5m", c.MaxSkew)
+		}
+	}
+	for course, cs := range c.Courses {
+		switch cs.Mode {
+		case "", SigningModeBearer:
+		case SigningModeSigned:
+			if cs.Secret == "" && len(cs.Labs) == 0 {
+				return fmt.Errorf("signing: course %s is signed but has no secret", course)
+			}
+		default:
+			return fmt.Errorf("signing: unknown mode %q for course %s, expected %q or %q",
+				cs.Mode, course, SigningModeBearer, SigningModeSigned)
+		}
+	}
+	return nil
+}
+
+// Signed tells whether events of the course must carry a signature
+func (c SigningConfig) Signed(course string) bool {
+	return c.Courses[course].Mode == SigningModeSigned
+}
+
+// Secret is the lab secret, or the course secret for labs without one
+func (c SigningConfig) Secret(course, lab string) string {
+	cs := c.Courses[course]
+	if secret, ok := cs.Labs[lab]; ok {
+		return secret
+	}
+	return cs.Secret
+}
+
+func (c SigningConfig) maxSkew() time.Duration {
+	if d, err := time.ParseDuration(c.MaxSkew); err == nil && d > 0 {
+		return d
+	}
+	return defaultMaxSkew
+}
+
+// SignEvent is the hex HMAC-SHA256 a lab checker sends in X-Signature,
+// timestamp is unix seconds as sent in X-Timestamp
+func SignEvent(secret, course, lab, student, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, part := range []string{course, lab, student, timestamp, nonce} {
+		mac.Write([]byte(part))
+		mac.Write([]byte("\n"))
+	}
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignatureVerifier checks signed events and remembers their nonces in redis
+type SignatureVerifier struct {
+	config SigningConfig
+	redis  *redis.Client
+	now    func() time.Time
+}
+
+func NewSignatureVerifier(config SigningConfig, client *redis.Client) *SignatureVerifier {
+	return &SignatureVerifier{config: config, redis: client, now: time.Now}
+}
+
+// newServiceSignatureVerifier is nil unless some course is signed, nonces need redis
+func newServiceSignatureVerifier(config *Config) (*SignatureVerifier, error) {
+	signed := false
+	for course := range config.Signing.Courses {
+		signed = signed || config.Signing.Signed(course)
+	}
+	if !signed {
+		return nil, nil
+	}
+
+	opt, err := redis.ParseURL(config.Auth.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse redis URL: %w", err)
+	}
+	client := redis.NewClient(opt)
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	}
+	return NewSignatureVerifier(config.Signing, client), nil
+}
+
+func (v *SignatureVerifier) Close() error {
+	return v.redis.Close()
+}
+
+// Verify passes events of bearer-only courses, for signed courses it returns
+// one of the ErrSignature* errors or ErrNonceReused when the event can't be trusted
+func (v *SignatureVerifier) Verify(ctx context.Context, r *http.Request, course, lab, student string, body []byte) error {
+	if !v.config.Signed(course) {
+		return nil
+	}
+
+	nonce, err := v.checkSignature(r.Header, course, lab, student, body)
+	if err != nil {
+		return err
+	}
+
+	// a nonce outlives the accepted skew on both sides, so a replay is either stale or reused
+	key := fmt.Sprintf("nonce:%s:%s", course, nonce)
+	fresh, err := v.redis.SetNX(ctx, key, student, 2*v.config.maxSkew()).Result()
+	if err != nil {
+		return fmt.Errorf("failed to record nonce: %w", err)
+	}
+	if !fresh {
+		return ErrNonceReused
+	}
+	return nil
+}
+
+// checkSignature validates everything but the nonce history and returns the nonce
+func (v *SignatureVerifier) checkSignature(headers http.Header, course, lab, student string, body []byte) (string, error) {
+	signature := headers.Get(SignatureHeader)
+	timestamp := headers.Get(TimestampHeader)
+	nonce := headers.Get(NonceHeader)
+	if signature == "" || timestamp == "" || nonce == "" {
+		return "", ErrSignatureMissing
+	}
+
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", ErrSignatureInvalid
+	}
+	skew := v.now().Sub(time.Unix(sent, 0))
+	if skew > v.config.maxSkew() || skew < -v.config.maxSkew() {
+		return "", ErrSignatureStale
+	}
+
+	secret := v.config.Secret(course, lab)
+	if secret == "" {
+		return "", fmt.Errorf("%w: no secret for lab %s", ErrSignatureInvalid, lab)
+	}
+	expected := SignEvent(secret, course, lab, student, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return "", ErrSignatureInvalid
+	}
+	return nonce, nil
+}
+
+// IsSignatureError is false for failures of the verifier itself, like redis being down
+func IsSignatureError(err error) bool {
+	return signatureFailureReason(err) != "error"
+}
+
+// signatureFailureReason is the metric label for a Verify error
+func signatureFailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrSignatureMissing):
+		return "signature_missing"
+	case errors.Is(err, ErrSignatureInvalid):
+		return "signature_invalid"
+	case errors.Is(err, ErrSignatureStale):
+		return "signature_stale"
+	case errors.Is(err, ErrNonceReused):
+		return "nonce_reused"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,68 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigningConfig(t *testing.T) {
+	cfg := SigningConfig{
+		Courses: map[string]CourseSigning{
+			"DE15": {Mode: SigningModeSigned, Secret: "course", Labs: map[string]string{"01s": "lab"}},
+			"DE16": {Mode: SigningModeBearer},
+		},
+	}
+	require.NoError(t, cfg.Validate())
+
+	assert.True(t, cfg.Signed("DE15"))
+	assert.False(t, cfg.Signed("DE16"))
+	assert.False(t, cfg.Signed("DE17"))
+	assert.Equal(t, "lab", cfg.Secret("DE15", "01s"))
+	assert.Equal(t, "course", cfg.Secret("DE15", "02s"))
+
+	assert.Error(t, SigningConfig{Courses: map[string]CourseSigning{"DE15": {Mode: SigningModeSigned}}}.Validate())
+	assert.Error(t, SigningConfig{Courses: map[string]CourseSigning{"DE15": {Mode: "hmac"}}}.Validate())
+	assert.Error(t, SigningConfig{MaxSkew: "soon"}.Validate())
+}
+
+func TestSignatureVerifier_CheckSignature(t *testing.T) {
+	now := time.Date(2024, 12, 1, 12, 0, 0, 0, time.UTC)
+	v := NewSignatureVerifier(SigningConfig{
+		Courses: map[string]CourseSigning{"DE15": {Mode: SigningModeSigned, Secret: "s3cret"}},
+	}, nil)
+	v.now = func() time.Time { return now }
+
+	body := []byte(`{"event_type":"100_lab_finish"}`)
+	signed := func(ts time.Time, secret string) http.Header {
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		h := http.Header{}
+		h.Set(TimestampHeader, timestamp)
+		h.Set(NonceHeader, "n1")
+		h.Set(SignatureHeader, SignEvent(secret, "DE15", "01s", "alice.a", timestamp, "n1", body))
+		return h
+	}
+
+	nonce, err := v.checkSignature(signed(now.Add(-time.Minute), "s3cret"), "DE15", "01s", "alice.a", body)
+	require.NoError(t, err)
+	assert.Equal(t, "n1", nonce)
+
+	_, err = v.checkSignature(http.Header{}, "DE15", "01s", "alice.a", body)
+	assert.ErrorIs(t, err, ErrSignatureMissing)
+
+	_, err = v.checkSignature(signed(now.Add(-time.Hour), "s3cret"), "DE15", "01s", "alice.a", body)
+	assert.ErrorIs(t, err, ErrSignatureStale)
+
+	_, err = v.checkSignature(signed(now, "guess"), "DE15", "01s", "alice.a", body)
+	assert.ErrorIs(t, err, ErrSignatureInvalid)
+
+	_, err = v.checkSignature(signed(now, "s3cret"), "DE15", "01s", "bob.b", body)
+	assert.ErrorIs(t, err, ErrSignatureInvalid, "signature covers the student")
+
+	_, err = v.checkSignature(signed(now, "s3cret"), "DE15", "01s", "alice.a", []byte(`{"event_type":"000_lab_start"}`))
+	assert.ErrorIs(t, err, ErrSignatureInvalid, "signature covers the body")
+}
@@ -0,0 +1,254 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+	"github.com/shrimpsizemoose/kanelbulle/internal/scoring"
+	"github.com/shrimpsizemoose/kanelbulle/internal/store"
+)
+
+// LiveGradebook can be used instead of a snapshot name to compare against current scores
+const LiveGradebook = "live"
+
+var snapshotNameRegex = regexp.MustCompile(`^[\w.-]+$`)
+
+var (
+	ErrSnapshotInvalidName = errors.New("invalid snapshot name")
+	ErrSnapshotExists      = errors.New("snapshot already exists")
+	ErrSnapshotNotFound    = errors.New("snapshot not found")
+)
+
+// Gradebook is everything needed to explain a frozen scoring matrix later
+type Gradebook struct {
+	Course      string                                  `json:"course"`
+	Name        string                                  `json:"name"`
+	CreatedAt   int64                                   `json:"created_at"`
+	CreatedBy   string                                  `json:"created_by"`
+	Policy      scoring.Policy                          `json:"policy"`
+	Labs        []models.LabScore                       `json:"labs"`
+	Overrides   []models.ScoreOverride                  `json:"overrides"`
+	Scores      map[string]map[string]int               `json:"scores"`
+	Statuses    map[string]map[string]scoring.LabStatus `json:"statuses,omitempty"`
+	InputHashes map[string]string                       `json:"input_hashes"`
+	InputHash   string                                  `json:"input_hash"`
+}
+
+type GradebookDiff struct {
+	Course        string                `json:"course"`
+	From          string                `json:"from"`
+	To            string                `json:"to"`
+	ChangedInputs []string              `json:"changed_inputs"`
+	Students      []scoring.StudentDiff `json:"students"`
+}
+
+type Snapshots struct {
+	store  store.ScoreStore
+	grader *scoring.Grader
+	roster Roster
+}
+
+func NewSnapshots(store store.ScoreStore, grader *scoring.Grader, roster Roster) *Snapshots {
+	return &Snapshots{store: store, grader: grader, roster: roster}
+}
+
+func hashJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Build assembles the current gradebook for the course without saving it
+func (s *Snapshots) Build(course string) (*Gradebook, error) {
+	labs, err := s.store.ListLabScores(course)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list lab scores: %w", err)
+	}
+
+	overrides, err := s.store.ListCourseScoreOverrides(course)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list overrides: %w", err)
+	}
+
+	finishEvents, err := s.store.GetCourseEventsByType(course, "100_lab_finish")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get finish events: %w", err)
+	}
+
+	roster := fetchRoster(s.roster, course)
+	matrix, err := s.grader.CourseMatrix(course, roster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to calculate scores: %w", err)
+	}
+
+	gradebook := &Gradebook{
+		Course:      course,
+		Name:        LiveGradebook,
+		CreatedAt:   time.Now().Unix(),
+		Policy:      s.grader.Policy(course),
+		Labs:        labs,
+		Overrides:   overrides,
+		Scores:      matrix.Scores(),
+		Statuses:    matrix.Statuses(),
+		InputHashes: make(map[string]string),
+	}
+
+	inputs := map[string]interface{}{
+		"policy":        gradebook.Policy,
+		"labs":          labs,
+		"overrides":     overrides,
+		"finish_events": finishEvents,
+		"roster":        roster,
+	}
+	for name, input := range inputs {
+		if gradebook.InputHashes[name], err = hashJSON(input); err != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", name, err)
+		}
+	}
+	if gradebook.InputHash, err = hashJSON(gradebook.InputHashes); err != nil {
+		return nil, fmt.Errorf("failed to hash inputs: %w", err)
+	}
+
+	return gradebook, nil
+}
+
+// Create freezes the current gradebook under the given name, existing snapshots are never replaced
+func (s *Snapshots) Create(course, name, createdBy string) (*Gradebook, error) {
+	if !snapshotNameRegex.MatchString(name) || name == LiveGradebook {
+		return nil, fmt.Errorf("%w %q", ErrSnapshotInvalidName, name)
+	}
+
+	existing, err := s.store.GetGradebookSnapshot(course, name)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("%w: %s for course %s", ErrSnapshotExists, name, course)
+	}
+
+	gradebook, err := s.Build(course)
+	if err != nil {
+		return nil, err
+	}
+	gradebook.Name = name
+	gradebook.CreatedBy = createdBy
+
+	data, err := json.Marshal(gradebook)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize gradebook: %w", err)
+	}
+
+	err = s.store.CreateGradebookSnapshot(models.GradebookSnapshot{
+		Course:    course,
+		Name:      name,
+		CreatedAt: gradebook.CreatedAt,
+		CreatedBy: createdBy,
+		InputHash: gradebook.InputHash,
+		Data:      string(data),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return gradebook, nil
+}
+
+// Get loads a snapshot by name, LiveGradebook builds the current one instead
+func (s *Snapshots) Get(course, name string) (*Gradebook, error) {
+	if name == LiveGradebook {
+		return s.Build(course)
+	}
+
+	snapshot, err := s.store.GetGradebookSnapshot(course, name)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot == nil {
+		return nil, fmt.Errorf("%w: %s for course %s", ErrSnapshotNotFound, name, course)
+	}
+
+	var gradebook Gradebook
+	if err := json.Unmarshal([]byte(snapshot.Data), &gradebook); err != nil {
+		return nil, fmt.Errorf("failed to decode snapshot %s: %w", name, err)
+	}
+	return &gradebook, nil
+}
+
+func (s *Snapshots) List(course string) ([]models.GradebookSnapshot, error) {
+	return s.store.ListGradebookSnapshots(course)
+}
+
+func (s *Snapshots) Compare(course, from, to string) (*GradebookDiff, error) {
+	before, err := s.Get(course, from)
+	if err != nil {
+		return nil, err
+	}
+	after, err := s.Get(course, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &GradebookDiff{Course: course, From: from, To: to}
+
+	for name, hash := range before.InputHashes {
+		if after.InputHashes[name] != hash {
+			diff.ChangedInputs = append(diff.ChangedInputs, name)
+		}
+	}
+	sort.Strings(diff.ChangedInputs)
+
+	students := make(map[string]bool)
+	for student := range before.Scores {
+		students[student] = true
+	}
+	for student := range after.Scores {
+		students[student] = true
+	}
+
+	var names []string
+	for student := range students {
+		names = append(names, student)
+	}
+	sort.Strings(names)
+
+	for _, student := range names {
+		sd := scoring.StudentDiff{Student: student}
+		labs := make(map[string]bool)
+		for lab, score := range before.Scores[student] {
+			sd.BeforeTotal += score
+			labs[lab] = true
+		}
+		for lab, score := range after.Scores[student] {
+			sd.AfterTotal += score
+			labs[lab] = true
+		}
+
+		var labNames []string
+		for lab := range labs {
+			labNames = append(labNames, lab)
+		}
+		sort.Strings(labNames)
+
+		for _, lab := range labNames {
+			b, a := before.Scores[student][lab], after.Scores[student][lab]
+			if b != a {
+				sd.Labs = append(sd.Labs, scoring.LabDiff{Lab: lab, Before: b, After: a})
+			}
+		}
+		if len(sd.Labs) > 0 {
+			diff.Students = append(diff.Students, sd)
+		}
+	}
+
+	return diff, nil
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+	"github.com/shrimpsizemoose/kanelbulle/internal/scoring"
+	"github.com/shrimpsizemoose/kanelbulle/internal/store/sqlite"
+)
+
+type staticRoster []string
+
+func (r staticRoster) CourseRoster(context.Context, string) ([]string, error) {
+	return r, nil
+}
+
+func TestSnapshots_Compare(t *testing.T) {
+	s, err := sqlite.NewSQLiteStore(":memory:", "../../migrations")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	s.DB.SetMaxOpenConns(1)
+
+	deadline := time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC)
+	require.NoError(t, s.CreateLabScore(models.LabScore{Course: "DE15", Lab: "01s", BaseScore: 10, Deadline: deadline.Unix()}))
+	for _, student := range []string{"alice.a", "bob.b"} {
+		require.NoError(t, s.CreateEntry(&models.Entry{
+			Course:    "DE15",
+			Lab:       "01s",
+			Student:   student,
+			EventType: "100_lab_finish",
+			Timestamp: deadline.Add(-time.Hour).Unix(),
+		}))
+	}
+
+	grader := scoring.NewGrader(s, map[int]int{1: -1, 2: -2, 3: -3}, 0.5, 7, 1)
+	snapshots := NewSnapshots(s, grader, staticRoster{"alice.a", "bob.b", "carol.c"})
+
+	frozen, err := snapshots.Create("DE15", "midterm", "teacher")
+	require.NoError(t, err)
+	assert.Equal(t, "teacher", frozen.CreatedBy)
+	assert.Equal(t, map[string]int{"01s": 10}, frozen.Scores["bob.b"])
+	assert.Equal(t, scoring.StatusNotStarted, frozen.Statuses["carol.c"]["01s"])
+
+	t.Run("nothing changed", func(t *testing.T) {
+		diff, err := snapshots.Compare("DE15", "midterm", LiveGradebook)
+		require.NoError(t, err)
+		assert.Empty(t, diff.ChangedInputs)
+		assert.Empty(t, diff.Students)
+	})
+
+	require.NoError(t, s.CreateScoreOverride(models.ScoreOverride{Course: "DE15", Lab: "01s", Student: "bob.b", Score: 3, Reason: "списал"}))
+
+	t.Run("override changed", func(t *testing.T) {
+		diff, err := snapshots.Compare("DE15", "midterm", LiveGradebook)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"overrides"}, diff.ChangedInputs)
+		assert.Equal(t, []scoring.StudentDiff{{
+			Student:     "bob.b",
+			BeforeTotal: 10,
+			AfterTotal:  3,
+			Labs:        []scoring.LabDiff{{Lab: "01s", Before: 10, After: 3}},
+		}}, diff.Students)
+	})
+
+	t.Run("frozen gradebook stays", func(t *testing.T) {
+		gradebook, err := snapshots.Get("DE15", "midterm")
+		require.NoError(t, err)
+		assert.Equal(t, frozen.Scores, gradebook.Scores)
+		assert.Equal(t, frozen.InputHash, gradebook.InputHash)
+		assert.Empty(t, gradebook.Overrides)
+	})
+
+	t.Run("between snapshots", func(t *testing.T) {
+		_, err := snapshots.Create("DE15", "final", "teacher")
+		require.NoError(t, err)
+
+		diff, err := snapshots.Compare("DE15", "final", "midterm")
+		require.NoError(t, err)
+		require.Len(t, diff.Students, 1)
+		assert.Equal(t, []scoring.LabDiff{{Lab: "01s", Before: 3, After: 10}}, diff.Students[0].Labs)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		_, err := snapshots.Create("DE15", "midterm", "teacher")
+		assert.ErrorIs(t, err, ErrSnapshotExists)
+		_, err = snapshots.Create("DE15", LiveGradebook, "teacher")
+		assert.ErrorIs(t, err, ErrSnapshotInvalidName)
+		_, err = snapshots.Create("DE15", "final 2024", "teacher")
+		assert.ErrorIs(t, err, ErrSnapshotInvalidName)
+		_, err = snapshots.Compare("DE15", "midterm", "nope")
+		assert.ErrorIs(t, err, ErrSnapshotNotFound)
+	})
+}
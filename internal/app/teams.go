@@ -0,0 +1,59 @@
+package app
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/store"
+)
+
+const teamPrefix = "team:"
+
+var teamNameRe = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// TeamKey is how a team shows up in places keyed by student
+func TeamKey(team string) string {
+	return teamPrefix + team
+}
+
+// ParseTeamTarget tells whether a student argument like team:<name> points to a whole team
+func ParseTeamTarget(target string) (string, bool) {
+	return strings.CutPrefix(target, teamPrefix)
+}
+
+func ValidateTeam(team string, students []string) error {
+	if !teamNameRe.MatchString(team) {
+		return fmt.Errorf("invalid team name %q, use letters, digits, dots, dashes and underscores", team)
+	}
+	if len(students) == 0 {
+		return fmt.Errorf("team %s has no members", team)
+	}
+	seen := make(map[string]bool, len(students))
+	for _, student := range students {
+		if seen[student] {
+			return fmt.Errorf("student %s is listed twice", student)
+		}
+		seen[student] = true
+	}
+	return nil
+}
+
+// TeamStudents lists members of a team for a lab, an unknown team is an error
+func TeamStudents(s store.ScoreStore, course, lab, team string) ([]string, error) {
+	members, err := s.ListTeamMembers(course)
+	if err != nil {
+		return nil, err
+	}
+
+	var students []string
+	for _, m := range members {
+		if m.Lab == lab && m.Team == team {
+			students = append(students, m.Student)
+		}
+	}
+	if len(students) == 0 {
+		return nil, fmt.Errorf("team %s not found for %s/%s", team, course, lab)
+	}
+	return students, nil
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"fmt"
+	"time"
+
+	// alpine images ship without zoneinfo
+	_ "time/tzdata"
+)
+
+const (
+	DefaultDisplayFormat = "2006-01-02 15:04:05"
+	deadlineDateFormat   = "2006-01-02"
+	deadlineTimeFormat   = "2006-01-02T15:04"
+)
+
+// ParseDeadline accepts either a date (deadline is at 23:59:59 that day)
+// or a date with time of day, both interpreted in the given location
+func ParseDeadline(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.ParseInLocation(deadlineTimeFormat, value, loc); err == nil {
+		return t, nil
+	}
+
+	t, err := time.ParseInLocation(deadlineDateFormat, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("use YYYY-MM-DD or YYYY-MM-DDTHH:MM: %w", err)
+	}
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 23, 59, 59, 0, loc), nil
+}
+
+// FormatTimestamp renders unix timestamp in the given location with zone abbreviation
+func FormatTimestamp(ts int64, loc *time.Location, layout string) string {
+	if layout == "" {
+		layout = DefaultDisplayFormat
+	}
+	return time.Unix(ts, 0).In(loc).Format(layout + " MST")
+}
+
+// FormatDuration renders a duration as 3d4h5m, days are left out when zero
+func FormatDuration(d time.Duration) string {
+	days := d / (24 * time.Hour)
+	d = d % (24 * time.Hour)
+	hours := d / time.Hour
+	d = d % time.Hour
+	minutes := d / time.Minute
+
+	if days > 0 {
+		return fmt.Sprintf("%dd%dh%dm", days, hours, minutes)
+	}
+	return fmt.Sprintf("%dh%dm", hours, minutes)
+}
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeadline(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		value   string
+		loc     *time.Location
+		want    time.Time
+		wantErr bool
+	}{
+		{"date is the end of the day", "2024-04-01", time.UTC, time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC), false},
+		{"date with time", "2024-04-01T18:30", time.UTC, time.Date(2024, 4, 1, 18, 30, 0, 0, time.UTC), false},
+		{"date in course timezone", "2024-04-01", moscow, time.Date(2024, 4, 1, 20, 59, 59, 0, time.UTC), false},
+		{"time in course timezone", "2024-04-01T18:30", moscow, time.Date(2024, 4, 1, 15, 30, 0, 0, time.UTC), false},
+		{"seconds are not accepted", "2024-04-01T18:30:00", time.UTC, time.Time{}, true},
+		{"other date layouts are not accepted", "01.04.2024", time.UTC, time.Time{}, true},
+		{"invalid date", "2024-02-30", time.UTC, time.Time{}, true},
+		{"empty", "", time.UTC, time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDeadline(tt.value, tt.loc)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.True(t, tt.want.Equal(got), "got %v, want %v", got, tt.want)
+			assert.Equal(t, tt.loc, got.Location())
+		})
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	ts := time.Date(2024, 4, 1, 20, 59, 59, 0, time.UTC).Unix()
+
+	assert.Equal(t, "2024-04-01 20:59:59 UTC", FormatTimestamp(ts, time.UTC, ""))
+	assert.Equal(t, "2024-04-01 23:59:59 MSK", FormatTimestamp(ts, moscow, ""))
+	assert.Equal(t, "2024-04-01 23:59 MSK", FormatTimestamp(ts, moscow, "2006-01-02 15:04"))
+}
+
+func TestCourses_Location(t *testing.T) {
+	courses := Courses{
+		"DE15": {Timezone: "Europe/Moscow"},
+		"DE16": {},
+		"DE17": {Timezone: "Mars/Olympus_Mons"},
+	}
+
+	assert.Equal(t, "Europe/Moscow", courses.Location("DE15").String())
+	assert.Equal(t, time.UTC, courses.Location("DE16"), "no timezone configured")
+	assert.Equal(t, time.UTC, courses.Location("DE99"), "unknown course")
+	assert.Equal(t, time.UTC, courses.Location("DE17"), "invalid timezone")
+
+	deadline, err := ParseDeadline("2024-04-01", courses.Location("DE15"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 4, 1, 20, 59, 59, 0, time.UTC).Unix(), deadline.Unix())
+
+	assert.ErrorContains(t, courses.Validate(), `invalid timezone "Mars/Olympus_Mons" for course DE17`)
+	delete(courses, "DE17")
+	assert.NoError(t, courses.Validate())
+}
@@ -0,0 +1,95 @@
+package app
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Only a salted hash of a token is stored, the plaintext is shown once
+// when the token is issued. Redis hashes written before that keep the
+// plaintext in the "token" field until MigratePlaintextTokens runs.
+
+const (
+	tokenPrefix    = "sk-knlbll-"
+	tokenSaltBytes = 16
+)
+
+// TokenSecret is what a token backend stores to validate a token later
+type TokenSecret struct {
+	Hash string
+	// Salt is hex encoded
+	Salt string
+	Hint string
+	// Lookup finds the owner of a token, see tokenLookup
+	Lookup string
+	// Legacy is a plaintext token stored before hashing was introduced
+	Legacy  string
+	Issued  time.Time
+	Revoked bool
+}
+
+func generateToken() (string, error) {
+	randomBytes := make([]byte, 12)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("failed ot generate random bytes: %w", err)
+	}
+
+	return tokenPrefix + hex.EncodeToString(randomBytes), nil
+}
+
+func hashToken(token string, salt []byte) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(token))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// tokenLookup is an unsalted hash used as the key of the token -> student index.
+// Tokens are random, so it can't be reversed, but the salted Hash stays the
+// only thing a token is validated against.
+func tokenLookup(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// tokenHint is enough of a token to tell which one a student has without being usable
+func tokenHint(token string) string {
+	if len(token) <= len(tokenPrefix)+4 {
+		return tokenPrefix + "…"
+	}
+	return tokenPrefix + "…" + token[len(token)-4:]
+}
+
+func newTokenSecret(token string) (*TokenSecret, error) {
+	salt := make([]byte, tokenSaltBytes)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	return &TokenSecret{
+		Hash:   hashToken(token, salt),
+		Salt:   hex.EncodeToString(salt),
+		Hint:   tokenHint(token),
+		Lookup: tokenLookup(token),
+	}, nil
+}
+
+// matches compares in constant time, falling back to the legacy plaintext
+func (s *TokenSecret) matches(token string) bool {
+	if s.Hash != "" {
+		salt, err := hex.DecodeString(s.Salt)
+		if err != nil {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(s.Hash), []byte(hashToken(token, salt))) == 1
+	}
+
+	if s.Legacy != "" {
+		return subtle.ConstantTimeCompare([]byte(s.Legacy), []byte(token)) == 1
+	}
+	return false
+}
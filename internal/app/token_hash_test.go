@@ -0,0 +1,42 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenSecret_Matches(t *testing.T) {
+	token := tokenPrefix + "0123456789abcdef01234567"
+
+	secret, err := newTokenSecret(token)
+	require.NoError(t, err)
+	assert.NotContains(t, secret.Hash, token)
+	assert.Equal(t, tokenPrefix+"…4567", secret.Hint)
+
+	assert.True(t, secret.matches(token))
+	assert.False(t, secret.matches(token+"0"))
+	assert.False(t, secret.matches(""))
+
+	other, err := newTokenSecret(token)
+	require.NoError(t, err)
+	assert.NotEqual(t, secret.Hash, other.Hash, "salt differs per token")
+
+	t.Run("legacy plaintext", func(t *testing.T) {
+		legacy := redisTokenSecret(map[string]string{"token": token})
+		assert.True(t, legacy.matches(token))
+		assert.False(t, legacy.matches("sk-knlbll-nope"))
+		assert.Equal(t, tokenPrefix+"…4567", legacy.Hint)
+	})
+
+	t.Run("redis fields round trip", func(t *testing.T) {
+		fields := make(map[string]string)
+		for k, v := range redisSecretFields(secret) {
+			fields[k] = v.(string)
+		}
+		assert.True(t, redisTokenSecret(fields).matches(token))
+	})
+
+	assert.False(t, (&TokenSecret{}).matches(""))
+}
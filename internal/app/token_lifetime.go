@@ -0,0 +1,41 @@
+package app
+
+import (
+	"fmt"
+	"time"
+)
+
+// TokenLifetime decides when tokens stop validating, either TTL after they
+// were issued or at the course end date, whichever comes first
+type TokenLifetime struct {
+	TTL     time.Duration
+	Courses Courses
+}
+
+// NewTokenLifetime parses ttl like "2160h", empty ttl means tokens only end with the course
+func NewTokenLifetime(ttl string, courses Courses) (*TokenLifetime, error) {
+	lifetime := &TokenLifetime{Courses: courses}
+	if ttl == "" {
+		return lifetime, nil
+	}
+
+	d, err := time.ParseDuration(ttl)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid token_ttl %q, use a duration like 2160h", ttl)
+	}
+	lifetime.TTL = d
+	return lifetime, nil
+}
+
+// Expiry returns when a token issued at issued stops validating, zero time means never.
+// Tokens created before issue times were tracked only expire with the course.
+func (l *TokenLifetime) Expiry(course string, issued time.Time) time.Time {
+	var expiry time.Time
+	if l.TTL > 0 && !issued.IsZero() {
+		expiry = issued.Add(l.TTL)
+	}
+	if end, ok := l.Courses.EndTime(course); ok && (expiry.IsZero() || end.Before(expiry)) {
+		expiry = end
+	}
+	return expiry
+}
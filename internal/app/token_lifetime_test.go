@@ -0,0 +1,41 @@
+package app
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenLifetime_Expiry(t *testing.T) {
+	courses := Courses{
+		"DE15": {Timezone: "Europe/Moscow", EndDate: "2024-06-30"},
+		"DE16": {},
+	}
+	issued := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	courseEnd := time.Date(2024, 6, 30, 20, 59, 59, 0, time.UTC)
+
+	lifetime, err := NewTokenLifetime("240h", courses)
+	require.NoError(t, err)
+
+	assert.Equal(t, issued.Add(240*time.Hour), lifetime.Expiry("DE15", issued), "ttl comes first")
+	assert.True(t, courseEnd.Equal(lifetime.Expiry("DE15", issued.Add(480*time.Hour))), "course ends first")
+	assert.Equal(t, issued.Add(240*time.Hour), lifetime.Expiry("DE16", issued))
+	assert.True(t, courseEnd.Equal(lifetime.Expiry("DE15", time.Time{})), "unknown issue time only ends with the course")
+
+	forever, err := NewTokenLifetime("", courses)
+	require.NoError(t, err)
+	assert.True(t, forever.Expiry("DE16", issued).IsZero())
+
+	_, err = NewTokenLifetime("90 days", courses)
+	assert.Error(t, err)
+}
+
+func TestIssuedAt(t *testing.T) {
+	assert.Equal(t, time.Date(2024, 3, 1, 10, 0, 0, 0, time.UTC), issuedAt(map[string]string{
+		"created_dttm_utc": "2024-01-01 10:00:00",
+		"rotated_dttm_utc": "2024-03-01 10:00:00",
+	}))
+	assert.True(t, issuedAt(map[string]string{}).IsZero())
+}
@@ -2,222 +2,82 @@ package app
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"fmt"
-	"strconv"
-	"strings"
-	"time"
 
 	"github.com/redis/go-redis/v9"
+
 	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+	"github.com/shrimpsizemoose/kanelbulle/internal/store"
 )
 
 const (
-	timeFormat       = "2006-01-02 15:04:05"
-	authKeyTpl       = "auth:%s:%s" // auth:${course}:${student}
-	lookupKeyTpl     = "lookup:%s"  // lookup:${course}
-	chatCourseKeyTpl = "chat:%d"    // chat:${chatID}
-	tokenPrefix      = "sk-knlbll-"
+	TokenBackendRedis = "redis"
+	TokenBackendSQL   = "sql"
 )
 
-type TokenManager struct {
-	redis *redis.Client
-}
-
-func NewTokenManager(redis *redis.Client) *TokenManager {
-	return &TokenManager{redis: redis}
+// TokenManager keeps student tokens, student<->telegram mappings and chat<->course
+// associations. RedisTokenManager and SQLTokenManager implement it.
+type TokenManager interface {
+	Roster
+
+	FetchOrCreateStudentToken(ctx context.Context, course, student string) (*models.TokenInfo, bool, error)
+	FetchStudentToken(ctx context.Context, course, student string) (*models.TokenInfo, error)
+	RotateStudentToken(ctx context.Context, course, student, actor string) (*models.TokenInfo, error)
+	RevokeStudentToken(ctx context.Context, course, student, actor string) error
+	// FetchTokenSecret returns ErrTokenNotFound when the student has no token
+	FetchTokenSecret(ctx context.Context, course, student string) (*TokenSecret, error)
+	// ResolveToken finds whose token it is, ErrTokenNotFound for tokens issued
+	// before the index existed. It doesn't validate the token.
+	ResolveToken(ctx context.Context, token string) (*models.StudentCourseInfo, error)
+	FetchTokenRotations(ctx context.Context, course string) (map[string]int64, error)
+	// RecordTokenUse counts an accepted API request, rotation resets the usage
+	RecordTokenUse(ctx context.Context, course, student string, use models.TokenUse) error
+	FetchTokenUsage(ctx context.Context, course, student string) (*models.TokenUsage, error)
+	SubscribeTokenEvents(ctx context.Context) <-chan models.TokenEvent
+
+	SaveStudentCourseInfo(ctx context.Context, tgUsername string, info *models.StudentCourseInfo) error
+	FetchStudentCourseInfo(ctx context.Context, tgUsername string) (*models.StudentCourseInfo, error)
+	SaveStudentTelegramMapping(ctx context.Context, course, tgUsername, studentID string) error
+	FetchStudentIDByTelegram(ctx context.Context, course, tgUsername string) (string, error)
+	FetchCourseStudents(ctx context.Context, course string) (map[string]string, error)
+	// SaveTelegramUserID remembers who can be messaged in private, telegram
+	// doesn't allow messaging a username. FetchTelegramUserID is 0 for users
+	// who never wrote to the bot.
+	SaveTelegramUserID(ctx context.Context, tgUsername string, userID int64) error
+	FetchTelegramUserID(ctx context.Context, tgUsername string) (int64, error)
+
+	AssociateChatWithCourse(ctx context.Context, chatID int64, mapping *models.ChatCourseMapping) error
+	FetchCourseMappingByChatID(ctx context.Context, chatID int64) (*models.ChatCourseMapping, error)
+	// FetchCourseChats and FetchAllChatMappings return chat ID -> mapping
+	FetchCourseChats(ctx context.Context, course string) (map[int64]*models.ChatCourseMapping, error)
+	FetchAllChatMappings(ctx context.Context) (map[int64]*models.ChatCourseMapping, error)
+	UnlinkChat(ctx context.Context, chatID int64) error
+
+	Close() error
 }
 
-func generateToken() (string, error) {
-	randomBytes := make([]byte, 12)
-	if _, err := rand.Read(randomBytes); err != nil {
-		return "", fmt.Errorf("failed ot generate random bytes: %w", err)
-	}
-
-	return tokenPrefix + hex.EncodeToString(randomBytes), nil
-}
-
-func (tm *TokenManager) FetchOrCreateStudentToken(ctx context.Context, course, student string) (*models.TokenInfo, bool, error) {
-	if course == "" || student == "" {
-		return nil, false, fmt.Errorf("invalid or unknown course/student ID")
-	}
-	key := fmt.Sprintf(authKeyTpl, course, student)
-
-	token, err := tm.redis.HGet(ctx, key, "token").Result()
-	if err != nil && err != redis.Nil {
-		return nil, false, fmt.Errorf("failed to check token: %w", err)
-	}
-
-	now := time.Now().UTC()
-	isNewToken := false
-
-	if err == redis.Nil {
-		token, err = generateToken()
+// NewTokenManager picks the token backend, empty backend means redis.
+// The sql backend keeps everything in the scores database.
+func NewTokenManager(backend, redisURL, keyTemplate string, scores store.ScoreStore) (TokenManager, error) {
+	switch backend {
+	case "", TokenBackendRedis:
+		opt, err := redis.ParseURL(redisURL)
 		if err != nil {
-			return nil, false, fmt.Errorf("failed to generate token: %w", err)
-		}
-
-		pipe := tm.redis.Pipeline()
-		pipe.HSet(ctx, key, map[string]interface{}{
-			"token":                 token,
-			"request_count":         1,
-			"last_request_dttm_utc": now.Format(timeFormat),
-			"created_dttm_utc":      now.Format(timeFormat),
-		})
-
-		if _, err := pipe.Exec(ctx); err != nil {
-			return nil, false, fmt.Errorf("failed to create token: %w", err)
+			return nil, fmt.Errorf("failed to parse redis URL: %w", err)
 		}
 
-		isNewToken = true
-	} else {
-		pipe := tm.redis.Pipeline()
-		pipe.HIncrBy(ctx, key, "request_count", 1)
-		pipe.HSet(ctx, key, "last_request_dttm_utc", now.Format(timeFormat))
-
-		if _, err := pipe.Exec(ctx); err != nil {
-			return nil, false, fmt.Errorf("failed to update token stats: %w", err)
-		}
-	}
-
-	values, err := tm.redis.HGetAll(ctx, key).Result()
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to get token info: %w", err)
-	}
-
-	lastReqTime, _ := time.Parse(timeFormat, values["last_request_dttm_utc"])
-	createdTime, _ := time.Parse(timeFormat, values["created_dttm_utc"])
-	reqCount, _ := strconv.Atoi(values["request_count"])
-
-	return &models.TokenInfo{
-		Token:           values["token"],
-		RequestCount:    reqCount,
-		LastRequestTime: lastReqTime,
-		CreatedTime:     createdTime,
-	}, isNewToken, nil
-}
-
-func (tm *TokenManager) SaveStudentCourseInfo(ctx context.Context, tgUsername string, info *models.StudentCourseInfo) error {
-	key := fmt.Sprintf("student_course:%s", tgUsername)
-
-	return tm.redis.HSet(ctx, key, info).Err()
-}
-
-func (tm *TokenManager) FetchStudentCourseInfo(ctx context.Context, tgUsername string) (*models.StudentCourseInfo, error) {
-	key := fmt.Sprintf("student_course:%s", tgUsername)
-	var info models.StudentCourseInfo
-
-	if err := tm.redis.HGetAll(ctx, key).Scan(&info); err != nil {
-		return nil, fmt.Errorf("Error fetching mapping found for %s", tgUsername)
-	}
-	return &info, nil
-}
-
-func (tm *TokenManager) SaveStudentTelegramMapping(ctx context.Context, course, tgUsername, studentID string) error {
-	key := fmt.Sprintf(lookupKeyTpl, course)
-	return tm.redis.HSet(ctx, key, tgUsername, studentID).Err()
-}
-
-func (tm *TokenManager) FetchStudentIDByTelegram(ctx context.Context, course, tgUsername string) (string, error) {
-	key := fmt.Sprintf(lookupKeyTpl, course)
-	studentID, err := tm.redis.HGet(ctx, key, tgUsername).Result()
-	if err == redis.Nil {
-		return "", fmt.Errorf("no mapping found for telegram user %s in course %s", tgUsername, course)
-	}
-	return studentID, err
-}
-
-func (tm *TokenManager) FetchCourseMappings(ctx context.Context, course string) (map[string]string, error) {
-	key := fmt.Sprintf(lookupKeyTpl, course)
-	return tm.redis.HGetAll(ctx, key).Result()
-}
-
-func (tm *TokenManager) AssociateChatWithCourse(ctx context.Context, chatID int64, mapping *models.ChatCourseMapping) error {
-	key := fmt.Sprintf(chatCourseKeyTpl, chatID)
-	return tm.redis.HSet(ctx, key, map[string]interface{}{
-		"course":              mapping.Course,
-		"name":                mapping.Name,
-		"comment":             mapping.Comment,
-		"associated_dttm_utc": mapping.AssociationTime.Format(timeFormat),
-		"registered_by":       mapping.RegisteredBy,
-	}).Err()
-}
-
-func (tm *TokenManager) FetchCourseStudents(ctx context.Context, course string) (map[string]string, error) {
-	key := fmt.Sprintf("lookup:%s", course)
-
-	students, err := tm.redis.HGetAll(ctx, key).Result()
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch course students: %w", err)
-	}
-
-	return students, nil
-}
-
-func (tm *TokenManager) FetchCourseMappingByChatID(ctx context.Context, chatID int64) (*models.ChatCourseMapping, error) {
-	key := fmt.Sprintf(chatCourseKeyTpl, chatID)
-
-	values, err := tm.redis.HGetAll(ctx, key).Result()
-	if err == redis.Nil || len(values) == 0 {
-		return nil, fmt.Errorf("no course mapping found for chat %d", chatID)
-	}
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch chat course mapping found for chat %d", chatID)
-	}
-
-	associationTime, _ := time.Parse(timeFormat, values["association_dttm_utc"])
-	registeredBy, _ := strconv.ParseInt(values["registered_by"], 10, 64)
-
-	return &models.ChatCourseMapping{
-		Course:          values["course"],
-		Name:            values["name"],
-		Comment:         values["comment"],
-		AssociationTime: associationTime,
-		RegisteredBy:    registeredBy,
-	}, nil
-}
-
-func (tm *TokenManager) Close() error {
-	if tm.redis != nil {
-		return tm.redis.Close()
-	}
-	return nil
-}
-
-func (tm *TokenManager) FetchAllChatMappings(ctx context.Context) (map[string]*models.ChatCourseMapping, error) {
-	// FIXME: scans are expensive
-	pattern := fmt.Sprintf("%s*", chatCourseKeyTpl)
-
-	iter := tm.redis.Scan(ctx, 0, pattern, 0).Iterator()
-
-	mappings := make(map[string]*models.ChatCourseMapping)
-
-	for iter.Next(ctx) {
-		key := iter.Val()
-		chatID := strings.TrimPrefix(key, chatCourseKeyTpl)
-
-		values, err := tm.redis.HGetAll(ctx, key).Result()
-		if err != nil {
-			continue
+		client := redis.NewClient(opt)
+		if err := client.Ping(context.Background()).Err(); err != nil {
+			return nil, fmt.Errorf("failed to connect to redis: %w", err)
 		}
-
-		associationTime, _ := time.Parse(timeFormat, values["association_dttm_utc"])
-		registeredBy, _ := strconv.ParseInt(values["registered_by"], 10, 64)
-
-		mappings[chatID] = &models.ChatCourseMapping{
-			Course:          values["course"],
-			Name:            values["name"],
-			Comment:         values["comment"],
-			AssociationTime: associationTime,
-			RegisteredBy:    registeredBy,
+		return NewRedisTokenManager(client, keyTemplate), nil
+	case TokenBackendSQL:
+		tokens, ok := scores.(store.TokenStore)
+		if !ok {
+			return nil, fmt.Errorf("store %T can't keep tokens", scores)
 		}
+		return NewSQLTokenManager(tokens), nil
+	default:
+		return nil, fmt.Errorf("unknown token backend %q, expected %q or %q", backend, TokenBackendRedis, TokenBackendSQL)
 	}
-
-	if err := iter.Err(); err != nil {
-		return nil, fmt.Errorf("failed to fetch chat mappings: %w", err)
-	}
-
-	return mappings, nil
-
 }
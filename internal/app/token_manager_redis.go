@@ -0,0 +1,678 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/shrimpsizemoose/trekker/logger"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+const (
+	timeFormat         = "2006-01-02 15:04:05"
+	defaultAuthKeyTpl  = "auth:{course}:{student}"
+	lookupKeyTpl       = "lookup:%s" // lookup:${course}
+	chatCourseKeyTpl   = "chat:%d"   // chat:${chatID}
+	chatIndexKey       = "chats"
+	telegramUsersKey   = "tg_users"
+	courseChatsKeyTpl  = "course_chats:%s" // course_chats:${course}
+	tokenLookupKeyTpl  = "token_lookup:%s"
+	tokenUsageKeyTpl   = "token_usage:%s:%s" // token_usage:${course}:${student}
+	tokenEventsChannel = "token_events"
+)
+
+type RedisTokenManager struct {
+	redis       *redis.Client
+	keyTemplate string
+}
+
+// NewRedisTokenManager keeps tokens in hashes named by keyTemplate, empty means auth:{course}:{student}
+func NewRedisTokenManager(redis *redis.Client, keyTemplate string) *RedisTokenManager {
+	if keyTemplate == "" {
+		keyTemplate = defaultAuthKeyTpl
+	}
+	return &RedisTokenManager{redis: redis, keyTemplate: keyTemplate}
+}
+
+func (tm *RedisTokenManager) authKey(course, student string) string {
+	return strings.NewReplacer(
+		"{course}", course,
+		"{student}", student,
+	).Replace(tm.keyTemplate)
+}
+
+// parseAuthKey is the reverse of authKey
+func (tm *RedisTokenManager) parseAuthKey(key string) (string, string, bool) {
+	pattern := regexp.QuoteMeta(tm.keyTemplate)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{course}"), "(?P<course>.+?)", 1)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta("{student}"), "(?P<student>.+?)", 1)
+	re, err := regexp.Compile("^" + pattern + "$")
+	if err != nil {
+		return "", "", false
+	}
+
+	match := re.FindStringSubmatch(key)
+	courseIdx, studentIdx := re.SubexpIndex("course"), re.SubexpIndex("student")
+	if match == nil || courseIdx < 0 || studentIdx < 0 {
+		return "", "", false
+	}
+	return match[courseIdx], match[studentIdx], true
+}
+
+// FetchOrCreateStudentToken returns the plaintext Token only when it was just created,
+// existing tokens come back with the Hint alone
+func (tm *RedisTokenManager) FetchOrCreateStudentToken(ctx context.Context, course, student string) (*models.TokenInfo, bool, error) {
+	if course == "" || student == "" {
+		return nil, false, fmt.Errorf("invalid or unknown course/student ID")
+	}
+	key := tm.authKey(course, student)
+
+	stored, err := tm.redis.HMGet(ctx, key, "token_hash", "token").Result()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to check token: %w", err)
+	}
+
+	now := time.Now().UTC()
+	isNewToken := false
+	var token string
+
+	if stored[0] == nil && stored[1] == nil {
+		token, err = generateToken()
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to generate token: %w", err)
+		}
+
+		secret, err := newTokenSecret(token)
+		if err != nil {
+			return nil, false, err
+		}
+
+		pipe := tm.redis.Pipeline()
+		pipe.HSet(ctx, key, redisSecretFields(secret))
+		tm.indexToken(ctx, pipe, secret.Lookup, course, student)
+		pipe.HSet(ctx, key, map[string]interface{}{
+			"request_count":         1,
+			"last_request_dttm_utc": now.Format(timeFormat),
+			"created_dttm_utc":      now.Format(timeFormat),
+		})
+
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, false, fmt.Errorf("failed to create token: %w", err)
+		}
+
+		isNewToken = true
+	} else {
+		pipe := tm.redis.Pipeline()
+		pipe.HIncrBy(ctx, key, "request_count", 1)
+		pipe.HSet(ctx, key, "last_request_dttm_utc", now.Format(timeFormat))
+
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, false, fmt.Errorf("failed to update token stats: %w", err)
+		}
+	}
+
+	info, err := tm.FetchStudentToken(ctx, course, student)
+	if err != nil {
+		return nil, false, err
+	}
+	info.Token = token
+	return info, isNewToken, nil
+}
+
+// RotateStudentToken replaces the token with a fresh one, the old token stops
+// validating right away. A revoked token is revived by rotation.
+func (tm *RedisTokenManager) RotateStudentToken(ctx context.Context, course, student, actor string) (*models.TokenInfo, error) {
+	if course == "" || student == "" {
+		return nil, fmt.Errorf("invalid or unknown course/student ID")
+	}
+	key := tm.authKey(course, student)
+
+	token, err := generateToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	secret, err := newTokenSecret(token)
+	if err != nil {
+		return nil, err
+	}
+
+	previous, err := tm.redis.HGet(ctx, key, "token_lookup").Result()
+	if err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to check token: %w", err)
+	}
+
+	now := time.Now().UTC()
+	pipe := tm.redis.TxPipeline()
+	if previous != "" {
+		pipe.Del(ctx, fmt.Sprintf(tokenLookupKeyTpl, previous))
+	}
+	pipe.Del(ctx, fmt.Sprintf(tokenUsageKeyTpl, course, student))
+	pipe.HSetNX(ctx, key, "created_dttm_utc", now.Format(timeFormat))
+	pipe.HSet(ctx, key, redisSecretFields(secret))
+	tm.indexToken(ctx, pipe, secret.Lookup, course, student)
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"rotated_dttm_utc":      now.Format(timeFormat),
+		"last_request_dttm_utc": now.Format(timeFormat),
+	})
+	pipe.HIncrBy(ctx, key, "request_count", 1)
+	pipe.HDel(ctx, key, "token", "revoked_dttm_utc", "revoked_by")
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to rotate token: %w", err)
+	}
+
+	tm.publishTokenEvent(ctx, models.TokenEvent{
+		Action:  models.TokenRotated,
+		Course:  course,
+		Student: student,
+		Actor:   actor,
+		Time:    now,
+	})
+
+	info, err := tm.FetchStudentToken(ctx, course, student)
+	if err != nil {
+		return nil, err
+	}
+	info.Token = token
+	return info, nil
+}
+
+// RevokeStudentToken makes the current token fail validation until it's rotated
+func (tm *RedisTokenManager) RevokeStudentToken(ctx context.Context, course, student, actor string) error {
+	key := tm.authKey(course, student)
+
+	exists, err := tm.redis.Exists(ctx, key).Result()
+	if err != nil {
+		return fmt.Errorf("failed to check token: %w", err)
+	}
+	if exists == 0 {
+		return fmt.Errorf("no token for %s in course %s", student, course)
+	}
+
+	now := time.Now().UTC()
+	if err := tm.redis.HSet(ctx, key, map[string]interface{}{
+		"revoked_dttm_utc": now.Format(timeFormat),
+		"revoked_by":       actor,
+	}).Err(); err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+
+	tm.publishTokenEvent(ctx, models.TokenEvent{
+		Action:  models.TokenRevoked,
+		Course:  course,
+		Student: student,
+		Actor:   actor,
+		Time:    now,
+	})
+	return nil
+}
+
+// FetchStudentToken reads token info without counting it as a request, Token is always empty
+func (tm *RedisTokenManager) FetchStudentToken(ctx context.Context, course, student string) (*models.TokenInfo, error) {
+	values, err := tm.redis.HGetAll(ctx, tm.authKey(course, student)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token info: %w", err)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no token for %s in course %s", student, course)
+	}
+
+	lastReqTime, _ := time.Parse(timeFormat, values["last_request_dttm_utc"])
+	createdTime, _ := time.Parse(timeFormat, values["created_dttm_utc"])
+	reqCount, _ := strconv.Atoi(values["request_count"])
+	secret := redisTokenSecret(values)
+
+	return &models.TokenInfo{
+		Hint:            secret.Hint,
+		RequestCount:    reqCount,
+		LastRequestTime: lastReqTime,
+		CreatedTime:     createdTime,
+		IssuedTime:      secret.Issued,
+		Revoked:         secret.Revoked,
+	}, nil
+}
+
+func (tm *RedisTokenManager) FetchTokenSecret(ctx context.Context, course, student string) (*TokenSecret, error) {
+	values, err := tm.redis.HGetAll(ctx, tm.authKey(course, student)).Result()
+	if err == redis.Nil || (err == nil && len(values) == 0) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %w", err)
+	}
+	return redisTokenSecret(values), nil
+}
+
+func (tm *RedisTokenManager) ResolveToken(ctx context.Context, token string) (*models.StudentCourseInfo, error) {
+	values, err := tm.redis.HGetAll(ctx, fmt.Sprintf(tokenLookupKeyTpl, tokenLookup(token))).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis error: %w", err)
+	}
+	if len(values) == 0 {
+		return nil, ErrTokenNotFound
+	}
+	return &models.StudentCourseInfo{Course: values["course"], StudentID: values["student"]}, nil
+}
+
+// usageEventPrefix marks the per lab and event type counters of the usage hash
+const usageEventPrefix = "event:"
+
+func (tm *RedisTokenManager) RecordTokenUse(ctx context.Context, course, student string, use models.TokenUse) error {
+	key := fmt.Sprintf(tokenUsageKeyTpl, course, student)
+	pipe := tm.redis.TxPipeline()
+	pipe.HIncrBy(ctx, key, "count", 1)
+	pipe.HIncrBy(ctx, key, usageEventPrefix+use.UsageKey(), 1)
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"last_used_dttm_utc": use.Time.UTC().Format(timeFormat),
+		"last_ip":            use.IP,
+		"last_user_agent":    use.UserAgent,
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to record token use: %w", err)
+	}
+	return nil
+}
+
+// FetchTokenUsage returns empty usage for tokens never used with the API
+func (tm *RedisTokenManager) FetchTokenUsage(ctx context.Context, course, student string) (*models.TokenUsage, error) {
+	values, err := tm.redis.HGetAll(ctx, fmt.Sprintf(tokenUsageKeyTpl, course, student)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token usage: %w", err)
+	}
+
+	usage := &models.TokenUsage{
+		LastIP:        values["last_ip"],
+		LastUserAgent: values["last_user_agent"],
+		Events:        make(map[string]int64),
+	}
+	usage.Count, _ = strconv.ParseInt(values["count"], 10, 64)
+	usage.LastUsed, _ = time.Parse(timeFormat, values["last_used_dttm_utc"])
+	for field, value := range values {
+		if event, ok := strings.CutPrefix(field, usageEventPrefix); ok {
+			usage.Events[event], _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+	return usage, nil
+}
+
+// indexToken queues the token_lookup:${lookup} -> course, student entry
+func (tm *RedisTokenManager) indexToken(ctx context.Context, pipe redis.Pipeliner, lookup, course, student string) {
+	pipe.HSet(ctx, fmt.Sprintf(tokenLookupKeyTpl, lookup), map[string]interface{}{
+		"course":  course,
+		"student": student,
+	})
+}
+
+func redisSecretFields(secret *TokenSecret) map[string]interface{} {
+	return map[string]interface{}{
+		"token_hash":   secret.Hash,
+		"token_salt":   secret.Salt,
+		"token_hint":   secret.Hint,
+		"token_lookup": secret.Lookup,
+	}
+}
+
+func redisTokenSecret(fields map[string]string) *TokenSecret {
+	secret := &TokenSecret{
+		Hash:    fields["token_hash"],
+		Salt:    fields["token_salt"],
+		Hint:    fields["token_hint"],
+		Legacy:  fields["token"],
+		Issued:  issuedAt(fields),
+		Revoked: fields["revoked_dttm_utc"] != "",
+	}
+	if secret.Hint == "" && secret.Legacy != "" {
+		secret.Hint = tokenHint(secret.Legacy)
+	}
+	return secret
+}
+
+// issuedAt is the last rotation or, for tokens never rotated, the creation time
+func issuedAt(fields map[string]string) time.Time {
+	for _, field := range []string{"rotated_dttm_utc", "created_dttm_utc"} {
+		if t, err := time.Parse(timeFormat, fields[field]); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// MigratePlaintextTokens replaces plaintext tokens in every token hash with
+// a salted hash and indexes them for ResolveToken, returns how many hashes
+// were converted. Tokens hashed before the index existed can't be indexed,
+// their students keep sending the student header until they rotate.
+func (tm *RedisTokenManager) MigratePlaintextTokens(ctx context.Context) (int, error) {
+	iter := tm.redis.Scan(ctx, 0, tm.authKey("*", "*"), 0).Iterator()
+
+	migrated := 0
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		token, err := tm.redis.HGet(ctx, key, "token").Result()
+		if err != nil || token == "" {
+			// already hashed or not a token hash at all
+			continue
+		}
+
+		secret, err := newTokenSecret(token)
+		if err != nil {
+			return migrated, err
+		}
+
+		pipe := tm.redis.TxPipeline()
+		pipe.HSet(ctx, key, redisSecretFields(secret))
+		if course, student, ok := tm.parseAuthKey(key); ok {
+			tm.indexToken(ctx, pipe, secret.Lookup, course, student)
+		}
+		pipe.HDel(ctx, key, "token")
+		if _, err := pipe.Exec(ctx); err != nil {
+			return migrated, fmt.Errorf("failed to migrate %s: %w", key, err)
+		}
+		migrated++
+	}
+
+	if err := iter.Err(); err != nil {
+		return migrated, fmt.Errorf("failed to scan tokens: %w", err)
+	}
+	return migrated, nil
+}
+
+// token events go through redis pub/sub so the bot hears about changes made via the api
+func (tm *RedisTokenManager) publishTokenEvent(ctx context.Context, event models.TokenEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logger.Error.Printf("Failed to encode token event: %v", err)
+		return
+	}
+	if err := tm.redis.Publish(ctx, tokenEventsChannel, payload).Err(); err != nil {
+		logger.Error.Printf("Failed to publish token event: %v", err)
+	}
+}
+
+// SubscribeTokenEvents delivers token events until ctx is done
+func (tm *RedisTokenManager) SubscribeTokenEvents(ctx context.Context) <-chan models.TokenEvent {
+	events := make(chan models.TokenEvent)
+	sub := tm.redis.Subscribe(ctx, tokenEventsChannel)
+
+	go func() {
+		defer close(events)
+		defer sub.Close()
+
+		for msg := range sub.Channel() {
+			var event models.TokenEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				logger.Error.Printf("Failed to decode token event: %v", err)
+				continue
+			}
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events
+}
+
+func (tm *RedisTokenManager) SaveStudentCourseInfo(ctx context.Context, tgUsername string, info *models.StudentCourseInfo) error {
+	key := fmt.Sprintf("student_course:%s", tgUsername)
+
+	return tm.redis.HSet(ctx, key, info).Err()
+}
+
+func (tm *RedisTokenManager) FetchStudentCourseInfo(ctx context.Context, tgUsername string) (*models.StudentCourseInfo, error) {
+	key := fmt.Sprintf("student_course:%s", tgUsername)
+	var info models.StudentCourseInfo
+
+	if err := tm.redis.HGetAll(ctx, key).Scan(&info); err != nil {
+		return nil, fmt.Errorf("Error fetching mapping found for %s", tgUsername)
+	}
+	return &info, nil
+}
+
+func (tm *RedisTokenManager) SaveStudentTelegramMapping(ctx context.Context, course, tgUsername, studentID string) error {
+	key := fmt.Sprintf(lookupKeyTpl, course)
+	return tm.redis.HSet(ctx, key, tgUsername, studentID).Err()
+}
+
+func (tm *RedisTokenManager) FetchStudentIDByTelegram(ctx context.Context, course, tgUsername string) (string, error) {
+	key := fmt.Sprintf(lookupKeyTpl, course)
+	studentID, err := tm.redis.HGet(ctx, key, tgUsername).Result()
+	if err == redis.Nil {
+		return "", fmt.Errorf("no mapping found for telegram user %s in course %s", tgUsername, course)
+	}
+	return studentID, err
+}
+
+func (tm *RedisTokenManager) SaveTelegramUserID(ctx context.Context, tgUsername string, userID int64) error {
+	return tm.redis.HSet(ctx, telegramUsersKey, tgUsername, userID).Err()
+}
+
+func (tm *RedisTokenManager) FetchTelegramUserID(ctx context.Context, tgUsername string) (int64, error) {
+	userID, err := tm.redis.HGet(ctx, telegramUsersKey, tgUsername).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch telegram user %s: %w", tgUsername, err)
+	}
+	return userID, nil
+}
+
+func (tm *RedisTokenManager) FetchCourseMappings(ctx context.Context, course string) (map[string]string, error) {
+	key := fmt.Sprintf(lookupKeyTpl, course)
+	return tm.redis.HGetAll(ctx, key).Result()
+}
+
+// AssociateChatWithCourse also moves the chat between the per course indexes
+func (tm *RedisTokenManager) AssociateChatWithCourse(ctx context.Context, chatID int64, mapping *models.ChatCourseMapping) error {
+	key := fmt.Sprintf(chatCourseKeyTpl, chatID)
+	previous, err := tm.redis.HGet(ctx, key, "course").Result()
+	if err != nil && err != redis.Nil {
+		return fmt.Errorf("failed to check chat course: %w", err)
+	}
+
+	pipe := tm.redis.TxPipeline()
+	if previous != "" && previous != mapping.Course {
+		pipe.SRem(ctx, fmt.Sprintf(courseChatsKeyTpl, previous), chatID)
+	}
+	pipe.SAdd(ctx, fmt.Sprintf(courseChatsKeyTpl, mapping.Course), chatID)
+	pipe.SAdd(ctx, chatIndexKey, chatID)
+	pipe.HSet(ctx, key, map[string]interface{}{
+		"course":              mapping.Course,
+		"name":                mapping.Name,
+		"comment":             mapping.Comment,
+		"associated_dttm_utc": mapping.AssociationTime.Format(timeFormat),
+		"registered_by":       mapping.RegisteredBy,
+	})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to associate chat: %w", err)
+	}
+	return nil
+}
+
+// UnlinkChat forgets the course of the chat
+func (tm *RedisTokenManager) UnlinkChat(ctx context.Context, chatID int64) error {
+	key := fmt.Sprintf(chatCourseKeyTpl, chatID)
+	course, err := tm.redis.HGet(ctx, key, "course").Result()
+	if err == redis.Nil {
+		return fmt.Errorf("no course mapping found for chat %d", chatID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check chat course: %w", err)
+	}
+
+	pipe := tm.redis.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.SRem(ctx, fmt.Sprintf(courseChatsKeyTpl, course), chatID)
+	pipe.SRem(ctx, chatIndexKey, chatID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to unlink chat: %w", err)
+	}
+	return nil
+}
+
+func (tm *RedisTokenManager) FetchCourseStudents(ctx context.Context, course string) (map[string]string, error) {
+	key := fmt.Sprintf("lookup:%s", course)
+
+	students, err := tm.redis.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch course students: %w", err)
+	}
+
+	return students, nil
+}
+
+func (tm *RedisTokenManager) FetchCourseMappingByChatID(ctx context.Context, chatID int64) (*models.ChatCourseMapping, error) {
+	key := fmt.Sprintf(chatCourseKeyTpl, chatID)
+
+	values, err := tm.redis.HGetAll(ctx, key).Result()
+	if err == redis.Nil || len(values) == 0 {
+		return nil, fmt.Errorf("no course mapping found for chat %d", chatID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chat course mapping found for chat %d", chatID)
+	}
+
+	return redisChatMapping(values), nil
+}
+
+func redisChatMapping(values map[string]string) *models.ChatCourseMapping {
+	associationTime, _ := time.Parse(timeFormat, values["associated_dttm_utc"])
+	registeredBy, _ := strconv.ParseInt(values["registered_by"], 10, 64)
+
+	return &models.ChatCourseMapping{
+		Course:          values["course"],
+		Name:            values["name"],
+		Comment:         values["comment"],
+		AssociationTime: associationTime,
+		RegisteredBy:    registeredBy,
+	}
+}
+
+func (tm *RedisTokenManager) Close() error {
+	if tm.redis != nil {
+		return tm.redis.Close()
+	}
+	return nil
+}
+
+// FetchAllChatMappings returns chat ID -> mapping for every associated chat
+func (tm *RedisTokenManager) FetchAllChatMappings(ctx context.Context) (map[int64]*models.ChatCourseMapping, error) {
+	chatIDs, err := tm.redis.SMembers(ctx, chatIndexKey).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chat mappings: %w", err)
+	}
+	return tm.fetchChatMappings(ctx, chatIDs)
+}
+
+// FetchCourseChats returns chat ID -> mapping for chats associated with the course
+func (tm *RedisTokenManager) FetchCourseChats(ctx context.Context, course string) (map[int64]*models.ChatCourseMapping, error) {
+	chatIDs, err := tm.redis.SMembers(ctx, fmt.Sprintf(courseChatsKeyTpl, course)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch course chats: %w", err)
+	}
+	return tm.fetchChatMappings(ctx, chatIDs)
+}
+
+// fetchChatMappings skips chats whose mapping is gone from under the index
+func (tm *RedisTokenManager) fetchChatMappings(ctx context.Context, chatIDs []string) (map[int64]*models.ChatCourseMapping, error) {
+	pipe := tm.redis.Pipeline()
+	cmds := make(map[int64]*redis.MapStringStringCmd, len(chatIDs))
+	for _, member := range chatIDs {
+		chatID, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		cmds[chatID] = pipe.HGetAll(ctx, fmt.Sprintf(chatCourseKeyTpl, chatID))
+	}
+	if len(cmds) == 0 {
+		return map[int64]*models.ChatCourseMapping{}, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, fmt.Errorf("failed to fetch chat mappings: %w", err)
+	}
+
+	mappings := make(map[int64]*models.ChatCourseMapping, len(cmds))
+	for chatID, cmd := range cmds {
+		if values := cmd.Val(); len(values) > 0 {
+			mappings[chatID] = redisChatMapping(values)
+		}
+	}
+	return mappings, nil
+}
+
+// IndexChatMappings adds chats associated before the chat indexes existed,
+// it scans every chat key once
+func (tm *RedisTokenManager) IndexChatMappings(ctx context.Context) (int, error) {
+	prefix := strings.TrimSuffix(chatCourseKeyTpl, "%d")
+	iter := tm.redis.Scan(ctx, 0, prefix+"*", 0).Iterator()
+
+	indexed := 0
+	for iter.Next(ctx) {
+		chatID, err := strconv.ParseInt(strings.TrimPrefix(iter.Val(), prefix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		course, err := tm.redis.HGet(ctx, iter.Val(), "course").Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return indexed, fmt.Errorf("failed to read chat %d: %w", chatID, err)
+		}
+
+		pipe := tm.redis.TxPipeline()
+		pipe.SAdd(ctx, fmt.Sprintf(courseChatsKeyTpl, course), chatID)
+		pipe.SAdd(ctx, chatIndexKey, chatID)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return indexed, fmt.Errorf("failed to index chat %d: %w", chatID, err)
+		}
+		indexed++
+	}
+	if err := iter.Err(); err != nil {
+		return indexed, fmt.Errorf("failed to scan chats: %w", err)
+	}
+	return indexed, nil
+}
+
+// FetchTokenRotations returns student -> unix time of the last token rotation
+// for students of the course whose token was ever rotated
+func (tm *RedisTokenManager) FetchTokenRotations(ctx context.Context, course string) (map[string]int64, error) {
+	students, err := tm.CourseRoster(ctx, course)
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := tm.redis.Pipeline()
+	cmds := make(map[string]*redis.StringCmd, len(students))
+	for _, student := range students {
+		cmds[student] = pipe.HGet(ctx, tm.authKey(course, student), "rotated_dttm_utc")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, fmt.Errorf("failed to fetch token rotations: %w", err)
+	}
+
+	rotations := make(map[string]int64)
+	for student, cmd := range cmds {
+		value, err := cmd.Result()
+		if err != nil {
+			continue
+		}
+		rotated, err := time.Parse(timeFormat, value)
+		if err != nil {
+			continue
+		}
+		rotations[student] = rotated.Unix()
+	}
+	return rotations, nil
+}
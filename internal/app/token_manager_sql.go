@@ -0,0 +1,377 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/shrimpsizemoose/trekker/logger"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+	"github.com/shrimpsizemoose/kanelbulle/internal/store"
+)
+
+// tokenEventsPollInterval is how often SQL subscribers look for new token events
+const tokenEventsPollInterval = 5 * time.Second
+
+// SQLTokenManager keeps tokens and mappings in the scores database,
+// token events are polled from the token_events table
+type SQLTokenManager struct {
+	store store.TokenStore
+}
+
+func NewSQLTokenManager(store store.TokenStore) *SQLTokenManager {
+	return &SQLTokenManager{store: store}
+}
+
+// FetchOrCreateStudentToken returns the plaintext Token only when it was just created,
+// existing tokens come back with the Hint alone
+func (tm *SQLTokenManager) FetchOrCreateStudentToken(ctx context.Context, course, student string) (*models.TokenInfo, bool, error) {
+	if course == "" || student == "" {
+		return nil, false, fmt.Errorf("invalid or unknown course/student ID")
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	secret, err := newTokenSecret(token)
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := time.Now().UTC().Unix()
+	created, err := tm.store.CreateStudentToken(models.StudentToken{
+		Course:        course,
+		Student:       student,
+		TokenHash:     secret.Hash,
+		TokenSalt:     secret.Salt,
+		TokenHint:     secret.Hint,
+		RequestCount:  1,
+		CreatedAt:     now,
+		LastRequestAt: now,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	if created {
+		if err := tm.store.SaveTokenLookup(secret.Lookup, course, student); err != nil {
+			return nil, false, err
+		}
+	} else {
+		token = ""
+		if err := tm.store.TouchStudentToken(course, student, now); err != nil {
+			return nil, false, err
+		}
+	}
+
+	info, err := tm.FetchStudentToken(ctx, course, student)
+	if err != nil {
+		return nil, false, err
+	}
+	info.Token = token
+	return info, created, nil
+}
+
+// RotateStudentToken replaces the token with a fresh one, the old token stops
+// validating right away. A revoked token is revived by rotation.
+func (tm *SQLTokenManager) RotateStudentToken(ctx context.Context, course, student, actor string) (*models.TokenInfo, error) {
+	if course == "" || student == "" {
+		return nil, fmt.Errorf("invalid or unknown course/student ID")
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	secret, err := newTokenSecret(token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	rotated := now.Unix()
+	if err := tm.store.ReplaceStudentToken(models.StudentToken{
+		Course:        course,
+		Student:       student,
+		TokenHash:     secret.Hash,
+		TokenSalt:     secret.Salt,
+		TokenHint:     secret.Hint,
+		RequestCount:  1,
+		CreatedAt:     rotated,
+		LastRequestAt: rotated,
+		RotatedAt:     &rotated,
+	}); err != nil {
+		return nil, err
+	}
+	if err := tm.store.SaveTokenLookup(secret.Lookup, course, student); err != nil {
+		return nil, err
+	}
+	if err := tm.store.DeleteTokenUsage(course, student); err != nil {
+		return nil, err
+	}
+
+	tm.publishTokenEvent(models.TokenEvent{
+		Action:  models.TokenRotated,
+		Course:  course,
+		Student: student,
+		Actor:   actor,
+		Time:    now,
+	})
+
+	info, err := tm.FetchStudentToken(ctx, course, student)
+	if err != nil {
+		return nil, err
+	}
+	info.Token = token
+	return info, nil
+}
+
+// RevokeStudentToken makes the current token fail validation until it's rotated
+func (tm *SQLTokenManager) RevokeStudentToken(ctx context.Context, course, student, actor string) error {
+	now := time.Now().UTC()
+	revoked, err := tm.store.RevokeStudentToken(course, student, actor, now.Unix())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return fmt.Errorf("no token for %s in course %s", student, course)
+	}
+
+	tm.publishTokenEvent(models.TokenEvent{
+		Action:  models.TokenRevoked,
+		Course:  course,
+		Student: student,
+		Actor:   actor,
+		Time:    now,
+	})
+	return nil
+}
+
+// FetchStudentToken reads token info without counting it as a request, Token is always empty
+func (tm *SQLTokenManager) FetchStudentToken(ctx context.Context, course, student string) (*models.TokenInfo, error) {
+	row, err := tm.store.GetStudentToken(course, student)
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, fmt.Errorf("no token for %s in course %s", student, course)
+	}
+
+	secret := sqlTokenSecret(row)
+	return &models.TokenInfo{
+		Hint:            secret.Hint,
+		RequestCount:    row.RequestCount,
+		LastRequestTime: time.Unix(row.LastRequestAt, 0).UTC(),
+		CreatedTime:     time.Unix(row.CreatedAt, 0).UTC(),
+		IssuedTime:      secret.Issued,
+		Revoked:         secret.Revoked,
+	}, nil
+}
+
+func (tm *SQLTokenManager) FetchTokenSecret(ctx context.Context, course, student string) (*TokenSecret, error) {
+	row, err := tm.store.GetStudentToken(course, student)
+	if err != nil {
+		return nil, err
+	}
+	if row == nil {
+		return nil, ErrTokenNotFound
+	}
+	return sqlTokenSecret(row), nil
+}
+
+func (tm *SQLTokenManager) ResolveToken(ctx context.Context, token string) (*models.StudentCourseInfo, error) {
+	info, err := tm.store.GetTokenLookup(tokenLookup(token))
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, ErrTokenNotFound
+	}
+	return info, nil
+}
+
+func (tm *SQLTokenManager) RecordTokenUse(ctx context.Context, course, student string, use models.TokenUse) error {
+	return tm.store.RecordTokenUse(course, student, use)
+}
+
+func (tm *SQLTokenManager) FetchTokenUsage(ctx context.Context, course, student string) (*models.TokenUsage, error) {
+	return tm.store.GetTokenUsage(course, student)
+}
+
+func sqlTokenSecret(row *models.StudentToken) *TokenSecret {
+	issued := row.CreatedAt
+	if row.RotatedAt != nil {
+		issued = *row.RotatedAt
+	}
+	return &TokenSecret{
+		Hash:    row.TokenHash,
+		Salt:    row.TokenSalt,
+		Hint:    row.TokenHint,
+		Issued:  time.Unix(issued, 0).UTC(),
+		Revoked: row.RevokedAt != nil,
+	}
+}
+
+// FetchTokenRotations returns student -> unix time of the last token rotation
+// for students of the course whose token was ever rotated
+func (tm *SQLTokenManager) FetchTokenRotations(ctx context.Context, course string) (map[string]int64, error) {
+	tokens, err := tm.store.ListStudentTokens(course)
+	if err != nil {
+		return nil, err
+	}
+
+	rotations := make(map[string]int64)
+	for _, token := range tokens {
+		if token.RotatedAt != nil {
+			rotations[token.Student] = *token.RotatedAt
+		}
+	}
+	return rotations, nil
+}
+
+// publishTokenEvent uses nanoseconds as the event ID so pollers can tell
+// events apart even when several land in the same second
+func (tm *SQLTokenManager) publishTokenEvent(event models.TokenEvent) {
+	event.ID = time.Now().UnixNano()
+	if err := tm.store.CreateTokenEvent(event); err != nil {
+		logger.Error.Printf("Failed to publish token event: %v", err)
+	}
+}
+
+// SubscribeTokenEvents delivers token events stored after the call until ctx is done
+func (tm *SQLTokenManager) SubscribeTokenEvents(ctx context.Context) <-chan models.TokenEvent {
+	events := make(chan models.TokenEvent)
+	lastID := time.Now().UnixNano()
+
+	go func() {
+		defer close(events)
+
+		ticker := time.NewTicker(tokenEventsPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			fresh, err := tm.store.ListTokenEventsAfter(lastID)
+			if err != nil {
+				logger.Error.Printf("Failed to poll token events: %v", err)
+				continue
+			}
+			for _, event := range fresh {
+				lastID = event.ID
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return events
+}
+
+func (tm *SQLTokenManager) SaveStudentCourseInfo(ctx context.Context, tgUsername string, info *models.StudentCourseInfo) error {
+	return tm.store.SaveStudentCourseInfo(tgUsername, *info)
+}
+
+func (tm *SQLTokenManager) FetchStudentCourseInfo(ctx context.Context, tgUsername string) (*models.StudentCourseInfo, error) {
+	info, err := tm.store.GetStudentCourseInfo(tgUsername)
+	if err != nil {
+		return nil, err
+	}
+	if info == nil {
+		return nil, fmt.Errorf("no mapping found for %s", tgUsername)
+	}
+	return info, nil
+}
+
+func (tm *SQLTokenManager) SaveStudentTelegramMapping(ctx context.Context, course, tgUsername, studentID string) error {
+	return tm.store.SaveCourseStudent(course, tgUsername, studentID)
+}
+
+func (tm *SQLTokenManager) FetchStudentIDByTelegram(ctx context.Context, course, tgUsername string) (string, error) {
+	studentID, err := tm.store.GetCourseStudent(course, tgUsername)
+	if err != nil {
+		return "", err
+	}
+	if studentID == "" {
+		return "", fmt.Errorf("no mapping found for telegram user %s in course %s", tgUsername, course)
+	}
+	return studentID, nil
+}
+
+func (tm *SQLTokenManager) FetchCourseStudents(ctx context.Context, course string) (map[string]string, error) {
+	return tm.store.ListCourseStudents(course)
+}
+
+func (tm *SQLTokenManager) SaveTelegramUserID(ctx context.Context, tgUsername string, userID int64) error {
+	return tm.store.SaveTelegramUser(tgUsername, userID)
+}
+
+func (tm *SQLTokenManager) FetchTelegramUserID(ctx context.Context, tgUsername string) (int64, error) {
+	return tm.store.GetTelegramUserID(tgUsername)
+}
+
+// CourseRoster returns student IDs mapped by /new_course and /map_student
+func (tm *SQLTokenManager) CourseRoster(ctx context.Context, course string) ([]string, error) {
+	mappings, err := tm.store.ListCourseStudents(course)
+	if err != nil {
+		return nil, err
+	}
+
+	students := make([]string, 0, len(mappings))
+	for _, studentID := range mappings {
+		students = append(students, studentID)
+	}
+	sort.Strings(students)
+	return students, nil
+}
+
+func (tm *SQLTokenManager) AssociateChatWithCourse(ctx context.Context, chatID int64, mapping *models.ChatCourseMapping) error {
+	return tm.store.SaveChatCourse(chatID, *mapping)
+}
+
+func (tm *SQLTokenManager) FetchCourseMappingByChatID(ctx context.Context, chatID int64) (*models.ChatCourseMapping, error) {
+	mapping, err := tm.store.GetChatCourse(chatID)
+	if err != nil {
+		return nil, err
+	}
+	if mapping == nil {
+		return nil, fmt.Errorf("no course mapping found for chat %d", chatID)
+	}
+	return mapping, nil
+}
+
+func (tm *SQLTokenManager) FetchCourseChats(ctx context.Context, course string) (map[int64]*models.ChatCourseMapping, error) {
+	return tm.store.ListChatCourses(course)
+}
+
+func (tm *SQLTokenManager) FetchAllChatMappings(ctx context.Context) (map[int64]*models.ChatCourseMapping, error) {
+	return tm.store.ListChatCourses("")
+}
+
+func (tm *SQLTokenManager) UnlinkChat(ctx context.Context, chatID int64) error {
+	unlinked, err := tm.store.DeleteChatCourse(chatID)
+	if err != nil {
+		return err
+	}
+	if !unlinked {
+		return fmt.Errorf("no course mapping found for chat %d", chatID)
+	}
+	return nil
+}
+
+// Close is a no-op, the database belongs to the score store
+func (tm *SQLTokenManager) Close() error {
+	return nil
+}
@@ -0,0 +1,90 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+)
+
+func (b *Bot) handleAPIKeyAdd(msg *tgbotapi.Message, args Args) error {
+	scopes, err := app.ParseScopes(args.String("scopes"))
+	if err != nil {
+		return fmt.Errorf("некорректные права: %v", err)
+	}
+	var courses []string
+	if args.Has("courses") {
+		courses = strings.Split(args.String("courses"), ",")
+	}
+
+	plaintext, key, err := b.apiKeys.Create(args.String("name"), scopes, courses, "@"+msg.From.UserName)
+	if err != nil {
+		return fmt.Errorf("ошибка создания ключа: %v", err)
+	}
+
+	courseText := "все курсы"
+	if len(key.Courses) > 0 {
+		courseText = strings.Join(key.Courses, ", ")
+	}
+
+	return b.sendMessage(msg.Chat.ID, fmt.Sprintf("🔑 Ключ %s\nПрава: %s\nКурсы: %s\n\n%s\n\nКлюч показывается один раз, передавайте его в заголовке %s",
+		key.Name,
+		strings.Join(key.Scopes, ", "),
+		courseText,
+		plaintext,
+		app.DefaultAPIKeyHeader,
+	))
+}
+
+func (b *Bot) handleAPIKeyList(msg *tgbotapi.Message, _ Args) error {
+	chatID := msg.Chat.ID
+	keys, err := b.apiKeys.List()
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка ключей: %v", err)
+	}
+	if len(keys) == 0 {
+		return b.sendMessage(chatID, "Ключей пока нет")
+	}
+
+	var response strings.Builder
+	response.WriteString("🔑 Ключи API:\n\n")
+	for _, key := range keys {
+		courses := "все курсы"
+		if len(key.Courses) > 0 {
+			courses = strings.Join(key.Courses, ", ")
+		}
+		status := ""
+		if key.RevokedAt != nil {
+			status = " (отозван)"
+		}
+		response.WriteString(fmt.Sprintf("%s %s%s\n  права: %s\n  курсы: %s\n  выдал %s %s\n",
+			key.Name,
+			key.Hint,
+			status,
+			strings.Join(key.Scopes, ", "),
+			courses,
+			key.CreatedBy,
+			key.CreatedAt.Format("2006-01-02"),
+		))
+	}
+	return b.sendMessage(chatID, response.String())
+}
+
+func (b *Bot) handleAPIKeyRevoke(msg *tgbotapi.Message, args Args) error {
+	name := args.String("name")
+	if err := b.apiKeys.Revoke(name); err != nil {
+		return fmt.Errorf("ошибка отзыва ключа: %v", err)
+	}
+	b.notifyAdmins(fmt.Sprintf("🔒 Ключ API `%s` отозван (@%s)", name, msg.From.UserName))
+	return nil
+}
+
+func scopeNames() []string {
+	names := make([]string, 0, len(app.Scopes))
+	for _, scope := range app.Scopes {
+		names = append(names, string(scope))
+	}
+	return names
+}
@@ -7,21 +7,35 @@ import (
 	"os/signal"
 	"syscall"
 
-	"github.com/redis/go-redis/v9"
-
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+	"github.com/shrimpsizemoose/kanelbulle/internal/scoring"
 
 	"github.com/shrimpsizemoose/kanelbulle/internal/store"
 	"github.com/shrimpsizemoose/trekker/logger"
 )
 
+// telegramAPI is the part of the bot API client the bot uses
+type telegramAPI interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	GetChatMember(config tgbotapi.GetChatMemberConfig) (tgbotapi.ChatMember, error)
+	GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel
+}
+
 type Bot struct {
-	config       *Config
-	store        store.ScoreStore
-	api          *tgbotapi.BotAPI
-	admins       map[int64]bool
-	tokenManager *app.TokenManager
+	config        *Config
+	store         store.ScoreStore
+	api           telegramAPI
+	commands      *Registry
+	wizards       map[string]*wizard
+	conversations *conversations
+	admins        map[int64]bool
+	tokenManager  app.TokenManager
+	apiKeys       *app.APIKeys
+	lifetime      *app.TokenLifetime
+	grader        *scoring.Grader
+	snapshots     *app.Snapshots
 }
 
 func New(config *Config, store store.ScoreStore) (*Bot, error) {
@@ -29,31 +43,50 @@ func New(config *Config, store store.ScoreStore) (*Bot, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create bot API: %w", err)
 	}
+	return newBot(config, store, api)
+}
 
+func newBot(config *Config, store store.ScoreStore, api telegramAPI) (*Bot, error) {
 	admins := make(map[int64]bool)
 	for _, id := range config.Bot.AdminIDs {
 		admins[id] = true
 	}
 
-	redisOpt, err := redis.ParseURL(config.Auth.RedisURL)
+	lifetime, err := app.NewTokenLifetime(config.Auth.TokenTTL, config.Courses)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse redis URL: %w", err)
+		return nil, err
 	}
 
-	redisClient := redis.NewClient(redisOpt)
-	if err := redisClient.Ping(context.Background()).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect to redis: %w", err)
+	tokenManager, err := app.NewTokenManager(config.Auth.Backend, config.Auth.RedisURL, config.Auth.TokenKeyTemplate, store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init tokens: %w", err)
 	}
 
-	tokenManager := app.NewTokenManager(redisClient)
+	apiKeys, err := app.NewAPIKeys(store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init api keys: %w", err)
+	}
 
-	return &Bot{
+	grader, err := app.NewCourseGrader(store, config.Scoring, config.Courses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init grader: %w", err)
+	}
+
+	b := &Bot{
 		config:       config,
 		store:        store,
 		api:          api,
 		admins:       admins,
 		tokenManager: tokenManager,
-	}, nil
+		apiKeys:      apiKeys,
+		lifetime:     lifetime,
+		grader:       grader,
+		snapshots:    app.NewSnapshots(store, grader, tokenManager),
+	}
+	b.conversations = newConversations()
+	b.wizards = b.registerWizards()
+	b.commands = b.registerCommands()
+	return b, nil
 }
 
 func (b *Bot) Start() error {
@@ -74,15 +107,28 @@ func (b *Bot) Start() error {
 
 	updates := b.api.GetUpdatesChan(u)
 
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go b.watchTokenEvents(ctx)
+
+	reminders, err := b.startReminders()
+	if err != nil {
+		return err
+	}
+	if reminders != nil {
+		defer reminders.Stop()
+	}
+
 	for {
 		select {
 		case update := <-updates:
-			if update.Message == nil {
-				continue
+			switch {
+			case update.Message != nil:
+				go b.handleMessage(update.Message)
+			case update.CallbackQuery != nil:
+				go b.handleCallback(update.CallbackQuery)
 			}
 
-			go b.handleMessage(update.Message)
-
 		case <-stop:
 			logger.Info.Println("Received shutdown signal")
 			return nil
@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/shrimpsizemoose/trekker/logger"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+func (b *Bot) handleChatList(msg *tgbotapi.Message, args Args) error {
+	chatID, course := msg.Chat.ID, args.String("course")
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	var (
+		mappings map[int64]*models.ChatCourseMapping
+		err      error
+	)
+	if course == "" {
+		mappings, err = b.tokenManager.FetchAllChatMappings(ctx)
+	} else {
+		mappings, err = b.tokenManager.FetchCourseChats(ctx, course)
+	}
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка чатов: %v", err)
+	}
+	if len(mappings) == 0 {
+		return b.sendMessage(chatID, "Привязанных чатов нет")
+	}
+
+	chatIDs := make([]int64, 0, len(mappings))
+	for id := range mappings {
+		chatIDs = append(chatIDs, id)
+	}
+	sort.Slice(chatIDs, func(i, j int) bool {
+		ci, cj := mappings[chatIDs[i]], mappings[chatIDs[j]]
+		if ci.Course != cj.Course {
+			return ci.Course < cj.Course
+		}
+		return chatIDs[i] < chatIDs[j]
+	})
+
+	var response strings.Builder
+	response.WriteString("💬 Чаты курсов:\n\n")
+	for _, id := range chatIDs {
+		mapping := mappings[id]
+		response.WriteString(fmt.Sprintf("%s: %s (%d)\n", mapping.Course, mapping.Name, id))
+		if mapping.Comment != "" {
+			response.WriteString(fmt.Sprintf("  %s\n", mapping.Comment))
+		}
+		response.WriteString(fmt.Sprintf("  привязан %s\n",
+			app.FormatTimestamp(mapping.AssociationTime.Unix(), b.config.Courses.Location(mapping.Course), app.DefaultDisplayFormat),
+		))
+	}
+	return b.sendMessage(chatID, response.String())
+}
+
+// handleChatUnlink unlinks the chat given by ID or, in a group, the current one
+func (b *Bot) handleChatUnlink(msg *tgbotapi.Message, args Args) error {
+	chatID := msg.Chat.ID
+	if args.Has("chat_id") {
+		chatID = args.Int64("chat_id")
+	} else if msg.Chat.Type == "private" {
+		return fmt.Errorf("использование: /chat unlink <chat_id>, ID чатов есть в /chat list")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	mapping, err := b.tokenManager.FetchCourseMappingByChatID(ctx, chatID)
+	if err != nil {
+		return fmt.Errorf("чат %d не привязан ни к какому курсу", chatID)
+	}
+	if err := b.tokenManager.UnlinkChat(ctx, chatID); err != nil {
+		return fmt.Errorf("не получилось отвязать чат: %v", err)
+	}
+
+	logger.Info.Printf("Chat %d unlinked from course %s by %d", chatID, mapping.Course, msg.From.ID)
+	return b.sendMessage(msg.Chat.ID, fmt.Sprintf("🔌 Чат %s (%d) отвязан от курса %s", mapping.Name, chatID, mapping.Course))
+}
@@ -3,113 +3,223 @@ package bot
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"strings"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/shrimpsizemoose/trekker/logger"
 
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
 	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+	"github.com/shrimpsizemoose/kanelbulle/internal/scoring"
 )
 
-const (
-	operationTimeout = 5 * time.Second
-
-	studentHelp = `Доступные команды:
-/token - Получить токен для доступа к API
-/help - Показать это сообщение`
-
-	adminHelp = `Доступные команды:
-/token - Получить токен для доступа к API
-/lab add <course> <lab> score <score> deadline <date> -- Добавить лабораторную
-/lab list <course> - Список лабораторных работ
-/override set <course> <student> <lab> score <score> reason <reason> - Установить оценку вручную
-/override list <course> - Список текущих оверрайдов
-/new_course COURSE_CODE +список пар @tg_username и student.id по одной в каждой строке
-/set_course <course> [comment] - Привязать чат к какому-то курсу
-/map_student @username <student.name> - Привязать телеграмный айдишник к student.id
-/help - Показать это сообщение
-
-Примеры:
-/lab add DE15 01s score 10 deadline "2024-12-01"
-/lab list DE15
-/override set DE15 01s student.name score 8 reason "Late submission accepted"
-/override list DE15
-/map_student @karkarkar kaggi.kar
-/set_course DE15 "Дамокловы Экивоки 14+"
-`
-)
-
-type commandHandler func(*tgbotapi.Message) error
-
-func (b *Bot) routeStudentCommands(cmd string) (commandHandler, bool) {
-	commands := map[string]commandHandler{
-		"start": b.handleStart,
-		"token": b.handleTokenCommand,
-		"help":  b.handleHelp,
-	}
-	handler, found := commands[cmd]
-	return handler, found
+const operationTimeout = 5 * time.Second
+
+// registerCommands declares every bot command, help and usage are generated from here
+func (b *Bot) registerCommands() *Registry {
+	course := Arg{Name: "course"}
+	student := Arg{Name: "student"}
+	lab := Arg{Name: "lab"}
+
+	r := NewRegistry(b.config.Courses.Location)
+	r.Register(
+		Command{Name: "start", Summary: "Начать работу с ботом", Raw: true, Handler: b.handleStart},
+		Command{Name: "token", Summary: "Получить токен для доступа к API", Handler: b.handleTokenCommand},
+		Command{Name: "token rotate", Summary: "Выпустить новый токен, старый перестанет работать", Handler: b.handleTokenRotateCommand},
+		Command{
+			Name:    "scores",
+			Summary: "Мои оценки по лабам (ответ придёт в личку)",
+			Args:    []Arg{{Name: "lab", Optional: true}},
+			Handler: b.handleScoresCommand,
+		},
+		Command{
+			Name:    "deadlines",
+			Summary: "Ближайшие дедлайны (без курса - курс чата или свой)",
+			Args:    []Arg{{Name: "course", Optional: true}},
+			Handler: b.handleDeadlinesCommand,
+		},
+		Command{Name: "cancel", Summary: "Прервать пошаговый ввод команды", Handler: b.handleCancel},
+		Command{Name: "help", Summary: "Показать это сообщение", Raw: true, Handler: b.handleHelp},
+
+		Command{
+			Name:    "lab add",
+			Summary: "Добавить или обновить лабораторную (дата YYYY-MM-DD или YYYY-MM-DDTHH:MM в часовом поясе курса)",
+			Args: []Arg{course, lab,
+				{Name: "score", Kind: ArgInt, Keyword: true},
+				{Name: "deadline", Kind: ArgDeadline, Keyword: true, Placeholder: "date"},
+			},
+			Permission:  PermAdmin,
+			Examples:    []string{"/lab add DE15 01s score 10 deadline 2024-12-01", "/lab add DE15 02s score 10 deadline 2024-12-08T18:00"},
+			Handler:     b.handleLabAdd,
+			Interactive: b.startWizard("lab add"),
+		},
+		Command{Name: "lab list", Summary: "Список лабораторных работ", Args: []Arg{course}, Permission: PermAdmin, Handler: b.handleLabList},
+		Command{
+			Name:    "override set",
+			Summary: "Установить оценку вручную, student может быть team:<команда>",
+			Args: []Arg{course, lab, student,
+				{Name: "score", Kind: ArgInt, Keyword: true},
+				{Name: "reason", Keyword: true, Rest: true, Optional: true},
+			},
+			Permission:  PermAdmin,
+			Examples:    []string{`/override set DE15 01s student.name score 8 reason "Late submission accepted"`, `/override set DE15 03t team:owls score 9 reason "Team bonus"`},
+			Handler:     b.handleOverrideSet,
+			Interactive: b.startWizard("override set"),
+		},
+		Command{Name: "override list", Summary: "Список текущих оверрайдов", Args: []Arg{course}, Permission: PermAdmin, Handler: b.handleOverrideList},
+		Command{
+			Name:       "team add",
+			Summary:    "Создать или пересобрать команду, сдача любого участника засчитывается всем",
+			Args:       []Arg{course, lab, {Name: "team"}, {Name: "students", Rest: true}},
+			Permission: PermAdmin,
+			Examples:   []string{"/team add DE15 03t owls kaggi.kar student.name"},
+			Handler:    b.handleTeamAdd,
+		},
+		Command{Name: "team list", Summary: "Список команд", Args: []Arg{course, {Name: "lab", Optional: true}}, Permission: PermAdmin, Handler: b.handleTeamList},
+		Command{Name: "team remove", Summary: "Удалить команду", Args: []Arg{course, lab, {Name: "team"}}, Permission: PermAdmin, Handler: b.handleTeamRemove},
+		Command{
+			Name:       "snapshot create",
+			Summary:    "Заморозить текущую ведомость",
+			Args:       []Arg{course, {Name: "name"}},
+			Permission: PermAdmin,
+			Examples:   []string{"/snapshot create DE15 final-2024"},
+			Handler:    b.handleSnapshotCreate,
+		},
+		Command{Name: "snapshot list", Summary: "Список ведомостей", Args: []Arg{course}, Permission: PermAdmin, Handler: b.handleSnapshotList},
+		Command{Name: "snapshot get", Summary: "Скачать ведомость", Args: []Arg{course, {Name: "name"}}, Permission: PermAdmin, Handler: b.handleSnapshotGet},
+		Command{
+			Name:       "snapshot diff",
+			Summary:    "Сравнить ведомости (live - текущие оценки)",
+			Args:       []Arg{course, {Name: "from"}, {Name: "to"}},
+			Permission: PermAdmin,
+			Examples:   []string{"/snapshot diff DE15 final-2024 live"},
+			Handler:    b.handleSnapshotDiff,
+		},
+		Command{
+			Name:       "simulate",
+			Summary:    "Что будет с оценками при другой политике, ничего не сохраняет. Стратегии: " + strings.Join(scoring.Strategies(), ", "),
+			Args:       []Arg{course, {Name: "options", Rest: true, Optional: true}},
+			Usage:      "/simulate <course> [deadline <lab> <date>] [strategy <name>] [param <name> <value>] [modifier <days> <delta>] [penalty <x>] [max_late_days <n>] [extra_penalty <n>]",
+			Permission: PermAdmin,
+			Examples:   []string{"/simulate DE15 deadline 01s 2024-12-08 modifier 1 -2", "/simulate DE15 strategy linear_decay param per_hour 0.02"},
+			Handler:    b.handleSimulateCommand,
+		},
+		Command{
+			Name:       "stats",
+			Summary:    "Сводка: сколько сдали, медиана времени, опоздания, баллы, кто застрял",
+			Args:       []Arg{course, {Name: "lab", Optional: true}},
+			Permission: PermAdmin,
+			Examples:   []string{"/stats DE15 01s"},
+			Handler:    b.handleStatsCommand,
+		},
+		Command{Name: "revoke", Summary: "Отозвать токен студента", Args: []Arg{course, student}, Permission: PermAdmin, Handler: b.handleRevokeCommand},
+		Command{Name: "tokeninfo", Summary: "Токен студента и где он использовался в API", Args: []Arg{course, student}, Permission: PermAdmin, Handler: b.handleTokenInfoCommand},
+		Command{
+			Name:       "apikey add",
+			Summary:    "Выпустить ключ API, без курсов ключ работает для всех. Scopes: " + strings.Join(scopeNames(), ", "),
+			Args:       []Arg{{Name: "name"}, {Name: "scopes", Placeholder: "scope1,scope2|all"}, {Name: "courses", Placeholder: "course1,course2", Optional: true}},
+			Permission: PermAdmin,
+			Chat:       ChatPrivate,
+			Examples:   []string{"/apikey add grafana read-stats,read-scores DE15"},
+			Handler:    b.handleAPIKeyAdd,
+		},
+		Command{Name: "apikey list", Summary: "Список ключей API", Permission: PermAdmin, Handler: b.handleAPIKeyList},
+		Command{Name: "apikey revoke", Summary: "Отозвать ключ API", Args: []Arg{{Name: "name"}}, Permission: PermAdmin, Handler: b.handleAPIKeyRevoke},
+		Command{
+			Name:       "new_course",
+			Summary:    "Завести курс и студентов, пары @tg_username student.id по одной на строке",
+			Usage:      "/new_course <course> + строки @tg_username student.id",
+			Raw:        true,
+			Permission: PermAdmin,
+			Handler:    b.handleNewCourseCommand,
+		},
+		Command{
+			Name:       "set_course",
+			Summary:    "Привязать чат к курсу",
+			Args:       []Arg{course, {Name: "comment", Rest: true, Optional: true}},
+			Permission: PermAdmin,
+			Chat:       ChatGroup,
+			Examples:   []string{`/set_course DE15 "Дамокловы Экивоки 14+"`},
+			Handler:    b.handleSetCourseCommand,
+		},
+		Command{Name: "chat list", Summary: "Чаты, привязанные к курсам", Args: []Arg{{Name: "course", Optional: true}}, Permission: PermAdmin, Handler: b.handleChatList},
+		Command{
+			Name:       "chat unlink",
+			Summary:    "Отвязать чат от курса, без chat_id - текущий",
+			Args:       []Arg{{Name: "chat_id", Kind: ArgInt, Optional: true}},
+			Permission: PermAdmin,
+			Handler:    b.handleChatUnlink,
+		},
+		Command{
+			Name:    "map_student",
+			Summary: "Привязать телеграмный айдишник к student.id в курсе чата или в указанном курсе",
+			Args: []Arg{
+				{Name: "username", Placeholder: "@username"},
+				{Name: "student", Placeholder: "student.name"},
+				{Name: "course", Keyword: true, Optional: true},
+			},
+			Permission:  PermAdmin,
+			Examples:    []string{"/map_student @karkarkar kaggi.kar"},
+			Handler:     b.handleMapStudentCommand,
+			Interactive: b.startWizard("map_student"),
+		},
+	)
+	return r
 }
 
-func (b *Bot) routeAdminCommands(cmd string) (commandHandler, bool) {
-	commands := map[string]commandHandler{
-		"lab":         b.handleLab,
-		"override":    b.handleOverride,
-		"set_course":  b.handleSetCourseCommand,
-		"map_student": b.handleMapStudentCommand,
-		"new_course":  b.handleNewCourseCommand,
+func (b *Bot) permission(msg *tgbotapi.Message) Permission {
+	if b.admins[msg.From.ID] {
+		return PermAdmin
 	}
-	handler, found := commands[cmd]
-	return handler, found
+	return PermStudent
 }
 
 func (b *Bot) handleMessage(msg *tgbotapi.Message) {
 	if !msg.IsCommand() {
-		b.sendHelp(msg.Chat.ID)
+		if !b.continueWizard(msg) {
+			b.sendHelp(msg.Chat.ID)
+		}
 		return
 	}
 
-	cmd := msg.Command()
+	b.rememberTelegramUser(msg)
 
-	if handler, ok := b.routeStudentCommands(cmd); ok {
-		if err := handler(msg); err != nil {
-			logger.Error.Printf("Command error: %v", err)
-			b.sendMessage(msg.Chat.ID, fmt.Sprintf("Error: %v", err))
-		}
+	reply := func(text string) error { return b.sendMessage(msg.Chat.ID, text) }
+	found, err := b.commands.Run(msg, b.permission(msg), reply)
+	if err != nil {
+		logger.Error.Printf("Command error: %v", err)
+		b.sendMessage(msg.Chat.ID, fmt.Sprintf("Error: %v", err))
 		return
 	}
+	if !found {
+		b.sendHelp(msg.Chat.ID)
+	}
+}
 
-	if b.admins[msg.From.ID] {
-		if handler, ok := b.routeAdminCommands(cmd); ok {
-			if err := handler(msg); err != nil {
-				logger.Error.Printf("Command error: %v", err)
-				b.sendMessage(msg.Chat.ID, fmt.Sprintf("Error: %v", err))
-			}
-		}
+// rememberTelegramUser keeps the user ID of everyone who writes to the bot in
+// private, reminders can only be sent to them
+func (b *Bot) rememberTelegramUser(msg *tgbotapi.Message) {
+	if msg.Chat.Type != "private" || msg.From.UserName == "" {
 		return
 	}
 
-	b.sendHelp(msg.Chat.ID)
-}
-
-func (b *Bot) handleHelp(msg *tgbotapi.Message) error {
-	var text string
-	if b.admins[msg.From.ID] {
-		text = adminHelp
-	} else {
-		text = studentHelp
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+	if err := b.tokenManager.SaveTelegramUserID(ctx, msg.From.UserName, msg.From.ID); err != nil {
+		logger.Error.Printf("Failed to remember telegram user %s: %v", msg.From.UserName, err)
 	}
+}
 
-	return b.sendMessage(msg.Chat.ID, text)
+func (b *Bot) handleHelp(msg *tgbotapi.Message, _ Args) error {
+	return b.sendMessage(msg.Chat.ID, b.commands.Help(b.permission(msg)))
 }
 
 func (b *Bot) sendHelp(chatID int64) error {
 	return b.sendMessage(chatID, "Используйте команды для взаимодействия с ботом. Отправьте /help для списка команд.")
 }
 
-func (b *Bot) handleStart(msg *tgbotapi.Message) error {
+func (b *Bot) handleStart(msg *tgbotapi.Message, _ Args) error {
 	if msg.Chat.Type != "private" {
 		return nil
 	}
@@ -119,50 +229,59 @@ func (b *Bot) handleStart(msg *tgbotapi.Message) error {
 	response.WriteString("Привет!\n\n")
 
 	if b.admins[msg.From.ID] {
-		b.sendMessage(msg.Chat.ID, "Ты администратор бота. Команды:\n"+adminHelp)
+		b.sendMessage(msg.Chat.ID, "Ты администратор бота. "+b.commands.Help(PermAdmin))
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
 	defer cancel()
 	info, err := b.tokenManager.FetchStudentCourseInfo(ctx, msg.From.UserName)
 	if err == nil {
-		response.WriteString(fmt.Sprintf("Вы студент курса `%s`.\n\n%s", info.Course, studentHelp))
+		response.WriteString(fmt.Sprintf("Вы студент курса `%s`.\n\n%s", info.Course, b.commands.Help(PermStudent)))
 	}
 
 	return b.sendMessage(msg.Chat.ID, response.String())
 
 }
 
-func (b *Bot) handleTokenCommand(msg *tgbotapi.Message) error {
-	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
-	defer cancel()
+// warnPrivateOnly answers token commands in groups with a short lived warning
+// and deletes both messages, so tokens never show up in a group
+func (b *Bot) warnPrivateOnly(msg *tgbotapi.Message) bool {
+	if msg.Chat.Type == "private" {
+		return false
+	}
+	// delMsg := tgbotapi.NewDeleteMessage(msg.Chat.ID, msg.MessageID)
+	// if _, err := b.api.Request(delMsg); err != nil {
+	// 	return fmt.Errorf("failed to delete message: %w", err)
+	// }
+	// return fmt.Errorf("Команда /token имеет смысл только в приватном чате")
 
-	if msg.Chat.Type != "private" {
-		// delMsg := tgbotapi.NewDeleteMessage(msg.Chat.ID, msg.MessageID)
-		// if _, err := b.api.Request(delMsg); err != nil {
-		// 	return fmt.Errorf("failed to delete message: %w", err)
-		// }
-		// return fmt.Errorf("Команда /token имеет смысл только в приватном чате")
-
-		warnMsg := tgbotapi.NewMessage(msg.Chat.ID, "Команда /token имеет смысл только в приватном чате")
-		warnMsg.ReplyToMessageID = msg.MessageID
-		reply, err := b.api.Send(warnMsg)
-		if err == nil {
-			go func() {
-				time.Sleep(12 * time.Second)
-				delWarn := tgbotapi.NewDeleteMessage(msg.Chat.ID, reply.MessageID)
-				if _, err := b.api.Request(delWarn); err != nil {
-					logger.Error.Printf("Failed to delete warning message: %v", err)
-				}
-				delMsg := tgbotapi.NewDeleteMessage(msg.Chat.ID, msg.MessageID)
-				if _, err := b.api.Request(delMsg); err != nil {
-					logger.Error.Printf("failed to delete message: %v", err)
-				}
-			}()
-		}
+	warnMsg := tgbotapi.NewMessage(msg.Chat.ID, "Команда /token имеет смысл только в приватном чате")
+	warnMsg.ReplyToMessageID = msg.MessageID
+	reply, err := b.api.Send(warnMsg)
+	if err == nil {
+		go func() {
+			time.Sleep(12 * time.Second)
+			delWarn := tgbotapi.NewDeleteMessage(msg.Chat.ID, reply.MessageID)
+			if _, err := b.api.Request(delWarn); err != nil {
+				logger.Error.Printf("Failed to delete warning message: %v", err)
+			}
+			delMsg := tgbotapi.NewDeleteMessage(msg.Chat.ID, msg.MessageID)
+			if _, err := b.api.Request(delMsg); err != nil {
+				logger.Error.Printf("failed to delete message: %v", err)
+			}
+		}()
+	}
+	return true
+}
+
+func (b *Bot) handleTokenCommand(msg *tgbotapi.Message, _ Args) error {
+	if b.warnPrivateOnly(msg) {
 		return nil
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
 	info, err := b.tokenManager.FetchStudentCourseInfo(ctx, msg.From.UserName)
 	if err != nil {
 		return fmt.Errorf("Не признал: %w", err)
@@ -184,14 +303,25 @@ func (b *Bot) handleTokenCommand(msg *tgbotapi.Message) error {
 	}
 
 	if isNewToken {
-		go b.notifyAdminsAboutNewToken(info.Course, info.StudentID, tokenInfo.Token)
+		go b.notifyAdminsAboutNewToken(info.Course, info.StudentID)
+	}
+
+	if tokenInfo.Revoked {
+		return b.sendMessage(msg.From.ID, "⛔ Токен отозван администратором. Новый можно получить командой /token rotate")
+	}
+
+	// only the hash is stored, the plaintext exists just for this one reply
+	tokenLine := fmt.Sprintf("Token: `%s`\nСохрани его, второй раз бот его не покажет", tokenInfo.Token)
+	if !isNewToken {
+		tokenLine = fmt.Sprintf("Token: `%s`\nТокен уже выдан и показывался один раз. Потерял? /token rotate", tokenInfo.Hint)
 	}
 
 	text := fmt.Sprintf(
-		"🧩 %s\nStudent: `%s`\nToken: `%s`",
+		"🧩 %s\nStudent: `%s`\n%s\n%s",
 		info.Course,
 		info.StudentID,
-		tokenInfo.Token,
+		tokenLine,
+		b.tokenLifetimeLine(info.Course, tokenInfo),
 	)
 
 	if err := b.sendMessageMarkdown(msg.From.ID, text); err != nil {
@@ -201,14 +331,17 @@ func (b *Bot) handleTokenCommand(msg *tgbotapi.Message) error {
 	return nil
 }
 
-func (b *Bot) notifyAdminsAboutNewToken(course, student, token string) {
+func (b *Bot) notifyAdminsAboutNewToken(course, student string) {
 	message := fmt.Sprintf(
-		"🔐 New token created\nCourse: %s\nStudent: `%s`\nToken: `%s`",
+		"🔐 New token created\nCourse: %s\nStudent: `%s`",
 		course,
 		student,
-		token,
 	)
 
+	b.notifyAdmins(message)
+}
+
+func (b *Bot) notifyAdmins(message string) {
 	for _, adminID := range b.config.Bot.AdminIDs {
 		go func(id int64) {
 			if err := b.sendMessageMarkdown(id, message); err != nil {
@@ -218,66 +351,12 @@ func (b *Bot) notifyAdminsAboutNewToken(course, student, token string) {
 	}
 }
 
-func (b *Bot) handleLab(msg *tgbotapi.Message) error {
-	args := strings.Fields(msg.CommandArguments())
-	if len(args) < 1 {
-		return b.sendMessage(msg.Chat.ID, "Использование:\n"+
-			"/lab add <course> <lab> score <score> deadline <date> - Добавить лабораторную\n"+
-			"/lab list <course> - Показать список лабораторных")
-	}
-
-	switch args[0] {
-	case "add":
-		return b.handleLabAdd(msg.Chat.ID, args[1:])
-	case "list":
-		if len(args) < 2 {
-			return fmt.Errorf("укажи курс: /lab list DE15")
-		}
-		return b.handleLabList(msg.Chat.ID, args[1])
-	default:
-		return fmt.Errorf("неизвестная подкоманда: %s", args[0])
-	}
-}
-
-func (b *Bot) handleLabAdd(chatID int64, args []string) error {
-	if len(args) < 4 {
-		return fmt.Errorf("использование: add <course> <lab> score <score> deadline <date>")
-	}
-
-	course := args[0]
-	lab := args[1]
-
-	var score int
-	var deadline time.Time
-	var err error
-
-	for i := 2; i < len(args); i += 2 {
-		if i+1 >= len(args) {
-			return fmt.Errorf("пропущено значение для %s", args[i])
-		}
-
-		switch args[i] {
-		case "score":
-			score, err = strconv.Atoi(args[i+1])
-			if err != nil {
-				return fmt.Errorf("некорректная оценка: %v", err)
-			}
-		case "deadline":
-			deadline, err = time.Parse("2006-01-02", args[i+1])
-			if err != nil {
-				return fmt.Errorf("некорректная дата (используйте YYYY-MM-DD): %v", err)
-			}
-			deadline = time.Date(
-				deadline.Year(),
-				deadline.Month(),
-				deadline.Day(),
-				23, 59, 59, 0,
-				deadline.Location(),
-			)
-		default:
-			return fmt.Errorf("неизвестный параметр: %s", args[i])
-		}
-	}
+func (b *Bot) handleLabAdd(msg *tgbotapi.Message, args Args) error {
+	course := args.String("course")
+	lab := args.String("lab")
+	score := args.Int("score")
+	deadline := args.Time("deadline")
+	loc := b.config.Courses.Location(course)
 
 	labScore := models.LabScore{
 		Lab:       lab,
@@ -301,19 +380,20 @@ func (b *Bot) handleLabAdd(chatID int64, args []string) error {
 		action = "обновлена"
 	}
 
-	return b.sendMessage(chatID, fmt.Sprintf("✅ Лабораторная %s для курса %s %s:\n"+
+	return b.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Лабораторная %s для курса %s %s:\n"+
 		"Баллы: %d\n"+
-		"Дедлайн: %s %s",
+		"Дедлайн: %s (%s)",
 		lab,
 		course,
 		action,
 		score,
-		deadline.Format("2006-01-02 15:04"),
-		deadline.Location().String(),
+		app.FormatTimestamp(deadline.Unix(), loc, "2006-01-02 15:04"),
+		loc.String(),
 	))
 }
 
-func (b *Bot) handleLabList(chatID int64, course string) error {
+func (b *Bot) handleLabList(msg *tgbotapi.Message, args Args) error {
+	chatID, course := msg.Chat.ID, args.String("course")
 	labs, err := b.store.ListLabScores(course)
 	if err != nil {
 		return fmt.Errorf("ошибка получения списка лаб: %v", err)
@@ -323,90 +403,83 @@ func (b *Bot) handleLabList(chatID int64, course string) error {
 		return b.sendMessage(chatID, "Лабораторные работы не найдены")
 	}
 
-	var msg strings.Builder
-	msg.WriteString(fmt.Sprintf("Лабораторные работы курса %s:\n\n", course))
+	loc := b.config.Courses.Location(course)
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("Лабораторные работы курса %s:\n\n", course))
 	for _, lab := range labs {
-		deadline := time.Unix(lab.Deadline, 0)
-		msg.WriteString(fmt.Sprintf("📝 %s (баллы: %d)\n"+
-			"📅 %s UTC\n\n",
+		text.WriteString(fmt.Sprintf("📝 %s (баллы: %d)\n"+
+			"📅 %s\n\n",
 			lab.Lab,
 			lab.BaseScore,
-			deadline.UTC().Format("2006-Jan-02 Mon 15:04"),
+			app.FormatTimestamp(lab.Deadline, loc, "2006-Jan-02 Mon 15:04"),
 		))
 	}
 
-	return b.sendMessage(chatID, msg.String())
+	return b.sendMessage(chatID, text.String())
 }
 
-func (b *Bot) handleOverride(msg *tgbotapi.Message) error {
-	args := strings.Fields(msg.CommandArguments())
-	if len(args) < 1 {
-		return b.sendMessage(msg.Chat.ID, "Использование:\n"+
-			"/override set <course> <lab> <student> <lab> score <score> reason <reason> - Установить оценку вручную\n"+
-			"/override list <course> - Список текущих оверрайдов для конкретног окурса")
-	}
+func (b *Bot) handleOverrideSet(msg *tgbotapi.Message, args Args) error {
+	course := args.String("course")
+	lab := args.String("lab")
+	student := args.String("student")
+	score := args.Int("score")
+	reason := args.String("reason")
 
-	switch args[0] {
-	case "set":
-		return b.handleOverrideSet(msg.Chat.ID, args[1:])
-	case "list":
-		if len(args) < 2 {
-			return fmt.Errorf("укажи курс: /override list DE15")
+	var err error
+	students := []string{student}
+	if team, ok := app.ParseTeamTarget(student); ok {
+		students, err = app.TeamStudents(b.store, course, lab, team)
+		if err != nil {
+			return fmt.Errorf("ошибка получения команды: %v", err)
 		}
-		return b.handleOverrideList(msg.Chat.ID, args[1])
-	default:
-		return fmt.Errorf("неизвестная подкоманда: %s", args[0])
-	}
-}
-
-func (b *Bot) handleOverrideSet(chatID int64, args []string) error {
-	if len(args) < 4 {
-		return fmt.Errorf("использование: set <course> <lab> <student> score <score> reason <reason>")
-	}
-
-	course := args[0]
-	lab := args[1]
-	student := args[2]
-	score, err := strconv.Atoi(args[4])
-	if err != nil {
-		return fmt.Errorf("некорректная оценка: %v", err)
-	}
-	reason := strings.Join(args[6+1:], " ")
-
-	scoreOverride := models.ScoreOverride{
-		Student: student,
-		Lab:     lab,
-		Score:   score,
-		Course:  course,
-		Reason:  reason,
 	}
 
-	existing, err := b.store.GetScoreOverride(course, lab, student)
-	if err != nil {
-		return fmt.Errorf("ошибка проверки существования оверрайда %s/%s/%s: %v", course, lab, student, err)
+	// every member of a team may or may not have an override already
+	var updated []string
+	for _, s := range students {
+		existing, err := b.store.GetScoreOverride(course, lab, s)
+		if err != nil {
+			return fmt.Errorf("ошибка проверки существования оверрайда %s/%s/%s: %v", course, lab, s, err)
+		}
+		if existing != nil {
+			updated = append(updated, s)
+		}
 	}
 
-	err = b.store.CreateScoreOverride(scoreOverride)
-	if err != nil {
-		return fmt.Errorf("ошибка сохранения: %v", err)
+	for _, s := range students {
+		err = b.store.CreateScoreOverride(models.ScoreOverride{
+			Student: s,
+			Lab:     lab,
+			Score:   score,
+			Course:  course,
+			Reason:  reason,
+		})
+		if err != nil {
+			return fmt.Errorf("ошибка сохранения для %s: %v", s, err)
+		}
 	}
 
 	action := "добавлен"
-	if existing != nil {
+	switch {
+	case len(updated) == len(students):
 		action = "обновлён"
+	case len(updated) > 0:
+		action = fmt.Sprintf("добавлен, у %s обновлён", strings.Join(updated, ", "))
 	}
 
-	return b.sendMessage(chatID, fmt.Sprintf("✅ Оверрайд для студента %s/%s/%s %s:\n"+
+	return b.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Оверрайд для студента %s/%s/%s %s:\n"+
 		"Баллы: %d\n"+
 		"Причина: %s",
-		action,
 		course, lab, student,
+		action,
 		score,
 		reason,
 	))
 }
 
-func (b *Bot) handleOverrideList(chatID int64, course string) error {
+func (b *Bot) handleOverrideList(msg *tgbotapi.Message, args Args) error {
+	chatID, course := msg.Chat.ID, args.String("course")
 	overrides, err := b.store.ListCourseScoreOverrides(course)
 	if err != nil {
 		return fmt.Errorf("ошибка получения списка оверрайдов: %v", err)
@@ -425,10 +498,10 @@ func (b *Bot) handleOverrideList(chatID int64, course string) error {
 		baseScores[score.Lab] = score.BaseScore
 	}
 
-	var msg strings.Builder
-	msg.WriteString(fmt.Sprintf("Оверрайды курса %s:\n\n", course))
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("Оверрайды курса %s:\n\n", course))
 	for _, override := range overrides {
-		msg.WriteString(fmt.Sprintf(
+		text.WriteString(fmt.Sprintf(
 			"👉🏻 %s: за лабу %s ставим %d\nБазовый скор за эту лабу: %d\n❓(%s)\n\n",
 			override.Student,
 			override.Lab,
@@ -438,7 +511,7 @@ func (b *Bot) handleOverrideList(chatID int64, course string) error {
 		))
 	}
 
-	return b.sendMessage(chatID, msg.String())
+	return b.sendMessage(chatID, text.String())
 }
 
 func isActiveMember(member tgbotapi.ChatMember) bool {
@@ -460,21 +533,9 @@ func isActiveMember(member tgbotapi.ChatMember) bool {
 }
 
 // handleSetCourseCommand привязывает чат к курсу чтобы отслеживать join/leave события
-func (b *Bot) handleSetCourseCommand(msg *tgbotapi.Message) error {
-	if msg.Chat.Type == "private" {
-		return fmt.Errorf("Эту команду имеет смысл выполнять только в групповых чатах")
-	}
-
-	args := strings.Fields(msg.CommandArguments())
-	if len(args) < 1 {
-		return fmt.Errorf("использование: /set_course <course> [comment]")
-	}
-
-	course := strings.TrimSpace(args[0])
-	comment := ""
-	if len(args) > 1 {
-		comment = strings.Trim(strings.TrimSpace(args[1]), `"'`)
-	}
+func (b *Bot) handleSetCourseCommand(msg *tgbotapi.Message, args Args) error {
+	course := args.String("course")
+	comment := args.String("comment")
 	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
 	defer cancel()
 
@@ -556,7 +617,7 @@ func (b *Bot) handleSetCourseCommand(msg *tgbotapi.Message) error {
 	)
 
 	if len(presentStudents) > 0 {
-		report += "\nПосчитал:\n" + strings.Join(missingStudents, "\n")
+		report += "\nПосчитал:\n" + strings.Join(presentStudents, "\n")
 	}
 
 	if len(missingStudents) > 0 {
@@ -592,19 +653,13 @@ func (b *Bot) notifyAdminsAboutNewCourseChatAssociation(chatTitle, course, comme
 	}
 }
 
-func (b *Bot) handleMapStudentCommand(msg *tgbotapi.Message) error {
-	args := strings.Fields(msg.CommandArguments())
-	if len(args) != 2 {
-		return b.sendMessage(msg.Chat.ID, "Использование:\n"+
-			"/map_student @username student.name - ассоциировать студента @username со student.name")
-	}
-
-	tgUsername := strings.TrimPrefix(args[0], "@")
+func (b *Bot) handleMapStudentCommand(msg *tgbotapi.Message, args Args) error {
+	tgUsername := strings.TrimPrefix(args.String("username"), "@")
 	if tgUsername == "" {
 		return fmt.Errorf("invalid telegram username")
 	}
 
-	studentID := args[1]
+	studentID := args.String("student")
 	if !strings.Contains(studentID, ".") {
 		return fmt.Errorf("Неправильный формат studentID, должно быть: firstname.lastname")
 	}
@@ -612,30 +667,36 @@ func (b *Bot) handleMapStudentCommand(msg *tgbotapi.Message) error {
 	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
 	defer cancel()
 
-	mapping, err := b.tokenManager.FetchCourseMappingByChatID(ctx, msg.Chat.ID)
-	if err != nil {
-		return fmt.Errorf("этот чат не сассоциирован ни с каким курсом: %v. Попросите админа сделать /set_course", err)
+	course := args.String("course")
+	if course == "" {
+		mapping, err := b.tokenManager.FetchCourseMappingByChatID(ctx, msg.Chat.ID)
+		if err != nil {
+			return fmt.Errorf("этот чат не сассоциирован ни с каким курсом: %v. Попросите админа сделать /set_course или укажите course <course>", err)
+		}
+		course = mapping.Course
 	}
 
-	if err := b.tokenManager.SaveStudentTelegramMapping(ctx, mapping.Course, tgUsername, studentID); err != nil {
+	if err := b.tokenManager.SaveStudentTelegramMapping(ctx, course, tgUsername, studentID); err != nil {
 		return fmt.Errorf("failed to save student mapping: %w", err)
 	}
 
-	tokenInfo, isNewToken, err := b.tokenManager.FetchOrCreateStudentToken(ctx, mapping.Course, studentID)
-	if err != nil {
-		logger.Error.Printf("Failed to check existing token: %v", err)
-	}
+	// tokens are issued in private via /token, the plaintext is never shown in a group
+	tokenInfo, err := b.tokenManager.FetchStudentToken(ctx, course, studentID)
 
 	var tokenStatus string
-	if err == nil {
-		if isNewToken {
-			tokenStatus = "\nНовый токен сгенерирован тоже."
+	if err != nil {
+		tokenStatus = "\nТокена пока нет, студент получит его командой /token в личке бота."
+	} else {
+		tokenStatus = fmt.Sprintf(
+			"\nУ студента уже есть токен %s (запрошен, раз: %d, последний: %s)",
+			tokenInfo.Hint,
+			tokenInfo.RequestCount,
+			app.FormatTimestamp(tokenInfo.LastRequestTime.Unix(), b.config.Courses.Location(course), app.DefaultDisplayFormat),
+		)
+		if usage, err := b.tokenManager.FetchTokenUsage(ctx, course, studentID); err != nil {
+			logger.Error.Printf("Failed to fetch token usage of %s/%s: %v", course, studentID, err)
 		} else {
-			tokenStatus = fmt.Sprintf(
-				"\nУ студента уже есть токен (запрошен, раз: %d, последний: %s)",
-				tokenInfo.RequestCount,
-				tokenInfo.LastRequestTime.Format("2006-01-02 15:04:05 MST"),
-			)
+			tokenStatus += "\n" + b.tokenUsageLines(course, usage)
 		}
 	}
 
@@ -644,7 +705,7 @@ func (b *Bot) handleMapStudentCommand(msg *tgbotapi.Message) error {
 			"Course: %s\n"+
 			"Telegram: @%s\n"+
 			"Student ID: %s%s",
-		mapping.Course,
+		course,
 		tgUsername,
 		studentID,
 		tokenStatus,
@@ -660,7 +721,7 @@ func (b *Bot) handleMapStudentCommand(msg *tgbotapi.Message) error {
 			"Telegram: @%s\n"+
 			"Student ID: %s\n"+
 			"Mapped by: @%s",
-		mapping.Course,
+		course,
 		tgUsername,
 		studentID,
 		msg.From.UserName,
@@ -679,7 +740,7 @@ func (b *Bot) handleMapStudentCommand(msg *tgbotapi.Message) error {
 	return nil
 }
 
-func (b *Bot) handleNewCourseCommand(msg *tgbotapi.Message) error {
+func (b *Bot) handleNewCourseCommand(msg *tgbotapi.Message, _ Args) error {
 	lines := strings.Split(msg.Text, "\n")
 	if len(lines) < 2 {
 		return fmt.Errorf("Использование:\n/new_course COURSE_CODE\n@username1 student1.name\n@username2 student2.name")
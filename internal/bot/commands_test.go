@@ -0,0 +1,513 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+	"github.com/shrimpsizemoose/kanelbulle/internal/store/sqlite"
+)
+
+const (
+	testAdminID   int64 = 100
+	testStudentID int64 = 200
+	testGroupID   int64 = -1001
+)
+
+type sentMessage struct {
+	chatID    int64
+	messageID int
+	text      string
+	keyboard  *tgbotapi.InlineKeyboardMarkup
+}
+
+// fakeAPI records everything the bot sends, admin notifications arrive from goroutines
+type fakeAPI struct {
+	mu      sync.Mutex
+	sent    []sentMessage
+	answers []string
+	nextID  int
+	// onSend runs before every Send
+	onSend func()
+}
+
+func (f *fakeAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if f.onSend != nil {
+		f.onSend()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nextID++
+	switch c := c.(type) {
+	case tgbotapi.MessageConfig:
+		keyboard, _ := c.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+		msg := sentMessage{chatID: c.ChatID, messageID: f.nextID, text: c.Text}
+		if keyboard.InlineKeyboard != nil {
+			msg.keyboard = &keyboard
+		}
+		f.sent = append(f.sent, msg)
+	case tgbotapi.EditMessageTextConfig:
+		f.sent = append(f.sent, sentMessage{chatID: c.ChatID, messageID: c.MessageID, text: "edit: " + c.Text, keyboard: c.ReplyMarkup})
+	case tgbotapi.DocumentConfig:
+		file := c.File.(tgbotapi.FileBytes)
+		f.sent = append(f.sent, sentMessage{chatID: c.ChatID, messageID: f.nextID, text: "document: " + file.Name + "\n" + string(file.Bytes)})
+	default:
+		return tgbotapi.Message{}, fmt.Errorf("unexpected %T", c)
+	}
+	return tgbotapi.Message{MessageID: f.nextID}, nil
+}
+
+func (f *fakeAPI) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if answer, ok := c.(tgbotapi.CallbackConfig); ok {
+		f.answers = append(f.answers, answer.Text)
+	}
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (f *fakeAPI) GetChatMember(config tgbotapi.GetChatMemberConfig) (tgbotapi.ChatMember, error) {
+	return tgbotapi.ChatMember{Status: "member"}, nil
+}
+
+func (f *fakeAPI) GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel {
+	return make(chan tgbotapi.Update)
+}
+
+func (f *fakeAPI) texts(chatID int64) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var texts []string
+	for _, msg := range f.sent {
+		if msg.chatID == chatID {
+			texts = append(texts, msg.text)
+		}
+	}
+	return texts
+}
+
+func (f *fakeAPI) reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sent = nil
+}
+
+type testBot struct {
+	*Bot
+	api   *fakeAPI
+	store *sqlite.SQLiteStore
+}
+
+func newTestBot(t *testing.T) *testBot {
+	s, err := sqlite.NewSQLiteStore(":memory:", "../../migrations")
+	require.NoError(t, err)
+	// every connection to :memory: is a database of its own
+	s.DB.SetMaxOpenConns(1)
+	t.Cleanup(func() { s.Close() })
+
+	config := &Config{}
+	config.Auth.Backend = app.TokenBackendSQL
+	config.Bot.AdminIDs = []int64{testAdminID}
+	config.Events.Start = "000_lab_start"
+	config.Events.Finish = "100_lab_finish"
+	config.Courses = app.Courses{"DE15": {Timezone: "Europe/Moscow"}}
+
+	api := &fakeAPI{}
+	b, err := newBot(config, s, api)
+	require.NoError(t, err)
+	return &testBot{Bot: b, api: api, store: s}
+}
+
+func (tb *testBot) run(chatType string, chatID, fromID int64, username, text string) {
+	tb.handleMessage(commandMessage(chatType, chatID, fromID, username, text))
+}
+
+func (tb *testBot) admin(text string) {
+	tb.run("private", testAdminID, testAdminID, "teacher", text)
+}
+
+func (tb *testBot) adminInGroup(text string) {
+	tb.run("group", testGroupID, testAdminID, "teacher", text)
+}
+
+func (tb *testBot) student(text string) {
+	tb.run("private", testStudentID, testStudentID, "alice_tg", text)
+}
+
+// requireSent waits for a message to chatID containing every part
+func (tb *testBot) requireSent(t *testing.T, chatID int64, parts ...string) string {
+	t.Helper()
+	var found string
+	require.Eventually(t, func() bool {
+		for _, text := range tb.api.texts(chatID) {
+			if containsAll(text, parts) {
+				found = text
+				return true
+			}
+		}
+		return false
+	}, time.Second, 5*time.Millisecond, "no message to %d with %q, got %q", chatID, parts, tb.api.texts(chatID))
+	return found
+}
+
+func (tb *testBot) requireNotSent(t *testing.T, chatID int64, parts ...string) {
+	t.Helper()
+	for _, text := range tb.api.texts(chatID) {
+		assert.False(t, containsAll(text, parts), "unexpected message %q", text)
+	}
+}
+
+func containsAll(text string, parts []string) bool {
+	for _, part := range parts {
+		if !strings.Contains(text, part) {
+			return false
+		}
+	}
+	return true
+}
+
+// seedCourse registers DE15 with two students, a lab and a group chat
+func (tb *testBot) seedCourse(t *testing.T) {
+	tb.admin("/new_course DE15\n@alice_tg alice.a\n@bob_tg bob.b")
+	tb.admin("/lab add DE15 01s score 10 deadline 2099-01-01")
+	tb.adminInGroup("/set_course DE15")
+	tb.requireSent(t, testGroupID, "Chat associated with course DE15")
+	tb.api.reset()
+}
+
+func (tb *testBot) finish(t *testing.T, lab, student string, at time.Time) {
+	for _, event := range []struct {
+		eventType string
+		at        time.Time
+	}{{"000_lab_start", at.Add(-time.Hour)}, {"100_lab_finish", at}} {
+		require.NoError(t, tb.store.CreateEntry(&models.Entry{
+			Timestamp: event.at.Unix(),
+			EventType: event.eventType,
+			Lab:       lab,
+			Student:   student,
+			Course:    "DE15",
+		}))
+	}
+}
+
+func TestStartAndHelp(t *testing.T) {
+	tb := newTestBot(t)
+	tb.seedCourse(t)
+
+	tb.student("/help")
+	help := tb.requireSent(t, testStudentID, "Доступные команды:", "/token rotate", "/scores [lab]", "/deadlines [course]")
+	assert.NotContains(t, help, "/lab add")
+	assert.NotContains(t, help, "Примеры:")
+
+	tb.admin("/help")
+	tb.requireSent(t, testAdminID,
+		"/lab add <course> <lab> score <score> deadline <date>",
+		"/override set <course> <lab> <student> score <score> [reason <reason>...]",
+		"Примеры:",
+		`/override set DE15 01s student.name score 8 reason "Late submission accepted"`,
+	)
+
+	tb.student("/start")
+	tb.requireSent(t, testStudentID, "Привет!", "Вы студент курса `DE15`", "/token")
+
+	tb.admin("/start")
+	tb.requireSent(t, testAdminID, "Ты администратор бота.", "/stats <course> [lab]")
+
+	t.Run("students don't see admin commands", func(t *testing.T) {
+		tb.api.reset()
+		tb.student("/lab list DE15")
+		tb.student("/override")
+		texts := tb.api.texts(testStudentID)
+		require.Len(t, texts, 2)
+		for _, text := range texts {
+			assert.Contains(t, text, "Отправьте /help для списка команд")
+		}
+	})
+
+	t.Run("group usage", func(t *testing.T) {
+		tb.admin("/team")
+		tb.requireSent(t, testAdminID, "Использование:\n/team add <course> <lab> <team> <students>...", "/team remove <course> <lab> <team>")
+	})
+}
+
+func TestTokenCommands(t *testing.T) {
+	tb := newTestBot(t)
+	tb.seedCourse(t)
+
+	tb.student("/token")
+	issued := tb.requireSent(t, testStudentID, "🧩 DE15", "Student: `alice.a`", "Сохрани его")
+	tb.requireSent(t, testAdminID, "New token created", "alice.a")
+
+	tb.api.reset()
+	tb.student("/token")
+	tb.requireSent(t, testStudentID, "Токен уже выдан")
+
+	tb.student("/token rotate")
+	rotated := tb.requireSent(t, testStudentID, "Новый токен", "Старый больше не работает")
+	assert.NotEqual(t, issued, rotated)
+
+	t.Run("in group", func(t *testing.T) {
+		tb.api.reset()
+		tb.run("group", testGroupID, testStudentID, "alice_tg", "/token rotate")
+		assert.Equal(t, []string{"Команда /token имеет смысл только в приватном чате"}, tb.api.texts(testGroupID))
+		assert.Empty(t, tb.api.texts(testStudentID))
+	})
+
+	t.Run("unknown student", func(t *testing.T) {
+		tb.run("private", 300, 300, "stranger", "/token")
+		tb.requireSent(t, 300, "Error: Не признал")
+	})
+
+	t.Run("extra argument", func(t *testing.T) {
+		tb.student("/token please")
+		tb.requireSent(t, testStudentID, `Error: лишний аргумент "please"`)
+	})
+
+	t.Run("tokeninfo and revoke", func(t *testing.T) {
+		tb.admin("/tokeninfo DE15 alice.a")
+		tb.requireSent(t, testAdminID, "🔑 DE15/alice.a", "Бот: запрошен раз")
+
+		tb.admin("/revoke DE15 alice.a")
+		tb.requireSent(t, testAdminID, "⛔ Токен DE15/alice.a отозван")
+
+		tb.api.reset()
+		tb.student("/token")
+		tb.requireSent(t, testStudentID, "Токен отозван администратором")
+
+		tb.admin("/tokeninfo DE15")
+		tb.requireSent(t, testAdminID, "не хватает аргумента <student>\nИспользование: /tokeninfo <course> <student>")
+	})
+}
+
+func TestLabCommands(t *testing.T) {
+	tb := newTestBot(t)
+
+	tb.admin("/lab add DE15 02s deadline 2024-12-08T18:00 score 12")
+	tb.requireSent(t, testAdminID, "✅ Лабораторная 02s для курса DE15 добавлена", "Баллы: 12", "Дедлайн: 2024-12-08 18:00 MSK (Europe/Moscow)")
+
+	lab, err := tb.store.GetLabScore("DE15", "02s")
+	require.NoError(t, err)
+	require.NotNil(t, lab)
+	assert.Equal(t, time.Date(2024, 12, 8, 15, 0, 0, 0, time.UTC).Unix(), lab.Deadline)
+
+	tb.admin("/lab add DE15 02s score 15 deadline 2024-12-09")
+	tb.requireSent(t, testAdminID, "02s для курса DE15 обновлена", "Баллы: 15")
+
+	tb.admin("/lab list DE15")
+	tb.requireSent(t, testAdminID, "Лабораторные работы курса DE15:", "📝 02s (баллы: 15)")
+
+	tb.admin("/lab add DE15 03s score ten deadline 2024-12-09")
+	tb.requireSent(t, testAdminID, `Error: score должно быть целым числом, а не "ten"`, "Использование: /lab add")
+
+	tb.admin("/lab add DE15 03s score 10")
+	tb.requireSent(t, testAdminID, "Error: не хватает аргумента deadline <date>")
+
+	labs, err := tb.store.ListLabScores("DE15")
+	require.NoError(t, err)
+	assert.Len(t, labs, 1)
+}
+
+func TestOverrideCommands(t *testing.T) {
+	tb := newTestBot(t)
+	tb.seedCourse(t)
+
+	tb.admin(`/override set DE15 01s alice.a score 8 reason "Late submission accepted"`)
+	tb.requireSent(t, testAdminID,
+		"✅ Оверрайд для студента DE15/01s/alice.a добавлен",
+		"Баллы: 8",
+		"Причина: Late submission accepted",
+	)
+
+	override, err := tb.store.GetScoreOverride("DE15", "01s", "alice.a")
+	require.NoError(t, err)
+	require.NotNil(t, override)
+	assert.Equal(t, 8, override.Score)
+	assert.Equal(t, "Late submission accepted", override.Reason)
+
+	tb.admin("/override set DE15 01s alice.a score 9 reason сдал устно")
+	tb.requireSent(t, testAdminID, "DE15/01s/alice.a обновлён", "Причина: сдал устно")
+
+	t.Run("team target", func(t *testing.T) {
+		tb.admin("/team add DE15 01s owls alice.a bob.b")
+		tb.admin("/override set DE15 01s team:owls score 7")
+		tb.requireSent(t, testAdminID, "DE15/01s/team:owls добавлен, у alice.a обновлён")
+
+		for _, student := range []string{"alice.a", "bob.b"} {
+			override, err := tb.store.GetScoreOverride("DE15", "01s", student)
+			require.NoError(t, err)
+			require.NotNil(t, override)
+			assert.Equal(t, 7, override.Score)
+		}
+
+		tb.admin("/override set DE15 01s team:owls score 6")
+		tb.requireSent(t, testAdminID, "DE15/01s/team:owls обновлён")
+
+		tb.admin("/team add DE15 01s cats carol.c alice.b")
+		tb.admin("/override set DE15 01s team:cats score 5")
+		tb.requireSent(t, testAdminID, "DE15/01s/team:cats добавлен")
+	})
+
+	tb.admin("/override list DE15")
+	tb.requireSent(t, testAdminID, "Оверрайды курса DE15:", "👉🏻 bob.b: за лабу 01s ставим 6", "Базовый скор за эту лабу: 10")
+}
+
+func TestTeamCommands(t *testing.T) {
+	tb := newTestBot(t)
+	tb.seedCourse(t)
+
+	tb.admin("/team add DE15 01s owls alice.a bob.b")
+	tb.requireSent(t, testAdminID, "✅ Команда owls для DE15/01s: alice.a, bob.b")
+
+	tb.admin("/team list DE15")
+	tb.requireSent(t, testAdminID, "Команды курса DE15:", "👥 01s owls: alice.a, bob.b")
+
+	tb.api.reset()
+	tb.admin("/team list DE15 02s")
+	tb.requireSent(t, testAdminID, "В курсе DE15 нет команд")
+
+	tb.admin("/team remove DE15 01s owls")
+	tb.requireSent(t, testAdminID, "🗑 Команда owls удалена из DE15/01s")
+
+	members, err := tb.store.ListTeamMembers("DE15")
+	require.NoError(t, err)
+	assert.Empty(t, members)
+}
+
+func TestScoringCommands(t *testing.T) {
+	tb := newTestBot(t)
+	tb.seedCourse(t)
+	tb.finish(t, "01s", "alice.a", time.Now().Add(-time.Hour))
+
+	t.Run("scores", func(t *testing.T) {
+		tb.run("group", testGroupID, testStudentID, "alice_tg", "/scores")
+		tb.requireSent(t, testStudentID, "📊 DE15, alice.a", "✅ 01s: 10/10", "Итого: 10 из 10")
+		assert.Empty(t, tb.api.texts(testGroupID))
+
+		tb.student("/scores 09s")
+		tb.requireSent(t, testStudentID, "Лабы 09s нет в курсе DE15")
+	})
+
+	t.Run("deadlines", func(t *testing.T) {
+		tb.student("/deadlines")
+		tb.requireSent(t, testStudentID, "📅 Дедлайны курса DE15 (Europe/Moscow)", "📝 01s (баллы: 10)")
+
+		tb.admin("/deadlines DE16")
+		tb.requireSent(t, testAdminID, "У курса DE16 нет предстоящих дедлайнов")
+	})
+
+	t.Run("simulate", func(t *testing.T) {
+		tb.admin("/simulate DE15 deadline 01s 2020-01-01")
+		tb.requireSent(t, testAdminID, "🔮 Симуляция для курса DE15 (ничего не сохранено)", "Затронуто студентов: 1")
+
+		tb.admin("/simulate DE15 strategy nope")
+		tb.requireSent(t, testAdminID, "Error:")
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		tb.admin("/stats DE15")
+		tb.requireSent(t, testAdminID, "📈 DE15, студентов: 2", "📝 01s: сдали 1/2 (50%)")
+
+		tb.admin("/stats DE15 01s")
+		tb.requireSent(t, testAdminID, "Баллы:\n  10: 1 чел.", "🐢 Дольше всех сдавали:\n  alice.a")
+
+		tb.admin("/stats DE15 09s")
+		tb.requireSent(t, testAdminID, "По лабе 09s курса DE15 нет данных")
+	})
+
+	t.Run("snapshots", func(t *testing.T) {
+		tb.admin("/snapshot create DE15 final-2024")
+		tb.requireSent(t, testAdminID, "🧊 Ведомость final-2024 для курса DE15 заморожена", "Студентов: 2")
+
+		tb.admin("/snapshot list DE15")
+		tb.requireSent(t, testAdminID, "Ведомости курса DE15:", "🧊 final-2024", "@teacher")
+
+		tb.admin("/snapshot get DE15 final-2024")
+		tb.requireSent(t, testAdminID, "document: DE15-final-2024.json", "alice.a")
+
+		tb.admin("/override set DE15 01s bob.b score 5")
+		tb.admin("/snapshot diff DE15 final-2024 live")
+		tb.requireSent(t, testAdminID, "🔍 DE15: final-2024 → live", "👉🏻 bob.b: 0 → 5")
+	})
+}
+
+func TestAPIKeyCommands(t *testing.T) {
+	tb := newTestBot(t)
+
+	tb.adminInGroup("/apikey add grafana read-stats")
+	tb.requireSent(t, testGroupID, "Error: команду /apikey add можно выполнять только в личке с ботом")
+
+	tb.admin("/apikey add grafana read-stats,read-scores DE15")
+	tb.requireSent(t, testAdminID, "🔑 Ключ grafana", "Права: read-stats, read-scores", "Курсы: DE15", app.DefaultAPIKeyHeader)
+
+	tb.admin("/apikey add broken nope")
+	tb.requireSent(t, testAdminID, "Error: некорректные права")
+
+	tb.admin("/apikey list")
+	tb.requireSent(t, testAdminID, "🔑 Ключи API:", "grafana", "курсы: DE15", "выдал @teacher")
+
+	tb.admin("/apikey revoke grafana")
+	tb.requireSent(t, testAdminID, "🔒 Ключ API `grafana` отозван (@teacher)")
+
+	keys, err := tb.apiKeys.List()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.NotNil(t, keys[0].RevokedAt)
+}
+
+func TestCourseSetupCommands(t *testing.T) {
+	tb := newTestBot(t)
+
+	tb.admin("/new_course DE15\n@alice_tg alice.a\n@bob_tg bob\nbroken line here")
+	tb.requireSent(t, testAdminID, "📚 Course DE15 setup", "@alice_tg -> alice.a", "Invalid student ID format: bob", "Invalid line format: broken line here")
+
+	tb.admin("/set_course DE15")
+	tb.requireSent(t, testAdminID, "Error: команду /set_course имеет смысл выполнять только в групповых чатах")
+
+	tb.adminInGroup(`/set_course DE15 "Дамокловы Экивоки 14+"`)
+	tb.requireSent(t, testGroupID, "edit: ✅ Chat associated with course DE15", "Present: 1 students", "Посчитал:\n@alice_tg (member)")
+	tb.requireSent(t, testAdminID, "Course association updated", "Comment: Дамокловы Экивоки 14+")
+
+	tb.adminInGroup("/map_student @carol_tg carol.c")
+	tb.requireSent(t, testGroupID, "✅ Student mapping created", "Telegram: @carol_tg", "Student ID: carol.c", "Токена пока нет")
+
+	tb.adminInGroup("/map_student carol_tg")
+	tb.requireSent(t, testGroupID, "Использование: /map_student <@username> <student.name>")
+
+	t.Run("chats", func(t *testing.T) {
+		tb.admin("/chat list")
+		tb.requireSent(t, testAdminID, "💬 Чаты курсов:", fmt.Sprintf("DE15: DE15 chat (%d)", testGroupID), "Дамокловы Экивоки 14+")
+
+		tb.admin("/chat unlink")
+		tb.requireSent(t, testAdminID, "Error: использование: /chat unlink <chat_id>")
+
+		tb.admin("/chat unlink abc")
+		tb.requireSent(t, testAdminID, `Error: chat_id должно быть целым числом, а не "abc"`)
+
+		tb.adminInGroup("/chat unlink")
+		tb.requireSent(t, testGroupID, fmt.Sprintf("🔌 Чат DE15 chat (%d) отвязан от курса DE15", testGroupID))
+
+		tb.api.reset()
+		tb.admin("/chat list DE15")
+		tb.requireSent(t, testAdminID, "Привязанных чатов нет")
+
+		tb.admin(fmt.Sprintf("/chat unlink %d", testGroupID))
+		tb.requireSent(t, testAdminID, fmt.Sprintf("Error: чат %d не привязан ни к какому курсу", testGroupID))
+	})
+
+	t.Run("map student without course chat", func(t *testing.T) {
+		tb.adminInGroup("/map_student @dan_tg dan.d")
+		tb.requireSent(t, testGroupID, "Error: этот чат не сассоциирован ни с каким курсом")
+		tb.requireNotSent(t, testGroupID, "Student ID: dan.d")
+	})
+}
@@ -5,28 +5,35 @@ import (
 	"os"
 
 	"github.com/pelletier/go-toml/v2"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+	"github.com/shrimpsizemoose/kanelbulle/internal/scoring"
 )
 
 type Config struct {
 	Auth struct {
-		Enabled          bool   `toml:"enabled"`
+		Enabled bool `toml:"enabled"`
+		// Backend keeps tokens and telegram mappings in "redis" (default) or "sql"
+		Backend          string `toml:"backend"`
 		RedisURL         string `toml:"redis_url"`
 		TokenHeader      string `toml:"token_header"`
 		TokenKeyTemplate string `toml:"token_key_template"`
+		TokenTTL         string `toml:"token_ttl"`
 	} `toml:"auth"`
 	Bot struct {
 		Token    string  `toml:"token"`
 		AdminIDs []int64 `toml:"admin_ids"`
 	} `toml:"bot"`
+	Reminders ReminderConfig `toml:"reminders"`
+	Events    struct {
+		Start  string `toml:"start"`
+		Finish string `toml:"finish"`
+	} `toml:"events"`
+	Courses  app.Courses `toml:"courses"`
 	Database struct {
 		DSN string `toml:"dsn"`
 	} `toml:"database"`
-	Scoring struct {
-		LateDaysModifiers  map[int]int `toml:"late_days_modifiers"`
-		DefaultLatePenalty float64     `toml:"default_late_penalty"`
-		MaxLateDays        int         `toml:"max_late_days"`
-		ExtraLatePenalty   int         `toml:"extra_late_penalty"`
-	} `toml:"scoring"`
+	Scoring scoring.Policy `toml:"scoring"`
 }
 
 func ReadConfig(path string) (*Config, error) {
@@ -41,5 +48,13 @@ func ReadConfig(path string) (*Config, error) {
 		return nil, fmt.Errorf("Failed to load config: %v", err)
 	}
 
+	if err := cfg.Courses.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Reminders.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &cfg, nil
 }
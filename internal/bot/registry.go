@@ -0,0 +1,462 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+)
+
+// Commands are declared once with their arguments. The registry splits the
+// message shell-style, fills named typed arguments, checks who may run the
+// command and where, and renders usage and help from the same declarations.
+
+type Permission int
+
+const (
+	// PermStudent commands are open to everyone
+	PermStudent Permission = iota
+	PermAdmin
+)
+
+type ChatScope int
+
+const (
+	ChatAny ChatScope = iota
+	ChatPrivate
+	ChatGroup
+)
+
+type ArgKind int
+
+const (
+	ArgString ArgKind = iota
+	ArgInt
+	ArgFloat
+	// ArgDeadline is YYYY-MM-DD or YYYY-MM-DDTHH:MM in the timezone of the "course" argument
+	ArgDeadline
+)
+
+type Arg struct {
+	Name string
+	Kind ArgKind
+	// Optional arguments may be left out, everything else is required
+	Optional bool
+	// Keyword arguments are written as "name value" anywhere in the command,
+	// Placeholder names the value in usage and defaults to the name
+	Keyword     bool
+	Placeholder string
+	// Rest takes every remaining word, it goes last
+	Rest bool
+}
+
+func (a Arg) placeholder() string {
+	if a.Placeholder != "" {
+		return a.Placeholder
+	}
+	return a.Name
+}
+
+func (a Arg) usage() string {
+	value := "<" + a.placeholder() + ">"
+	if a.Optional && !a.Keyword {
+		value = a.placeholder()
+	}
+	if a.Rest {
+		value += "..."
+	}
+	if a.Keyword {
+		value = a.Name + " " + value
+	}
+	if a.Optional {
+		return "[" + value + "]"
+	}
+	return value
+}
+
+type Command struct {
+	// Name is the command with its subcommand, like "lab add"
+	Name    string
+	Summary string
+	Args    []Arg
+	// Usage replaces the generated usage of commands with a syntax of their own
+	Usage string
+	// Raw commands get no parsed arguments and read msg.Text themselves
+	Raw        bool
+	Permission Permission
+	Chat       ChatScope
+	Examples   []string
+	Handler    func(msg *tgbotapi.Message, args Args) error
+	// Interactive runs instead of showing the usage when the command comes
+	// without arguments, wizards start this way
+	Interactive func(msg *tgbotapi.Message) error
+}
+
+// usage is the command line as shown in help, like /lab list <course>
+func (c *Command) usage() string {
+	if c.Usage != "" {
+		return c.Usage
+	}
+	parts := []string{"/" + c.Name}
+	for _, arg := range c.Args {
+		parts = append(parts, arg.usage())
+	}
+	return strings.Join(parts, " ")
+}
+
+func (c *Command) helpLine() string {
+	line := c.usage() + " - " + c.Summary
+	if c.Interactive != nil {
+		line += " (без аргументов - по шагам)"
+	}
+	return line
+}
+
+// words turns argument values back into words Parse understands
+func (c *Command) words(values map[string]string) []string {
+	var words []string
+	for _, arg := range c.Args {
+		value, ok := values[arg.Name]
+		if !ok {
+			continue
+		}
+		if arg.Keyword {
+			words = append(words, arg.Name)
+		}
+		words = append(words, value)
+	}
+	return words
+}
+
+func (c *Command) keyword(word string) *Arg {
+	for i := range c.Args {
+		if c.Args[i].Keyword && c.Args[i].Name == word {
+			return &c.Args[i]
+		}
+	}
+	return nil
+}
+
+func (c *Command) requiresArgs() bool {
+	for _, arg := range c.Args {
+		if !arg.Optional {
+			return true
+		}
+	}
+	return false
+}
+
+// Parse fills the arguments from words, locate gives the timezone for deadlines
+func (c *Command) Parse(words []string, locate func(course string) *time.Location) (Args, error) {
+	var positional []Arg
+	for _, arg := range c.Args {
+		if !arg.Keyword {
+			positional = append(positional, arg)
+		}
+	}
+
+	raw := make(map[string][]string)
+	pos := 0
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if kw := c.keyword(word); kw != nil && raw[kw.Name] == nil {
+			if i+1 >= len(words) {
+				return Args{}, fmt.Errorf("пропущено значение для %s", kw.Name)
+			}
+			if kw.Rest {
+				raw[kw.Name] = words[i+1:]
+				break
+			}
+			raw[kw.Name] = []string{words[i+1]}
+			i++
+			continue
+		}
+
+		if pos >= len(positional) {
+			return Args{}, fmt.Errorf("лишний аргумент %q", word)
+		}
+		arg := positional[pos]
+		raw[arg.Name] = append(raw[arg.Name], word)
+		if !arg.Rest {
+			pos++
+		}
+	}
+
+	args := Args{values: make(map[string]interface{}, len(raw))}
+	for _, arg := range c.Args {
+		words, ok := raw[arg.Name]
+		if !ok {
+			if !arg.Optional {
+				return Args{}, fmt.Errorf("не хватает аргумента %s", arg.usage())
+			}
+			continue
+		}
+
+		if arg.Rest {
+			args.values[arg.Name] = words
+			continue
+		}
+
+		value, err := convertArg(arg, words[0], raw, locate)
+		if err != nil {
+			return Args{}, err
+		}
+		args.values[arg.Name] = value
+	}
+	return args, nil
+}
+
+func convertArg(arg Arg, word string, raw map[string][]string, locate func(course string) *time.Location) (interface{}, error) {
+	switch arg.Kind {
+	case ArgInt:
+		value, err := strconv.ParseInt(word, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s должно быть целым числом, а не %q", arg.placeholder(), word)
+		}
+		return value, nil
+	case ArgFloat:
+		value, err := strconv.ParseFloat(word, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s должно быть числом, а не %q", arg.placeholder(), word)
+		}
+		return value, nil
+	case ArgDeadline:
+		course := ""
+		if courses := raw["course"]; len(courses) > 0 {
+			course = courses[0]
+		}
+		deadline, err := app.ParseDeadline(word, locate(course))
+		if err != nil {
+			return nil, fmt.Errorf("некорректная дата: %v", err)
+		}
+		return deadline, nil
+	default:
+		return word, nil
+	}
+}
+
+// Args are the parsed arguments, getters return zero values for missing optional ones
+type Args struct {
+	values map[string]interface{}
+}
+
+func (a Args) Has(name string) bool {
+	_, ok := a.values[name]
+	return ok
+}
+
+// String returns a string argument, or the words of a rest argument joined by spaces
+func (a Args) String(name string) string {
+	switch value := a.values[name].(type) {
+	case string:
+		return value
+	case []string:
+		return strings.Join(value, " ")
+	default:
+		return ""
+	}
+}
+
+func (a Args) Strings(name string) []string {
+	words, _ := a.values[name].([]string)
+	return words
+}
+
+func (a Args) Int64(name string) int64 {
+	value, _ := a.values[name].(int64)
+	return value
+}
+
+func (a Args) Int(name string) int {
+	return int(a.Int64(name))
+}
+
+func (a Args) Float(name string) float64 {
+	value, _ := a.values[name].(float64)
+	return value
+}
+
+func (a Args) Time(name string) time.Time {
+	value, _ := a.values[name].(time.Time)
+	return value
+}
+
+type Registry struct {
+	commands []*Command
+	locate   func(course string) *time.Location
+}
+
+func NewRegistry(locate func(course string) *time.Location) *Registry {
+	return &Registry{locate: locate}
+}
+
+func (r *Registry) Register(commands ...Command) {
+	for i := range commands {
+		r.commands = append(r.commands, &commands[i])
+	}
+}
+
+func (r *Registry) lookup(name string) *Command {
+	for _, cmd := range r.commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// Find picks the subcommand when the first word names one, the rest of the
+// words are the arguments
+func (r *Registry) Find(command string, words []string) (*Command, []string) {
+	if len(words) > 0 {
+		if cmd := r.lookup(command + " " + words[0]); cmd != nil {
+			return cmd, words[1:]
+		}
+	}
+	return r.lookup(command), words
+}
+
+// GroupUsage lists the subcommands of command available with perm
+func (r *Registry) GroupUsage(command string, perm Permission) string {
+	var lines []string
+	for _, cmd := range r.commands {
+		if strings.HasPrefix(cmd.Name, command+" ") && cmd.Permission <= perm {
+			lines = append(lines, cmd.helpLine())
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Help lists every command available with perm, admins also get the examples
+func (r *Registry) Help(perm Permission) string {
+	var lines, examples []string
+	for _, cmd := range r.commands {
+		if cmd.Permission > perm {
+			continue
+		}
+		lines = append(lines, cmd.helpLine())
+		if perm == PermAdmin {
+			examples = append(examples, cmd.Examples...)
+		}
+	}
+
+	help := "Доступные команды:\n" + strings.Join(lines, "\n")
+	if len(examples) > 0 {
+		help += "\n\nПримеры:\n" + strings.Join(examples, "\n")
+	}
+	return help
+}
+
+// Run finds the command of msg and runs it. Commands unknown at this permission
+// level answer false so the caller can show the generic help.
+func (r *Registry) Run(msg *tgbotapi.Message, perm Permission, reply func(text string) error) (bool, error) {
+	// raw commands read the text themselves, so a stray quote there is no error
+	words, splitErr := SplitArgs(msg.CommandArguments())
+	if splitErr != nil {
+		words = strings.Fields(msg.CommandArguments())
+	}
+
+	cmd, rest := r.Find(msg.Command(), words)
+	if cmd == nil || cmd.Permission > perm {
+		if usage := r.GroupUsage(msg.Command(), perm); usage != "" {
+			return true, reply("Использование:\n" + usage)
+		}
+		return false, nil
+	}
+
+	switch {
+	case cmd.Chat == ChatPrivate && msg.Chat.Type != "private":
+		return true, fmt.Errorf("команду /%s можно выполнять только в личке с ботом", cmd.Name)
+	case cmd.Chat == ChatGroup && msg.Chat.Type == "private":
+		return true, fmt.Errorf("команду /%s имеет смысл выполнять только в групповых чатах", cmd.Name)
+	}
+
+	if cmd.Raw {
+		return true, cmd.Handler(msg, Args{})
+	}
+	if splitErr != nil {
+		return true, splitErr
+	}
+	if len(rest) == 0 && cmd.requiresArgs() {
+		if cmd.Interactive != nil {
+			return true, cmd.Interactive(msg)
+		}
+		return true, reply("Использование:\n" + cmd.helpLine())
+	}
+
+	args, err := cmd.Parse(rest, r.locate)
+	if err != nil {
+		return true, fmt.Errorf("%v\nИспользование: %s", err, cmd.usage())
+	}
+	return true, cmd.Handler(msg, args)
+}
+
+// quotePairs maps opening quotes to closing ones, phones like to replace
+// straight quotes with typographic ones
+var quotePairs = map[rune]string{
+	'"':  `"`,
+	'\'': `'`,
+	'“':  `”“`,
+	'„':  `“”`,
+	'«':  `»`,
+}
+
+// SplitArgs splits text into words like a shell: quotes keep spaces inside a
+// word and a backslash escapes the next character outside single quotes.
+// Unlike a shell only a quote starting a word opens it, so apostrophes in
+// words like O'Brien stay as they are.
+func SplitArgs(text string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		closing string
+		quote   rune
+		escaped bool
+	)
+
+	for _, r := range text {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if strings.ContainsRune(closing, r) {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quotePairs[r] != "" && !inWord:
+			quote, closing = r, quotePairs[r]
+			inWord = true
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("не закрыта кавычка %c", quote)
+	}
+	if escaped {
+		word.WriteRune('\\')
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,292 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// commandMessage builds a message the way telegram sends it, with the command entity
+func commandMessage(chatType string, chatID, fromID int64, username, text string) *tgbotapi.Message {
+	command := strings.Fields(text)[0]
+	return &tgbotapi.Message{
+		MessageID: 1,
+		Text:      text,
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: chatType, Title: "DE15 chat"},
+		From:      &tgbotapi.User{ID: fromID, UserName: username},
+		Entities:  []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"plain", "  DE15 01s   alice.a ", []string{"DE15", "01s", "alice.a"}},
+		{"double quotes", `reason "Late submission accepted"`, []string{"reason", "Late submission accepted"}},
+		{"single quotes keep backslash", `'a\b c'`, []string{`a\b c`}},
+		{"typographic quotes", "reason “Сдал устно” ok", []string{"reason", "Сдал устно", "ok"}},
+		{"low typographic quotes", "„Сдал устно“", []string{"Сдал устно"}},
+		{"guillemets", "«Дамокловы Экивоки 14+»", []string{"Дамокловы Экивоки 14+"}},
+		{"escaped space", `Late\ submission`, []string{"Late submission"}},
+		{"escaped quote", `say \"hi\"`, []string{"say", `"hi"`}},
+		{"apostrophe inside word", "O'Brien isn't", []string{"O'Brien", "isn't"}},
+		{"empty quoted word", `a "" b`, []string{"a", "", "b"}},
+		{"quote glued to word", `"late"submission`, []string{"latesubmission"}},
+		{"multiline", "a\nb", []string{"a", "b"}},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitArgs(tt.text)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := SplitArgs(`reason "unterminated`)
+	assert.Error(t, err)
+	_, err = SplitArgs("«unterminated")
+	assert.Error(t, err)
+}
+
+func testCommand() *Command {
+	return &Command{
+		Name: "override set",
+		Args: []Arg{
+			{Name: "course"},
+			{Name: "lab"},
+			{Name: "student"},
+			{Name: "score", Kind: ArgInt, Keyword: true},
+			{Name: "deadline", Kind: ArgDeadline, Keyword: true, Optional: true, Placeholder: "date"},
+			{Name: "reason", Keyword: true, Rest: true, Optional: true},
+		},
+	}
+}
+
+func TestCommandParse(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	locate := func(course string) *time.Location {
+		if course == "DE15" {
+			return moscow
+		}
+		return time.UTC
+	}
+	cmd := testCommand()
+
+	args, err := cmd.Parse([]string{"DE15", "01s", "alice.a", "score", "8", "reason", "Late submission", "accepted"}, locate)
+	require.NoError(t, err)
+	assert.Equal(t, "DE15", args.String("course"))
+	assert.Equal(t, "01s", args.String("lab"))
+	assert.Equal(t, "alice.a", args.String("student"))
+	assert.Equal(t, 8, args.Int("score"))
+	assert.Equal(t, "Late submission accepted", args.String("reason"))
+	assert.Equal(t, []string{"Late submission", "accepted"}, args.Strings("reason"))
+	assert.False(t, args.Has("deadline"))
+	assert.True(t, args.Time("deadline").IsZero())
+
+	t.Run("keywords in any order", func(t *testing.T) {
+		args, err := cmd.Parse([]string{"DE15", "score", "-2", "01s", "deadline", "2024-12-01T18:00", "alice.a"}, locate)
+		require.NoError(t, err)
+		assert.Equal(t, -2, args.Int("score"))
+		assert.Equal(t, "alice.a", args.String("student"))
+		assert.Equal(t, time.Date(2024, 12, 1, 18, 0, 0, 0, moscow).Unix(), args.Time("deadline").Unix())
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		tests := []struct {
+			words []string
+			want  string
+		}{
+			{[]string{"DE15", "01s", "alice.a"}, "не хватает аргумента score <score>"},
+			{[]string{"DE15", "01s", "score", "8"}, "не хватает аргумента <student>"},
+			{[]string{"DE15", "01s", "alice.a", "score"}, "пропущено значение для score"},
+			{[]string{"DE15", "01s", "alice.a", "score", "eight"}, `score должно быть целым числом, а не "eight"`},
+			{[]string{"DE15", "01s", "alice.a", "bob.b", "score", "8"}, `лишний аргумент "bob.b"`},
+			{[]string{"DE15", "01s", "alice.a", "score", "8", "deadline", "tomorrow"}, "некорректная дата"},
+		}
+		for _, tt := range tests {
+			_, err := cmd.Parse(tt.words, locate)
+			require.Error(t, err, tt.words)
+			assert.Contains(t, err.Error(), tt.want)
+		}
+	})
+
+	t.Run("second keyword is a value", func(t *testing.T) {
+		args, err := cmd.Parse([]string{"DE15", "01s", "alice.a", "score", "8", "reason", "score", "was", "wrong"}, locate)
+		require.NoError(t, err)
+		assert.Equal(t, "score was wrong", args.String("reason"))
+	})
+
+	t.Run("positional rest and floats", func(t *testing.T) {
+		cmd := &Command{Name: "team add", Args: []Arg{
+			{Name: "team"},
+			{Name: "weight", Kind: ArgFloat, Keyword: true, Optional: true},
+			{Name: "students", Rest: true},
+		}}
+		args, err := cmd.Parse([]string{"owls", "alice.a", "weight", "0.5", "bob.b"}, locate)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"alice.a", "bob.b"}, args.Strings("students"))
+		assert.Equal(t, 0.5, args.Float("weight"))
+
+		_, err = cmd.Parse([]string{"owls"}, locate)
+		assert.EqualError(t, err, "не хватает аргумента <students>...")
+	})
+}
+
+func TestCommandUsage(t *testing.T) {
+	assert.Equal(t,
+		"/override set <course> <lab> <student> score <score> [deadline <date>] [reason <reason>...]",
+		testCommand().usage(),
+	)
+
+	cmd := &Command{Name: "team list", Args: []Arg{{Name: "course"}, {Name: "lab", Optional: true}, {Name: "rest", Optional: true, Rest: true}}}
+	assert.Equal(t, "/team list <course> [lab] [rest...]", cmd.usage())
+
+	cmd.Usage = "/team list <course> whatever"
+	assert.Equal(t, "/team list <course> whatever", cmd.usage())
+}
+
+func newTestRegistry(calls *[]string) *Registry {
+	record := func(name string) func(*tgbotapi.Message, Args) error {
+		return func(msg *tgbotapi.Message, args Args) error {
+			*calls = append(*calls, name+":"+args.String("course"))
+			return nil
+		}
+	}
+
+	r := NewRegistry(func(string) *time.Location { return time.UTC })
+	r.Register(
+		Command{Name: "help", Summary: "Помощь", Raw: true, Handler: record("help")},
+		Command{Name: "lab add", Summary: "Добавить лабу", Args: []Arg{{Name: "course"}, {Name: "lab"}}, Permission: PermAdmin,
+			Examples: []string{"/lab add DE15 01s"}, Handler: record("lab add")},
+		Command{Name: "lab list", Summary: "Список лаб", Args: []Arg{{Name: "course"}}, Permission: PermAdmin, Handler: record("lab list")},
+		Command{Name: "key", Summary: "Ключ", Chat: ChatPrivate, Handler: record("key")},
+		Command{Name: "link", Summary: "Привязать", Args: []Arg{{Name: "course"}}, Chat: ChatGroup, Handler: record("link")},
+	)
+	return r
+}
+
+func TestRegistryHelp(t *testing.T) {
+	r := newTestRegistry(new([]string))
+
+	assert.Equal(t, "Доступные команды:\n"+
+		"/help - Помощь\n"+
+		"/key - Ключ\n"+
+		"/link <course> - Привязать",
+		r.Help(PermStudent))
+
+	assert.Equal(t, "Доступные команды:\n"+
+		"/help - Помощь\n"+
+		"/lab add <course> <lab> - Добавить лабу\n"+
+		"/lab list <course> - Список лаб\n"+
+		"/key - Ключ\n"+
+		"/link <course> - Привязать\n\n"+
+		"Примеры:\n"+
+		"/lab add DE15 01s",
+		r.Help(PermAdmin))
+
+	assert.Equal(t, "/lab add <course> <lab> - Добавить лабу\n/lab list <course> - Список лаб", r.GroupUsage("lab", PermAdmin))
+	assert.Empty(t, r.GroupUsage("lab", PermStudent))
+}
+
+func TestRegistryRun(t *testing.T) {
+	var calls, replies []string
+	r := newTestRegistry(&calls)
+	reply := func(text string) error {
+		replies = append(replies, text)
+		return nil
+	}
+	run := func(perm Permission, chatType, text string) (bool, error) {
+		calls, replies = nil, nil
+		return r.Run(commandMessage(chatType, 1, 2, "admin", text), perm, reply)
+	}
+
+	found, err := run(PermAdmin, "private", `/lab list "DE15"`)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []string{"lab list:DE15"}, calls)
+
+	t.Run("admin commands are unknown to students", func(t *testing.T) {
+		found, err := run(PermStudent, "private", "/lab list DE15")
+		require.NoError(t, err)
+		assert.False(t, found)
+		assert.Empty(t, calls)
+
+		found, err = run(PermStudent, "private", "/nope")
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+
+	t.Run("group without subcommand shows usage", func(t *testing.T) {
+		found, err := run(PermAdmin, "private", "/lab")
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Empty(t, calls)
+		assert.Equal(t, []string{"Использование:\n" + r.GroupUsage("lab", PermAdmin)}, replies)
+
+		_, err = run(PermAdmin, "private", "/lab remove DE15")
+		require.NoError(t, err)
+		assert.Empty(t, calls)
+		assert.Len(t, replies, 1)
+	})
+
+	t.Run("no arguments shows usage", func(t *testing.T) {
+		_, err := run(PermAdmin, "private", "/lab add")
+		require.NoError(t, err)
+		assert.Empty(t, calls)
+		assert.Equal(t, []string{"Использование:\n/lab add <course> <lab> - Добавить лабу"}, replies)
+	})
+
+	t.Run("parse errors come with usage", func(t *testing.T) {
+		_, err := run(PermAdmin, "private", "/lab add DE15")
+		assert.EqualError(t, err, "не хватает аргумента <lab>\nИспользование: /lab add <course> <lab>")
+
+		_, err = run(PermAdmin, "private", `/lab add DE15 "01s`)
+		assert.EqualError(t, err, "не закрыта кавычка \"")
+		assert.Empty(t, calls)
+	})
+
+	t.Run("chat scope", func(t *testing.T) {
+		_, err := run(PermStudent, "group", "/key")
+		assert.EqualError(t, err, "команду /key можно выполнять только в личке с ботом")
+		_, err = run(PermStudent, "private", "/link DE15")
+		assert.EqualError(t, err, "команду /link имеет смысл выполнять только в групповых чатах")
+		assert.Empty(t, calls)
+
+		_, err = run(PermStudent, "supergroup", "/link DE15")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"link:DE15"}, calls)
+	})
+
+	t.Run("interactive commands start without arguments", func(t *testing.T) {
+		r.lookup("lab add").Interactive = func(msg *tgbotapi.Message) error {
+			calls = append(calls, "wizard")
+			return nil
+		}
+		defer func() { r.lookup("lab add").Interactive = nil }()
+
+		_, err := run(PermAdmin, "private", "/lab add")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"wizard"}, calls)
+		assert.Empty(t, replies)
+		assert.Contains(t, r.Help(PermAdmin), "/lab add <course> <lab> - Добавить лабу (без аргументов - по шагам)\n")
+
+		_, err = run(PermAdmin, "private", "/lab add DE15 01s")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"lab add:DE15"}, calls)
+	})
+
+	t.Run("raw commands skip parsing", func(t *testing.T) {
+		_, err := run(PermStudent, "private", `/help with "stray quote`)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"help:"}, calls)
+	})
+}
@@ -0,0 +1,298 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/shrimpsizemoose/trekker/logger"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+const defaultReminderSchedule = "*/5 * * * *"
+
+type ReminderConfig struct {
+	// Offsets like "24h" are how long before a lab deadline to remind, none disables reminders
+	Offsets []string `toml:"offsets"`
+	// Schedule is the cron, in UTC, of looking for due reminders
+	Schedule string `toml:"schedule"`
+	// DMUnfinished also messages students who haven't finished the lab,
+	// only those who ever wrote to the bot can be reached
+	DMUnfinished bool `toml:"dm_unfinished"`
+}
+
+func (c ReminderConfig) Validate() error {
+	_, err := c.offsets()
+	return err
+}
+
+func (c ReminderConfig) offsets() ([]time.Duration, error) {
+	offsets := make([]time.Duration, 0, len(c.Offsets))
+	for _, offset := range c.Offsets {
+		d, err := time.ParseDuration(offset)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("reminders: invalid offset %q, use a duration like 24h", offset)
+		}
+		offsets = append(offsets, d)
+	}
+	return offsets, nil
+}
+
+func (c ReminderConfig) schedule() string {
+	if c.Schedule == "" {
+		return defaultReminderSchedule
+	}
+	return c.Schedule
+}
+
+// reminder is due when its deadline is offset away
+type reminder struct {
+	lab      models.LabScore
+	offset   time.Duration
+	remindAt time.Time
+}
+
+// dueReminders returns reminders falling into (from, to], ordered by time
+func dueReminders(labs []models.LabScore, offsets []time.Duration, from, to time.Time) []reminder {
+	var due []reminder
+	for _, lab := range labs {
+		deadline := time.Unix(lab.Deadline, 0)
+		for _, offset := range offsets {
+			remindAt := deadline.Add(-offset)
+			if remindAt.After(from) && !remindAt.After(to) {
+				due = append(due, reminder{lab: lab, offset: offset, remindAt: remindAt})
+			}
+		}
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].remindAt.Before(due[j].remindAt) })
+	return due
+}
+
+// startReminders looks for due reminders on schedule. Each run covers the time
+// since the previous one, so reminders due while the bot was down are skipped.
+func (b *Bot) startReminders() (*gocron.Scheduler, error) {
+	offsets, err := b.config.Reminders.offsets()
+	if err != nil {
+		return nil, err
+	}
+	if len(offsets) == 0 {
+		return nil, nil
+	}
+
+	last := time.Now()
+	scheduler := gocron.NewScheduler(time.UTC)
+	scheduler.SingletonModeAll()
+	_, err = scheduler.Cron(b.config.Reminders.schedule()).Do(func() {
+		now := time.Now()
+		b.sendReminders(offsets, last, now)
+		last = now
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to schedule reminders on schedule '%s': %w", b.config.Reminders.schedule(), err)
+	}
+
+	logger.Info.Printf("Registering deadline reminders %v with schedule %s", b.config.Reminders.Offsets, b.config.Reminders.schedule())
+	scheduler.StartAsync()
+	return scheduler, nil
+}
+
+func (b *Bot) sendReminders(offsets []time.Duration, from, to time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	mappings, err := b.tokenManager.FetchAllChatMappings(ctx)
+	if err != nil {
+		logger.Error.Printf("Failed to fetch course chats for reminders: %v", err)
+		return
+	}
+
+	chats := make(map[string][]int64)
+	for chatID, mapping := range mappings {
+		chats[mapping.Course] = append(chats[mapping.Course], chatID)
+	}
+	for course := range b.config.Courses {
+		if _, ok := chats[course]; !ok {
+			chats[course] = nil
+		}
+	}
+
+	for course, chatIDs := range chats {
+		labs, err := b.store.ListLabScores(course)
+		if err != nil {
+			logger.Error.Printf("Failed to list labs of %s for reminders: %v", course, err)
+			continue
+		}
+
+		due := dueReminders(labs, offsets, from, to)
+		for _, reminder := range due {
+			text := b.reminderText(course, reminder)
+			for _, chatID := range chatIDs {
+				if err := b.sendMessage(chatID, text); err != nil {
+					logger.Error.Printf("Failed to send reminder to chat %d: %v", chatID, err)
+				}
+			}
+		}
+		if b.config.Reminders.DMUnfinished && len(due) > 0 {
+			b.remindUnfinished(course, due)
+		}
+	}
+}
+
+func (b *Bot) reminderText(course string, due reminder) string {
+	return fmt.Sprintf(
+		"⏰ %s: до дедлайна лабы %s осталось %s (%s)",
+		course,
+		due.lab.Lab,
+		app.FormatDuration(due.offset),
+		app.FormatTimestamp(due.lab.Deadline, b.config.Courses.Location(course), "2006-Jan-02 Mon 15:04"),
+	)
+}
+
+// remindUnfinished messages students of the course who haven't finished the
+// labs of the due reminders. A lab with several due reminders is mentioned once,
+// with the one closest to the deadline.
+func (b *Bot) remindUnfinished(course string, due []reminder) {
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	students, err := b.tokenManager.FetchCourseStudents(ctx, course)
+	if err != nil {
+		logger.Error.Printf("Failed to fetch students of %s for reminders: %v", course, err)
+		return
+	}
+	finished, err := b.finishedStudents(course)
+	if err != nil {
+		logger.Error.Printf("Failed to fetch finishes of %s for reminders: %v", course, err)
+		return
+	}
+
+	// due is ordered by time, so later reminders of a lab are closer to its deadline
+	latest := make(map[string]int, len(due))
+	for i, reminder := range due {
+		latest[reminder.lab.Lab] = i
+	}
+
+	userIDs := make(map[string]int64, len(students))
+	for i, reminder := range due {
+		if latest[reminder.lab.Lab] != i {
+			continue
+		}
+
+		text := fmt.Sprintf("%s\nЛаба у тебя ещё не сдана", b.reminderText(course, reminder))
+		for tgUsername, student := range students {
+			if finished[reminder.lab.Lab][student] {
+				continue
+			}
+
+			userID, ok := userIDs[tgUsername]
+			if !ok {
+				userID, err = b.tokenManager.FetchTelegramUserID(ctx, tgUsername)
+				if err != nil {
+					logger.Error.Printf("Failed to find telegram user %s: %v", tgUsername, err)
+					continue
+				}
+				userIDs[tgUsername] = userID
+			}
+			if userID == 0 {
+				continue
+			}
+			if err := b.sendMessage(userID, text); err != nil {
+				logger.Error.Printf("Failed to remind %s: %v", tgUsername, err)
+			}
+		}
+	}
+}
+
+// finishedStudents returns lab -> students who finished it. Like
+// GetStudentFinishEvent a finish counts for every teammate, but the whole
+// course takes two queries.
+func (b *Bot) finishedStudents(course string) (map[string]map[string]bool, error) {
+	finishes, err := b.store.GetCourseEventsByType(course, "100_lab_finish")
+	if err != nil {
+		return nil, err
+	}
+	members, err := b.store.ListTeamMembers(course)
+	if err != nil {
+		return nil, err
+	}
+
+	teams := make(map[string][]string)
+	teamOf := make(map[string]string)
+	for _, m := range members {
+		team := m.Lab + "/" + m.Team
+		teams[team] = append(teams[team], m.Student)
+		teamOf[m.Lab+"/"+m.Student] = team
+	}
+
+	finished := make(map[string]map[string]bool)
+	for _, e := range finishes {
+		if finished[e.Lab] == nil {
+			finished[e.Lab] = make(map[string]bool)
+		}
+		finished[e.Lab][e.Student] = true
+		for _, mate := range teams[teamOf[e.Lab+"/"+e.Student]] {
+			finished[e.Lab][mate] = true
+		}
+	}
+	return finished, nil
+}
+
+// handleDeadlinesCommand lists upcoming deadlines of the course given as the
+// argument, of the chat, or of the student asking
+func (b *Bot) handleDeadlinesCommand(msg *tgbotapi.Message, args Args) error {
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	course := args.String("course")
+	if course == "" && msg.Chat.Type != "private" {
+		if mapping, err := b.tokenManager.FetchCourseMappingByChatID(ctx, msg.Chat.ID); err == nil {
+			course = mapping.Course
+		}
+	}
+	if course == "" {
+		info, err := b.tokenManager.FetchStudentCourseInfo(ctx, msg.From.UserName)
+		if err != nil || info.Course == "" {
+			return fmt.Errorf("не понял, какой курс. Использование: /deadlines <course>")
+		}
+		course = info.Course
+	}
+
+	labs, err := b.store.ListLabScores(course)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка лаб: %v", err)
+	}
+
+	return b.sendMessage(msg.Chat.ID, b.formatDeadlines(course, labs, time.Now()))
+}
+
+func (b *Bot) formatDeadlines(course string, labs []models.LabScore, now time.Time) string {
+	var upcoming []models.LabScore
+	for _, lab := range labs {
+		if lab.Deadline > now.Unix() {
+			upcoming = append(upcoming, lab)
+		}
+	}
+	if len(upcoming) == 0 {
+		return fmt.Sprintf("У курса %s нет предстоящих дедлайнов", course)
+	}
+	sort.Slice(upcoming, func(i, j int) bool { return upcoming[i].Deadline < upcoming[j].Deadline })
+
+	loc := b.config.Courses.Location(course)
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("📅 Дедлайны курса %s (%s):\n\n", course, loc))
+	for _, lab := range upcoming {
+		msg.WriteString(fmt.Sprintf("📝 %s (баллы: %d)\n%s, через %s\n\n",
+			lab.Lab,
+			lab.BaseScore,
+			app.FormatTimestamp(lab.Deadline, loc, "2006-Jan-02 Mon 15:04"),
+			app.FormatDuration(time.Unix(lab.Deadline, 0).Sub(now)),
+		))
+	}
+	return msg.String()
+}
@@ -0,0 +1,122 @@
+package bot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+func TestDueReminders(t *testing.T) {
+	deadline := time.Date(2024, 4, 1, 18, 0, 0, 0, time.UTC)
+	labs := []models.LabScore{
+		{Lab: "01s", Deadline: deadline.Unix()},
+		{Lab: "02s", Deadline: deadline.Add(time.Hour).Unix()},
+	}
+	offsets := []time.Duration{24 * time.Hour, time.Hour}
+	dayBefore := deadline.Add(-24 * time.Hour)
+
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []string
+	}{
+		{"window ends right at the reminder", dayBefore.Add(-5 * time.Minute), dayBefore, []string{"01s/24h0m0s"}},
+		{"window starts right at the reminder", dayBefore, dayBefore.Add(5 * time.Minute), nil},
+		{"consecutive windows remind once", dayBefore.Add(-5 * time.Minute), dayBefore.Add(-time.Second), nil},
+		{"nothing due", deadline.Add(-10 * time.Hour), deadline.Add(-5 * time.Hour), nil},
+		{"several labs and offsets, ordered by time", dayBefore.Add(-time.Minute), deadline.Add(-time.Minute), []string{"01s/24h0m0s", "02s/24h0m0s", "01s/1h0m0s"}},
+		{"both offsets of both labs", dayBefore.Add(-time.Minute), deadline, []string{"01s/24h0m0s", "02s/24h0m0s", "01s/1h0m0s", "02s/1h0m0s"}},
+		{"deadline itself is not a reminder", deadline.Add(-time.Minute), deadline.Add(time.Minute), []string{"02s/1h0m0s"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, due := range dueReminders(labs, offsets, tt.from, tt.to) {
+				got = append(got, due.lab.Lab+"/"+due.offset.String())
+				assert.Equal(t, time.Unix(due.lab.Deadline, 0).Add(-due.offset), due.remindAt)
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// consecutive runs cover the whole time exactly once
+	var reminded int
+	for from := dayBefore.Add(-time.Hour); from.Before(deadline.Add(time.Hour)); from = from.Add(5 * time.Minute) {
+		reminded += len(dueReminders(labs, offsets, from, from.Add(5*time.Minute)))
+	}
+	assert.Equal(t, 4, reminded)
+}
+
+func TestFormatDeadlines(t *testing.T) {
+	tb := newTestBot(t)
+	now := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	labs := []models.LabScore{
+		{Lab: "03s", BaseScore: 15, Deadline: now.Add(50 * time.Hour).Unix()},
+		{Lab: "01s", BaseScore: 10, Deadline: now.Add(-time.Hour).Unix()},
+		{Lab: "02s", BaseScore: 10, Deadline: now.Add(90 * time.Minute).Unix()},
+	}
+
+	assert.Equal(t,
+		"📅 Дедлайны курса DE15 (Europe/Moscow):\n\n"+
+			"📝 02s (баллы: 10)\n2024-Apr-01 Mon 16:30 MSK, через 1h30m\n\n"+
+			"📝 03s (баллы: 15)\n2024-Apr-03 Wed 17:00 MSK, через 2d2h0m\n\n",
+		tb.formatDeadlines("DE15", labs, now),
+	)
+	assert.Equal(t, "У курса DE15 нет предстоящих дедлайнов", tb.formatDeadlines("DE15", labs[1:2], now))
+}
+
+func TestSendReminders(t *testing.T) {
+	const bobID int64 = 201
+
+	tb := newTestBot(t)
+	tb.seedCourse(t)
+	tb.config.Reminders.DMUnfinished = true
+	offsets := []time.Duration{24 * time.Hour, time.Hour}
+
+	tb.admin("/lab add DE15 02s score 10 deadline 2099-01-02")
+	tb.admin("/team add DE15 02s owls alice.a bob.b")
+	// only students who wrote to the bot can get a message
+	tb.student("/help")
+	tb.run("private", bobID, bobID, "bob_tg", "/help")
+	tb.finish(t, "01s", "bob.b", time.Now())
+	tb.api.reset()
+
+	first, err := tb.store.GetLabScore("DE15", "01s")
+	require.NoError(t, err)
+	deadline := time.Unix(first.Deadline, 0)
+
+	t.Run("lab reminder", func(t *testing.T) {
+		tb.sendReminders(offsets, deadline.Add(-24*time.Hour-5*time.Minute), deadline.Add(-24*time.Hour))
+		tb.requireSent(t, testGroupID, "⏰ DE15: до дедлайна лабы 01s осталось 1d0h0m (2099-Jan-01 Thu 23:59 MSK)")
+		tb.requireSent(t, testStudentID, "лабы 01s осталось 1d0h0m", "Лаба у тебя ещё не сдана")
+		assert.Empty(t, tb.api.texts(bobID), "bob.b finished")
+		assert.Len(t, tb.api.texts(testGroupID), 1)
+	})
+
+	t.Run("teammate finished", func(t *testing.T) {
+		tb.finish(t, "02s", "bob.b", time.Now())
+		tb.api.reset()
+
+		second := deadline.Add(24 * time.Hour)
+		tb.sendReminders(offsets, second.Add(-24*time.Hour-5*time.Minute), second.Add(-24*time.Hour))
+		tb.requireSent(t, testGroupID, "лабы 02s осталось 1d0h0m")
+		assert.Empty(t, tb.api.texts(testStudentID))
+		assert.Empty(t, tb.api.texts(bobID))
+	})
+
+	t.Run("one message per lab", func(t *testing.T) {
+		tb.api.reset()
+
+		// a window covering both reminders of 01s, like a daily schedule would
+		tb.sendReminders(offsets, deadline.Add(-25*time.Hour), deadline.Add(-time.Hour))
+		assert.Len(t, tb.api.texts(testGroupID), 2)
+		texts := tb.api.texts(testStudentID)
+		require.Len(t, texts, 1)
+		assert.Contains(t, texts[0], "лабы 01s осталось 1h0m")
+	})
+}
@@ -0,0 +1,347 @@
+package bot
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+	"github.com/shrimpsizemoose/kanelbulle/internal/scoring"
+)
+
+// telegram rejects messages longer than 4096 characters
+const maxListedStudents = 30
+
+func (b *Bot) handleSimulateCommand(msg *tgbotapi.Message, args Args) error {
+	course := args.String("course")
+	scenario, err := b.parseScenario(course, args.Strings("options"))
+	if err != nil {
+		return err
+	}
+
+	result, err := b.grader.Simulate(course, *scenario)
+	if err != nil {
+		return fmt.Errorf("ошибка симуляции: %v", err)
+	}
+
+	return b.sendMessage(msg.Chat.ID, formatSimulation(result))
+}
+
+func (b *Bot) parseScenario(course string, args []string) (*scoring.Scenario, error) {
+	loc := b.config.Courses.Location(course)
+	scenario := &scoring.Scenario{Deadlines: make(map[string]int64)}
+
+	for i := 0; i < len(args); {
+		switch args[i] {
+		case "deadline":
+			if i+2 >= len(args) {
+				return nil, fmt.Errorf("использование: deadline <lab> <date>")
+			}
+			deadline, err := app.ParseDeadline(args[i+2], loc)
+			if err != nil {
+				return nil, fmt.Errorf("некорректная дата: %v", err)
+			}
+			scenario.Deadlines[args[i+1]] = deadline.Unix()
+			i += 3
+		case "modifier":
+			if i+2 >= len(args) {
+				return nil, fmt.Errorf("использование: modifier <days> <delta>")
+			}
+			days, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("некорректное число дней: %v", err)
+			}
+			delta, err := strconv.Atoi(args[i+2])
+			if err != nil {
+				return nil, fmt.Errorf("некорректный модификатор: %v", err)
+			}
+			if scenario.LateDaysModifiers == nil {
+				scenario.LateDaysModifiers = make(map[int]int)
+			}
+			scenario.LateDaysModifiers[days] = delta
+			i += 3
+		case "strategy":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("пропущено значение для strategy, доступны: %v", scoring.Strategies())
+			}
+			strategy := args[i+1]
+			scenario.Strategy = &strategy
+			i += 2
+		case "param":
+			if i+2 >= len(args) {
+				return nil, fmt.Errorf("использование: param <name> <value>")
+			}
+			value, err := strconv.ParseFloat(args[i+2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("некорректное значение для %s: %v", args[i+1], err)
+			}
+			if scenario.Params == nil {
+				scenario.Params = make(map[string]float64)
+			}
+			scenario.Params[args[i+1]] = value
+			i += 3
+		case "penalty":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("пропущено значение для penalty")
+			}
+			penalty, err := strconv.ParseFloat(args[i+1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("некорректный штраф: %v", err)
+			}
+			scenario.DefaultLatePenalty = &penalty
+			i += 2
+		case "max_late_days", "extra_penalty":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("пропущено значение для %s", args[i])
+			}
+			value, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return nil, fmt.Errorf("некорректное значение для %s: %v", args[i], err)
+			}
+			if args[i] == "max_late_days" {
+				scenario.MaxLateDays = &value
+			} else {
+				scenario.ExtraLatePenalty = &value
+			}
+			i += 2
+		default:
+			return nil, fmt.Errorf("неизвестный параметр: %s", args[i])
+		}
+	}
+
+	return scenario, nil
+}
+
+func formatSimulation(result *scoring.SimulationResult) string {
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("🔮 Симуляция для курса %s (ничего не сохранено)\n", result.Course))
+	if result.Policy.StrategyName() == scoring.StrategyStepTable {
+		msg.WriteString(fmt.Sprintf(
+			"Штраф: x%.2f после %d дней ещё -%d, модификаторы: %v\n",
+			result.Policy.DefaultLatePenalty,
+			result.Policy.MaxLateDays,
+			result.Policy.ExtraLatePenalty,
+			result.Policy.LateDaysModifiers,
+		))
+	} else {
+		msg.WriteString(fmt.Sprintf(
+			"Стратегия: %s, параметры: %v\n",
+			result.Policy.StrategyName(),
+			result.Policy.Params,
+		))
+	}
+	msg.WriteString(fmt.Sprintf(
+		"Затронуто студентов: %d, без изменений: %d\n\n",
+		len(result.Affected),
+		result.Unaffected,
+	))
+
+	for i, diff := range result.Affected {
+		if i == maxListedStudents {
+			msg.WriteString(fmt.Sprintf("...и ещё %d\n", len(result.Affected)-maxListedStudents))
+			break
+		}
+
+		msg.WriteString(formatStudentDiff(diff))
+	}
+
+	return msg.String()
+}
+
+func (b *Bot) handleSnapshotCreate(msg *tgbotapi.Message, args Args) error {
+	course := args.String("course")
+	gradebook, err := b.snapshots.Create(course, args.String("name"), "@"+msg.From.UserName)
+	if err != nil {
+		return fmt.Errorf("не смог создать ведомость: %v", err)
+	}
+	return b.sendMessage(msg.Chat.ID, fmt.Sprintf(
+		"🧊 Ведомость %s для курса %s заморожена\nСтудентов: %d\nХеш входных данных: %s",
+		gradebook.Name,
+		course,
+		len(gradebook.Scores),
+		gradebook.InputHash[:12],
+	))
+}
+
+func (b *Bot) handleSnapshotList(msg *tgbotapi.Message, args Args) error {
+	chatID, course := msg.Chat.ID, args.String("course")
+	snapshots, err := b.snapshots.List(course)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка ведомостей: %v", err)
+	}
+
+	if len(snapshots) == 0 {
+		return b.sendMessage(chatID, "Ведомости не найдены")
+	}
+
+	loc := b.config.Courses.Location(course)
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("Ведомости курса %s:\n\n", course))
+	for _, s := range snapshots {
+		text.WriteString(fmt.Sprintf(
+			"🧊 %s\n📅 %s, %s\n#️⃣ %s\n\n",
+			s.Name,
+			app.FormatTimestamp(s.CreatedAt, loc, "2006-01-02 15:04"),
+			s.CreatedBy,
+			s.InputHash[:12],
+		))
+	}
+
+	return b.sendMessage(chatID, text.String())
+}
+
+func (b *Bot) handleSnapshotGet(msg *tgbotapi.Message, args Args) error {
+	chatID, course, name := msg.Chat.ID, args.String("course"), args.String("name")
+	gradebook, err := b.snapshots.Get(course, name)
+	if err != nil {
+		return fmt.Errorf("не нашёл ведомость: %v", err)
+	}
+
+	data, err := json.MarshalIndent(gradebook, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize gradebook: %w", err)
+	}
+
+	doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{
+		Name:  fmt.Sprintf("%s-%s.json", course, name),
+		Bytes: data,
+	})
+	_, err = b.api.Send(doc)
+	return err
+}
+
+func (b *Bot) handleSnapshotDiff(msg *tgbotapi.Message, args Args) error {
+	diff, err := b.snapshots.Compare(args.String("course"), args.String("from"), args.String("to"))
+	if err != nil {
+		return fmt.Errorf("не смог сравнить: %v", err)
+	}
+	return b.sendMessage(msg.Chat.ID, formatGradebookDiff(diff))
+}
+
+func formatGradebookDiff(diff *app.GradebookDiff) string {
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("🔍 %s: %s → %s\n", diff.Course, diff.From, diff.To))
+	if len(diff.ChangedInputs) == 0 {
+		msg.WriteString("Входные данные не менялись\n")
+	} else {
+		msg.WriteString(fmt.Sprintf("Изменились: %s\n", strings.Join(diff.ChangedInputs, ", ")))
+	}
+	msg.WriteString(fmt.Sprintf("Изменились оценки у %d студентов\n\n", len(diff.Students)))
+
+	for i, sd := range diff.Students {
+		if i == maxListedStudents {
+			msg.WriteString(fmt.Sprintf("...и ещё %d\n", len(diff.Students)-maxListedStudents))
+			break
+		}
+		msg.WriteString(formatStudentDiff(sd))
+	}
+
+	return msg.String()
+}
+
+func formatStudentDiff(diff scoring.StudentDiff) string {
+	var labs []string
+	for _, lab := range diff.Labs {
+		labs = append(labs, fmt.Sprintf("%s: %d→%d", lab.Lab, lab.Before, lab.After))
+	}
+	return fmt.Sprintf(
+		"👉🏻 %s: %d → %d (%s)\n",
+		diff.Student,
+		diff.BeforeTotal,
+		diff.AfterTotal,
+		strings.Join(labs, ", "),
+	)
+}
+
+// handleScoresCommand shows a student their own grades, always in private
+func (b *Bot) handleScoresCommand(msg *tgbotapi.Message, args Args) error {
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	info, err := b.tokenManager.FetchStudentCourseInfo(ctx, msg.From.UserName)
+	if err != nil {
+		return fmt.Errorf("Не признал: %w", err)
+	}
+
+	labs, err := b.store.ListLabScores(info.Course)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка лаб: %v", err)
+	}
+
+	if lab := args.String("lab"); lab != "" {
+		var found []models.LabScore
+		for _, labScore := range labs {
+			if labScore.Lab == lab {
+				found = append(found, labScore)
+			}
+		}
+		if len(found) == 0 {
+			return b.sendMessage(msg.From.ID, fmt.Sprintf("Лабы %s нет в курсе %s", lab, info.Course))
+		}
+		labs = found
+	}
+	if len(labs) == 0 {
+		return b.sendMessage(msg.From.ID, "Лабораторные работы не найдены")
+	}
+
+	explanations := make([]*scoring.ScoreExplanation, 0, len(labs))
+	for _, lab := range labs {
+		explanation, err := b.grader.ExplainScore(info.Course, lab.Lab, info.StudentID)
+		if err != nil {
+			return fmt.Errorf("не получилось посчитать оценку за %s: %v", lab.Lab, err)
+		}
+		explanations = append(explanations, explanation)
+	}
+
+	return b.sendMessage(msg.From.ID, b.formatStudentScores(info, explanations))
+}
+
+func (b *Bot) formatStudentScores(info *models.StudentCourseInfo, explanations []*scoring.ScoreExplanation) string {
+	loc := b.config.Courses.Location(info.Course)
+
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("📊 %s, %s\n\n", info.Course, info.StudentID))
+
+	total, possible := 0, 0
+	for _, e := range explanations {
+		total += e.Score
+		possible += e.BaseScore
+
+		switch {
+		case e.FinishTimestamp != nil:
+			late := ""
+			if e.LateDays > 0 {
+				late = fmt.Sprintf(", опоздание %d дн.", e.LateDays)
+			}
+			msg.WriteString(fmt.Sprintf("✅ %s: %d/%d, сдана %s%s\n",
+				e.Lab,
+				e.Score,
+				e.BaseScore,
+				app.FormatTimestamp(*e.FinishTimestamp, loc, "2006-01-02 15:04"),
+				late,
+			))
+		default:
+			msg.WriteString(fmt.Sprintf("⏳ %s: %d/%d, не сдана, дедлайн %s\n",
+				e.Lab,
+				e.Score,
+				e.BaseScore,
+				app.FormatTimestamp(e.Deadline, loc, "2006-01-02 15:04"),
+			))
+		}
+		if e.FinishedBy != "" {
+			msg.WriteString(fmt.Sprintf("   сдал(а) %s за команду\n", e.FinishedBy))
+		}
+		if e.OverrideApplied {
+			msg.WriteString(fmt.Sprintf("   оценка выставлена вручную (было бы %d): %s\n", e.CalculatedScore, e.Override.Reason))
+		}
+	}
+
+	msg.WriteString(fmt.Sprintf("\nИтого: %d из %d\n", total, possible))
+	return msg.String()
+}
@@ -0,0 +1,124 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+)
+
+// listed slowest and stuck students per lab
+const maxStatsStudents = 5
+
+func (b *Bot) handleStatsCommand(msg *tgbotapi.Message, args Args) error {
+	course := args.String("course")
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	roster, err := b.tokenManager.CourseRoster(ctx, course)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка студентов: %v", err)
+	}
+	matrix, err := b.grader.CourseMatrix(course, roster)
+	if err != nil {
+		return fmt.Errorf("не получилось посчитать оценки: %v", err)
+	}
+	stats, err := b.store.GetDetailedStats(course, b.config.Events.Start, b.config.Events.Finish)
+	if err != nil {
+		return fmt.Errorf("ошибка получения статистики: %v", err)
+	}
+	summaries := app.SummarizeLabs(matrix, stats)
+	if len(summaries) == 0 {
+		return b.sendMessage(msg.Chat.ID, fmt.Sprintf("По курсу %s пока нет данных", course))
+	}
+
+	if lab := args.String("lab"); lab != "" {
+		for _, summary := range summaries {
+			if summary.Lab == lab {
+				return b.sendMessage(msg.Chat.ID, formatLabStats(course, summary))
+			}
+		}
+		return b.sendMessage(msg.Chat.ID, fmt.Sprintf("По лабе %s курса %s нет данных", lab, course))
+	}
+	return b.sendMessage(msg.Chat.ID, formatCourseStats(course, len(matrix), summaries))
+}
+
+func formatCourseStats(course string, students int, summaries []app.LabSummary) string {
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("📈 %s, студентов: %d\n\n", course, students))
+	for _, summary := range summaries {
+		msg.WriteString(formatLabLine(summary))
+	}
+	msg.WriteString("\nПодробнее по лабе: /stats " + course + " <lab>")
+	return msg.String()
+}
+
+func formatLabStats(course string, summary app.LabSummary) string {
+	var msg strings.Builder
+	msg.WriteString(fmt.Sprintf("📈 %s\n\n", course))
+	msg.WriteString(formatLabLine(summary))
+
+	if len(summary.Scores) > 0 {
+		scores := make([]int, 0, len(summary.Scores))
+		for score := range summary.Scores {
+			scores = append(scores, score)
+		}
+		sort.Sort(sort.Reverse(sort.IntSlice(scores)))
+
+		msg.WriteString("\nБаллы:\n")
+		for _, score := range scores {
+			msg.WriteString(fmt.Sprintf("  %d: %d чел.\n", score, summary.Scores[score]))
+		}
+	}
+
+	if len(summary.Slowest) > 0 {
+		msg.WriteString("\n🐢 Дольше всех сдавали:\n")
+		for i, slow := range summary.Slowest {
+			if i == maxStatsStudents {
+				break
+			}
+			msg.WriteString(fmt.Sprintf("  %s: %s\n", slow.Student, app.FormatDuration(slow.Duration)))
+		}
+	}
+
+	if len(summary.Stuck) > 0 {
+		msg.WriteString("\n🧱 Начали и не сдали:\n")
+		for i, stuck := range summary.Stuck {
+			if i == maxStatsStudents {
+				msg.WriteString(fmt.Sprintf("  ...и ещё %d\n", len(summary.Stuck)-maxStatsStudents))
+				break
+			}
+			msg.WriteString(fmt.Sprintf("  %s: запусков %d\n", stuck.Student, stuck.Starts))
+		}
+	}
+	return msg.String()
+}
+
+func formatLabLine(summary app.LabSummary) string {
+	median := "—"
+	if summary.MedianToFinish != nil {
+		median = app.FormatDuration(*summary.MedianToFinish)
+	}
+
+	overridden := ""
+	if summary.Overridden > 0 {
+		overridden = fmt.Sprintf(" (вручную %d)", summary.Overridden)
+	}
+
+	return fmt.Sprintf(
+		"📝 %s: сдали %d/%d (%.0f%%)%s, в процессе %d, опоздали %d, медиана %s\n",
+		summary.Lab,
+		summary.Finished+summary.Overridden,
+		summary.Students,
+		summary.CompletionRate()*100,
+		overridden,
+		summary.InProgress,
+		summary.Late,
+		median,
+	)
+}
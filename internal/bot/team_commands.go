@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+)
+
+func (b *Bot) handleTeamAdd(msg *tgbotapi.Message, args Args) error {
+	course, lab, team := args.String("course"), args.String("lab"), args.String("team")
+	students := args.Strings("students")
+
+	if err := app.ValidateTeam(team, students); err != nil {
+		return fmt.Errorf("некорректная команда: %v", err)
+	}
+
+	if err := b.store.CreateTeam(course, lab, team, students); err != nil {
+		return fmt.Errorf("ошибка сохранения команды: %v", err)
+	}
+
+	return b.sendMessage(msg.Chat.ID, fmt.Sprintf("✅ Команда %s для %s/%s: %s",
+		team,
+		course, lab,
+		strings.Join(students, ", "),
+	))
+}
+
+func (b *Bot) handleTeamList(msg *tgbotapi.Message, args Args) error {
+	chatID, course, lab := msg.Chat.ID, args.String("course"), args.String("lab")
+
+	members, err := b.store.ListTeamMembers(course)
+	if err != nil {
+		return fmt.Errorf("ошибка получения списка команд: %v", err)
+	}
+
+	teams := make(map[string][]string)
+	var keys []string
+	for _, m := range members {
+		if lab != "" && m.Lab != lab {
+			continue
+		}
+		key := m.Lab + " " + m.Team
+		if _, ok := teams[key]; !ok {
+			keys = append(keys, key)
+		}
+		teams[key] = append(teams[key], m.Student)
+	}
+
+	if len(keys) == 0 {
+		return b.sendMessage(chatID, fmt.Sprintf("В курсе %s нет команд", course))
+	}
+	sort.Strings(keys)
+
+	var text strings.Builder
+	text.WriteString(fmt.Sprintf("Команды курса %s:\n\n", course))
+	for _, key := range keys {
+		text.WriteString(fmt.Sprintf("👥 %s: %s\n", key, strings.Join(teams[key], ", ")))
+	}
+
+	return b.sendMessage(chatID, text.String())
+}
+
+func (b *Bot) handleTeamRemove(msg *tgbotapi.Message, args Args) error {
+	course, lab, team := args.String("course"), args.String("lab"), args.String("team")
+	if err := b.store.DeleteTeam(course, lab, team); err != nil {
+		return fmt.Errorf("ошибка удаления команды: %v", err)
+	}
+	return b.sendMessage(msg.Chat.ID, fmt.Sprintf("🗑 Команда %s удалена из %s/%s", team, course, lab))
+}
@@ -0,0 +1,176 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+func (b *Bot) handleTokenRotateCommand(msg *tgbotapi.Message, _ Args) error {
+	if b.warnPrivateOnly(msg) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	info, err := b.tokenManager.FetchStudentCourseInfo(ctx, msg.From.UserName)
+	if err != nil {
+		return fmt.Errorf("Не признал: %w", err)
+	}
+
+	if end, ok := b.config.Courses.EndTime(info.Course); ok && time.Now().After(end) {
+		return b.sendMessage(msg.From.ID, fmt.Sprintf("🏁 Курс %s завершён, новые токены не выдаются", info.Course))
+	}
+
+	tokenInfo, err := b.tokenManager.RotateStudentToken(ctx, info.Course, info.StudentID, "@"+msg.From.UserName)
+	if err != nil {
+		return fmt.Errorf("не получилось выпустить новый токен: %w", err)
+	}
+
+	text := fmt.Sprintf(
+		"🔄 %s\nStudent: `%s`\nНовый токен: `%s`\nСтарый больше не работает\n%s",
+		info.Course,
+		info.StudentID,
+		tokenInfo.Token,
+		b.tokenLifetimeLine(info.Course, tokenInfo),
+	)
+	if err := b.sendMessageMarkdown(msg.From.ID, text); err != nil {
+		return fmt.Errorf("failed to send token: %w", err)
+	}
+	return nil
+}
+
+func (b *Bot) tokenLifetimeLine(course string, info *models.TokenInfo) string {
+	expiry := b.lifetime.Expiry(course, info.IssuedTime)
+	if expiry.IsZero() {
+		return "♾ Бессрочный"
+	}
+
+	until := app.FormatTimestamp(expiry.Unix(), b.config.Courses.Location(course), "2006-01-02 15:04")
+	remaining := time.Until(expiry)
+	if remaining <= 0 {
+		return fmt.Sprintf("⌛ Истёк %s, новый: /token rotate", until)
+	}
+	return fmt.Sprintf("⏳ Действует ещё %s (до %s)", app.FormatDuration(remaining), until)
+}
+
+// tokenUsageLines describes API requests made with the token since it was issued
+func (b *Bot) tokenUsageLines(course string, usage *models.TokenUsage) string {
+	if usage == nil || usage.Count == 0 {
+		return "API: токен ещё не использовался"
+	}
+
+	lines := []string{fmt.Sprintf(
+		"API: запросов %d, последний %s",
+		usage.Count,
+		app.FormatTimestamp(usage.LastUsed.Unix(), b.config.Courses.Location(course), app.DefaultDisplayFormat),
+	)}
+	if usage.LastIP != "" {
+		lines = append(lines, fmt.Sprintf("IP: %s", usage.LastIP))
+	}
+	if usage.LastUserAgent != "" {
+		lines = append(lines, fmt.Sprintf("User-Agent: %s", usage.LastUserAgent))
+	}
+
+	events := make([]string, 0, len(usage.Events))
+	for event := range usage.Events {
+		events = append(events, event)
+	}
+	sort.Strings(events)
+	for _, event := range events {
+		lines = append(lines, fmt.Sprintf("  %s: %d", strings.TrimPrefix(event, "/"), usage.Events[event]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (b *Bot) handleTokenInfoCommand(msg *tgbotapi.Message, args Args) error {
+	course, student := args.String("course"), args.String("student")
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	info, err := b.tokenManager.FetchStudentToken(ctx, course, student)
+	if err != nil {
+		return fmt.Errorf("не нашёл токен: %v", err)
+	}
+	usage, err := b.tokenManager.FetchTokenUsage(ctx, course, student)
+	if err != nil {
+		return fmt.Errorf("failed to fetch token usage: %w", err)
+	}
+
+	loc := b.config.Courses.Location(course)
+	status := b.tokenLifetimeLine(course, info)
+	if info.Revoked {
+		status = "⛔ Отозван"
+	}
+
+	text := fmt.Sprintf(
+		"🔑 %s/%s\n"+
+			"Токен: %s\n"+
+			"Создан: %s\n"+
+			"Выпущен: %s\n"+
+			"%s\n"+
+			"Бот: запрошен раз %d, последний %s\n"+
+			"%s",
+		course,
+		student,
+		info.Hint,
+		app.FormatTimestamp(info.CreatedTime.Unix(), loc, app.DefaultDisplayFormat),
+		app.FormatTimestamp(info.IssuedTime.Unix(), loc, app.DefaultDisplayFormat),
+		status,
+		info.RequestCount,
+		app.FormatTimestamp(info.LastRequestTime.Unix(), loc, app.DefaultDisplayFormat),
+		b.tokenUsageLines(course, usage),
+	)
+	return b.sendMessage(msg.Chat.ID, text)
+}
+
+func (b *Bot) handleRevokeCommand(msg *tgbotapi.Message, args Args) error {
+	course, student := args.String("course"), args.String("student")
+
+	ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+	defer cancel()
+
+	actor := fmt.Sprintf("admin %d", msg.From.ID)
+	if msg.From.UserName != "" {
+		actor = "@" + msg.From.UserName
+	}
+
+	if err := b.tokenManager.RevokeStudentToken(ctx, course, student, actor); err != nil {
+		return fmt.Errorf("ошибка отзыва токена: %v", err)
+	}
+
+	return b.sendMessage(msg.Chat.ID, fmt.Sprintf("⛔ Токен %s/%s отозван", course, student))
+}
+
+// watchTokenEvents tells admins about rotations and revocations, whether they
+// came from the bot or the api
+func (b *Bot) watchTokenEvents(ctx context.Context) {
+	for event := range b.tokenManager.SubscribeTokenEvents(ctx) {
+		var title string
+		switch event.Action {
+		case models.TokenRotated:
+			title = "🔄 Token rotated"
+		case models.TokenRevoked:
+			title = "⛔ Token revoked"
+		default:
+			continue
+		}
+
+		b.notifyAdmins(fmt.Sprintf(
+			"%s\nCourse: %s\nStudent: `%s`\nBy: `%s`",
+			title,
+			event.Course,
+			event.Student,
+			event.Actor,
+		))
+	}
+}
@@ -0,0 +1,647 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/shrimpsizemoose/trekker/logger"
+)
+
+// Wizards walk an admin through a registry command one argument at a time with
+// inline keyboards, typing "/lab add DE15 01s score 10 deadline ..." on a phone
+// is no fun. The answers become the words of the command, which is then parsed
+// and run by the registry exactly like a typed one.
+
+const (
+	// idle conversations are forgotten after this
+	wizardTimeout  = 30 * time.Minute
+	wizardPageSize = 8
+	callbackPrefix = "wz"
+)
+
+// deadline times offered after a day is picked, "" is the end of the day
+var wizardTimes = []string{"", "09:00", "12:00", "18:00"}
+
+type stepKind int
+
+const (
+	// stepChoice offers Options as buttons
+	stepChoice stepKind = iota
+	// stepText waits for a typed answer
+	stepText
+	// stepDate shows a calendar and then a few times of the day
+	stepDate
+)
+
+type wizardStep struct {
+	// Arg is the command argument the answer goes to
+	Arg    string
+	Prompt string
+	Kind   stepKind
+	// Options are the buttons of choice steps, values holds the answers so far
+	Options func(ctx context.Context, values map[string]string) ([]string, error)
+	// Free choice steps also take a typed answer that is not among the options
+	Free bool
+	// Optional steps can be skipped
+	Optional bool
+}
+
+type wizard struct {
+	// Command is the registry command the wizard fills
+	Command string
+	Title   string
+	Steps   []wizardStep
+}
+
+// conversation is a wizard in progress, there is at most one per chat
+type conversation struct {
+	id     int64
+	wizard *wizard
+	userID int64
+	step   int
+	values map[string]string
+	// options and page are what the current choice step shows
+	options []string
+	page    int
+	// month and date are what the date picker shows, date is set once a day is picked
+	month time.Time
+	date  string
+	// messageID is the message with the keyboard of the current step
+	messageID int
+	updated   time.Time
+}
+
+type conversations struct {
+	mu     sync.Mutex
+	nextID int64
+	chats  map[int64]*conversation
+}
+
+func newConversations() *conversations {
+	return &conversations{chats: make(map[int64]*conversation)}
+}
+
+// get returns the live conversation of the chat, the caller must hold mu
+func (c *conversations) get(chatID int64) *conversation {
+	conv, ok := c.chats[chatID]
+	if !ok {
+		return nil
+	}
+	if time.Since(conv.updated) > wizardTimeout {
+		delete(c.chats, chatID)
+		return nil
+	}
+	return conv
+}
+
+// current returns the conversation view was copied from if it is still at the
+// same step, the caller must hold mu
+func (c *conversations) current(chatID int64, view *conversation) *conversation {
+	conv := c.chats[chatID]
+	if conv == nil || conv.id != view.id || conv.step != view.step {
+		return nil
+	}
+	return conv
+}
+
+// press applies a button press to the conversation of the chat. It returns the
+// action with a copy of the conversation to act on, or without a copy the
+// answer for the user when there is nothing to do. Callback data is
+// wz:<conversation>:<step>:<action>.
+func (c *conversations) press(cb *tgbotapi.CallbackQuery) (string, *conversation, string) {
+	parts := strings.SplitN(cb.Data, ":", 4)
+	if len(parts) != 4 || parts[0] != callbackPrefix {
+		return "", nil, ""
+	}
+	chatID := cb.Message.Chat.ID
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conv := c.get(chatID)
+	if conv == nil || strconv.FormatInt(conv.id, 10) != parts[1] || strconv.Itoa(conv.step) != parts[2] {
+		return "", nil, "Кнопка устарела"
+	}
+	if conv.userID != cb.From.ID {
+		return "", nil, "Это не твой диалог"
+	}
+	conv.updated = time.Now()
+	conv.messageID = cb.Message.MessageID
+
+	action, value, _ := strings.Cut(parts[3], ":")
+	switch action {
+	case "cancel", "ok":
+		delete(c.chats, chatID)
+	case "skip":
+		delete(conv.values, conv.wizard.Steps[conv.step].Arg)
+		conv.next()
+	case "o":
+		i, err := strconv.Atoi(value)
+		if err != nil || i < 0 || i >= len(conv.options) {
+			return "", nil, "Кнопка устарела"
+		}
+		conv.values[conv.wizard.Steps[conv.step].Arg] = conv.options[i]
+		conv.next()
+	case "p":
+		conv.page, _ = strconv.Atoi(value)
+	case "m":
+		month, err := time.Parse("2006-01", value)
+		if err != nil {
+			return "", nil, ""
+		}
+		conv.month = month
+	case "d":
+		conv.date = value
+	case "t":
+		deadline := conv.date
+		if value != "" {
+			deadline += "T" + value
+		}
+		conv.values[conv.wizard.Steps[conv.step].Arg] = deadline
+		conv.next()
+	default:
+		return "", nil, ""
+	}
+	return action, conv.view(), ""
+}
+
+func (b *Bot) registerWizards() map[string]*wizard {
+	course := wizardStep{Arg: "course", Prompt: "Выбери курс", Kind: stepChoice, Options: b.courseOptions, Free: true}
+	lab := wizardStep{Arg: "lab", Prompt: "Выбери лабу", Kind: stepChoice, Options: b.labOptions}
+
+	wizards := []*wizard{
+		{
+			Command: "lab add",
+			Title:   "Новая лабораторная",
+			Steps: []wizardStep{
+				course,
+				{Arg: "lab", Prompt: "Напиши название новой лабы или выбери существующую, чтобы её обновить", Kind: stepChoice, Options: b.labOptions, Free: true},
+				{Arg: "score", Prompt: "Сколько баллов за лабу? Можно написать своё число", Kind: stepChoice, Options: staticOptions("5", "10", "15", "20"), Free: true},
+				{Arg: "deadline", Prompt: "Когда дедлайн? Можно написать дату как YYYY-MM-DD или YYYY-MM-DDTHH:MM", Kind: stepDate},
+			},
+		},
+		{
+			Command: "override set",
+			Title:   "Оценка вручную",
+			Steps: []wizardStep{
+				course,
+				lab,
+				{Arg: "student", Prompt: "Выбери студента или напиши team:<команда>", Kind: stepChoice, Options: b.studentOptions, Free: true},
+				{Arg: "score", Prompt: "Какую оценку поставить? Можно написать своё число", Kind: stepChoice, Options: b.scoreOptions, Free: true},
+				{Arg: "reason", Prompt: "Напиши причину", Kind: stepText, Optional: true},
+			},
+		},
+		{
+			Command: "map_student",
+			Title:   "Привязка студента",
+			Steps: []wizardStep{
+				course,
+				{Arg: "username", Prompt: "Напиши телеграмный @username студента", Kind: stepText},
+				{Arg: "student", Prompt: "Напиши student.name", Kind: stepText},
+			},
+		},
+	}
+
+	byCommand := make(map[string]*wizard, len(wizards))
+	for _, w := range wizards {
+		byCommand[w.Command] = w
+	}
+	return byCommand
+}
+
+func staticOptions(options ...string) func(context.Context, map[string]string) ([]string, error) {
+	return func(context.Context, map[string]string) ([]string, error) {
+		return options, nil
+	}
+}
+
+// courseOptions are the courses from the config and from course chats
+func (b *Bot) courseOptions(ctx context.Context, _ map[string]string) ([]string, error) {
+	known := make(map[string]bool)
+	for course := range b.config.Courses {
+		known[course] = true
+	}
+
+	mappings, err := b.tokenManager.FetchAllChatMappings(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка чатов: %v", err)
+	}
+	for _, mapping := range mappings {
+		known[mapping.Course] = true
+	}
+
+	courses := make([]string, 0, len(known))
+	for course := range known {
+		courses = append(courses, course)
+	}
+	sort.Strings(courses)
+	return courses, nil
+}
+
+func (b *Bot) labOptions(_ context.Context, values map[string]string) ([]string, error) {
+	labs, err := b.store.ListLabScores(values["course"])
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка лаб: %v", err)
+	}
+	options := make([]string, 0, len(labs))
+	for _, lab := range labs {
+		options = append(options, lab.Lab)
+	}
+	return options, nil
+}
+
+func (b *Bot) studentOptions(ctx context.Context, values map[string]string) ([]string, error) {
+	students, err := b.tokenManager.CourseRoster(ctx, values["course"])
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения списка студентов: %v", err)
+	}
+	sort.Strings(students)
+	return students, nil
+}
+
+// scoreOptions go from the base score of the lab down to zero
+func (b *Bot) scoreOptions(_ context.Context, values map[string]string) ([]string, error) {
+	lab, err := b.store.GetLabScore(values["course"], values["lab"])
+	if err != nil {
+		return nil, fmt.Errorf("ошибка получения лабы: %v", err)
+	}
+	if lab == nil {
+		return nil, nil
+	}
+	options := make([]string, 0, lab.BaseScore+1)
+	for score := lab.BaseScore; score >= 0; score-- {
+		options = append(options, strconv.Itoa(score))
+	}
+	return options, nil
+}
+
+// startWizard is the Interactive of commands with a wizard
+func (b *Bot) startWizard(command string) func(msg *tgbotapi.Message) error {
+	return func(msg *tgbotapi.Message) error {
+		b.conversations.mu.Lock()
+		b.conversations.nextID++
+		conv := &conversation{
+			id:      b.conversations.nextID,
+			wizard:  b.wizards[command],
+			userID:  msg.From.ID,
+			values:  make(map[string]string),
+			updated: time.Now(),
+		}
+		b.conversations.chats[msg.Chat.ID] = conv
+		view := conv.view()
+		b.conversations.mu.Unlock()
+
+		return b.showStep(msg.Chat.ID, view, false)
+	}
+}
+
+func (b *Bot) handleCancel(msg *tgbotapi.Message, _ Args) error {
+	b.conversations.mu.Lock()
+	conv := b.conversations.get(msg.Chat.ID)
+	if conv != nil && conv.userID == msg.From.ID {
+		delete(b.conversations.chats, msg.Chat.ID)
+	}
+	b.conversations.mu.Unlock()
+
+	if conv == nil || conv.userID != msg.From.ID {
+		return b.sendMessage(msg.Chat.ID, "Отменять нечего")
+	}
+	return b.sendMessage(msg.Chat.ID, "✖ Отменено")
+}
+
+// continueWizard takes a typed answer, false means there is no conversation
+// waiting for this user in the chat
+func (b *Bot) continueWizard(msg *tgbotapi.Message) bool {
+	b.conversations.mu.Lock()
+	conv := b.conversations.get(msg.Chat.ID)
+	if conv == nil || conv.userID != msg.From.ID || conv.step >= len(conv.wizard.Steps) {
+		b.conversations.mu.Unlock()
+		return false
+	}
+
+	step := conv.wizard.Steps[conv.step]
+	value := strings.TrimSpace(msg.Text)
+	err := b.checkAnswer(conv, step, value)
+	var view *conversation
+	if err == nil {
+		conv.values[step.Arg] = value
+		conv.next()
+		view = conv.view()
+	}
+	b.conversations.mu.Unlock()
+
+	if err != nil {
+		b.sendMessage(msg.Chat.ID, err.Error())
+		return true
+	}
+	if err := b.showStep(msg.Chat.ID, view, false); err != nil {
+		logger.Error.Printf("Wizard error: %v", err)
+		b.sendMessage(msg.Chat.ID, fmt.Sprintf("Error: %v", err))
+	}
+	return true
+}
+
+func (b *Bot) checkAnswer(conv *conversation, step wizardStep, value string) error {
+	if value == "" {
+		return fmt.Errorf("Ответ пустой, напиши ещё раз")
+	}
+	if step.Kind == stepChoice && !step.Free && !contains(conv.options, value) {
+		return fmt.Errorf("Такого варианта нет, выбери кнопкой")
+	}
+
+	cmd := b.commands.lookup(conv.wizard.Command)
+	for _, arg := range cmd.Args {
+		if arg.Name != step.Arg || arg.Kind == ArgString {
+			continue
+		}
+		raw := map[string][]string{"course": {conv.values["course"]}}
+		if _, err := convertArg(arg, value, raw, b.commands.locate); err != nil {
+			return fmt.Errorf("%v, напиши ещё раз", err)
+		}
+	}
+	return nil
+}
+
+func contains(options []string, value string) bool {
+	for _, option := range options {
+		if option == value {
+			return true
+		}
+	}
+	return false
+}
+
+// view copies the conversation, steps are rendered and sent from a copy
+// without holding the conversations lock
+func (c *conversation) view() *conversation {
+	view := *c
+	view.values = maps.Clone(c.values)
+	return &view
+}
+
+func (c *conversation) next() {
+	c.step++
+	c.options = nil
+	c.page = 0
+	c.month = time.Time{}
+	c.date = ""
+	c.updated = time.Now()
+}
+
+func (b *Bot) handleCallback(cb *tgbotapi.CallbackQuery) {
+	answer, err := b.pressButton(cb)
+	if err != nil {
+		logger.Error.Printf("Wizard error: %v", err)
+		b.sendMessage(cb.Message.Chat.ID, fmt.Sprintf("Error: %v", err))
+	}
+	if _, err := b.api.Request(tgbotapi.NewCallback(cb.ID, answer)); err != nil {
+		logger.Error.Printf("Failed to answer callback: %v", err)
+	}
+}
+
+// pressButton handles a press on a wizard keyboard, the answer is shown to the
+// user as a short popup
+func (b *Bot) pressButton(cb *tgbotapi.CallbackQuery) (string, error) {
+	if cb.Message == nil {
+		return "", nil
+	}
+	chatID := cb.Message.Chat.ID
+
+	action, view, answer := b.conversations.press(cb)
+	switch {
+	case view == nil:
+		return answer, nil
+	case action == "cancel":
+		return "", b.editMessage(chatID, view.messageID, "✖ Отменено")
+	case action == "ok":
+		return "", b.runWizard(cb, view)
+	default:
+		return "", b.showStep(chatID, view, true)
+	}
+}
+
+// runWizard runs the command with the collected answers on behalf of whoever pressed the button
+func (b *Bot) runWizard(cb *tgbotapi.CallbackQuery, conv *conversation) error {
+	cmd := b.commands.lookup(conv.wizard.Command)
+	words := cmd.words(conv.values)
+	if err := b.editMessage(cb.Message.Chat.ID, cb.Message.MessageID, "⏳ "+commandLine(cmd, words)); err != nil {
+		logger.Error.Printf("Failed to update wizard message: %v", err)
+	}
+
+	msg := &tgbotapi.Message{
+		MessageID: cb.Message.MessageID,
+		Chat:      cb.Message.Chat,
+		From:      cb.From,
+	}
+	if cmd.Permission > b.permission(msg) {
+		return fmt.Errorf("недостаточно прав для /%s", cmd.Name)
+	}
+	args, err := cmd.Parse(words, b.commands.locate)
+	if err != nil {
+		return err
+	}
+	return cmd.Handler(msg, args)
+}
+
+// showStep sends or edits the message of the current step of view, a copy of
+// the conversation. It runs without the conversations lock since options come
+// from the store and the message goes to Telegram, what the buttons refer to
+// is saved back while the conversation is still at that step.
+func (b *Bot) showStep(chatID int64, view *conversation, edit bool) error {
+	text, keyboard, err := b.renderStep(view)
+
+	b.conversations.mu.Lock()
+	if conv := b.conversations.current(chatID, view); conv != nil {
+		if err != nil {
+			delete(b.conversations.chats, chatID)
+		} else {
+			conv.options, conv.month = view.options, view.month
+		}
+	}
+	b.conversations.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if edit && view.messageID != 0 {
+		_, err := b.api.Send(tgbotapi.NewEditMessageTextAndMarkup(chatID, view.messageID, text, keyboard))
+		return err
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = keyboard
+	sent, err := b.api.Send(msg)
+	if err != nil {
+		return err
+	}
+
+	b.conversations.mu.Lock()
+	if conv := b.conversations.current(chatID, view); conv != nil {
+		conv.messageID = sent.MessageID
+	}
+	b.conversations.mu.Unlock()
+	return nil
+}
+
+func (b *Bot) renderStep(conv *conversation) (string, tgbotapi.InlineKeyboardMarkup, error) {
+	cmd := b.commands.lookup(conv.wizard.Command)
+
+	var text strings.Builder
+	text.WriteString("🧙 " + conv.wizard.Title + "\n")
+	for _, step := range conv.wizard.Steps[:conv.step] {
+		if value, ok := conv.values[step.Arg]; ok {
+			text.WriteString(fmt.Sprintf("%s: %s\n", step.Arg, value))
+		}
+	}
+
+	if conv.step == len(conv.wizard.Steps) {
+		text.WriteString("\nВсё верно?\n" + commandLine(cmd, cmd.words(conv.values)))
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Выполнить", conv.callback("ok")),
+			tgbotapi.NewInlineKeyboardButtonData("✖ Отмена", conv.callback("cancel")),
+		))
+		return text.String(), keyboard, nil
+	}
+
+	step := conv.wizard.Steps[conv.step]
+	text.WriteString("\n" + step.Prompt)
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	switch step.Kind {
+	case stepChoice:
+		if conv.options == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), operationTimeout)
+			defer cancel()
+			options, err := step.Options(ctx, conv.values)
+			if err != nil {
+				return "", tgbotapi.InlineKeyboardMarkup{}, err
+			}
+			if options == nil {
+				options = []string{}
+			}
+			conv.options = options
+		}
+		if len(conv.options) == 0 && !step.Free {
+			return "", tgbotapi.InlineKeyboardMarkup{}, fmt.Errorf("не из чего выбрать %s", step.Arg)
+		}
+		rows = conv.optionRows()
+	case stepDate:
+		rows = conv.dateRows(b.config.Courses.Location(conv.values["course"]))
+	}
+
+	var last []tgbotapi.InlineKeyboardButton
+	if step.Optional {
+		last = append(last, tgbotapi.NewInlineKeyboardButtonData("Пропустить", conv.callback("skip")))
+	}
+	last = append(last, tgbotapi.NewInlineKeyboardButtonData("✖ Отмена", conv.callback("cancel")))
+	rows = append(rows, last)
+
+	return text.String(), tgbotapi.NewInlineKeyboardMarkup(rows...), nil
+}
+
+func (c *conversation) callback(action string) string {
+	return fmt.Sprintf("%s:%d:%d:%s", callbackPrefix, c.id, c.step, action)
+}
+
+// optionRows shows a page of options two in a row
+func (c *conversation) optionRows() [][]tgbotapi.InlineKeyboardButton {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	start := c.page * wizardPageSize
+	end := min(start+wizardPageSize, len(c.options))
+	for i := start; i < end; i += 2 {
+		row := []tgbotapi.InlineKeyboardButton{tgbotapi.NewInlineKeyboardButtonData(c.options[i], c.callback(fmt.Sprintf("o:%d", i)))}
+		if i+1 < end {
+			row = append(row, tgbotapi.NewInlineKeyboardButtonData(c.options[i+1], c.callback(fmt.Sprintf("o:%d", i+1))))
+		}
+		rows = append(rows, row)
+	}
+
+	var nav []tgbotapi.InlineKeyboardButton
+	if c.page > 0 {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("‹", c.callback(fmt.Sprintf("p:%d", c.page-1))))
+	}
+	if end < len(c.options) {
+		nav = append(nav, tgbotapi.NewInlineKeyboardButtonData("›", c.callback(fmt.Sprintf("p:%d", c.page+1))))
+	}
+	if len(nav) > 0 {
+		rows = append(rows, nav)
+	}
+	return rows
+}
+
+// dateRows is a month calendar, or the times of the picked day
+func (c *conversation) dateRows(loc *time.Location) [][]tgbotapi.InlineKeyboardButton {
+	if c.date != "" {
+		var times []tgbotapi.InlineKeyboardButton
+		for _, t := range wizardTimes {
+			label := t
+			if t == "" {
+				label = "23:59"
+			}
+			times = append(times, tgbotapi.NewInlineKeyboardButtonData(label, c.callback("t:"+t)))
+		}
+		return [][]tgbotapi.InlineKeyboardButton{
+			{tgbotapi.NewInlineKeyboardButtonData("📅 "+c.date, c.callback("noop"))},
+			times,
+			{tgbotapi.NewInlineKeyboardButtonData("‹ Другой день", c.callback("d:"))},
+		}
+	}
+
+	if c.month.IsZero() {
+		now := time.Now().In(loc)
+		c.month = time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	month := c.month
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		{tgbotapi.NewInlineKeyboardButtonData(month.Format("January 2006"), c.callback("noop"))},
+	}
+	var weekdays []tgbotapi.InlineKeyboardButton
+	for _, day := range []string{"Пн", "Вт", "Ср", "Чт", "Пт", "Сб", "Вс"} {
+		weekdays = append(weekdays, tgbotapi.NewInlineKeyboardButtonData(day, c.callback("noop")))
+	}
+	rows = append(rows, weekdays)
+
+	// weeks start on monday
+	week := make([]tgbotapi.InlineKeyboardButton, 0, 7)
+	for i := 0; i < (int(month.Weekday())+6)%7; i++ {
+		week = append(week, tgbotapi.NewInlineKeyboardButtonData(" ", c.callback("noop")))
+	}
+	for day := month; day.Month() == month.Month(); day = day.AddDate(0, 0, 1) {
+		week = append(week, tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(day.Day()), c.callback("d:"+day.Format("2006-01-02"))))
+		if len(week) == 7 {
+			rows = append(rows, week)
+			week = make([]tgbotapi.InlineKeyboardButton, 0, 7)
+		}
+	}
+	if len(week) > 0 {
+		for len(week) < 7 {
+			week = append(week, tgbotapi.NewInlineKeyboardButtonData(" ", c.callback("noop")))
+		}
+		rows = append(rows, week)
+	}
+
+	return append(rows, []tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardButtonData("‹", c.callback("m:"+month.AddDate(0, -1, 0).Format("2006-01"))),
+		tgbotapi.NewInlineKeyboardButtonData("›", c.callback("m:"+month.AddDate(0, 1, 0).Format("2006-01"))),
+	})
+}
+
+// commandLine is the command as it could be typed, to show what is going to run
+func commandLine(cmd *Command, words []string) string {
+	parts := []string{"/" + cmd.Name}
+	for _, word := range words {
+		if word == "" || strings.ContainsAny(word, " \t\n\"'\\“„«") {
+			word = `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(word) + `"`
+		}
+		parts = append(parts, word)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,313 @@
+package bot
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// say sends a plain text message, like an answer to a wizard
+func (tb *testBot) say(chatType string, chatID, fromID int64, text string) {
+	tb.handleMessage(&tgbotapi.Message{
+		MessageID: 2,
+		Text:      text,
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: chatType},
+		From:      &tgbotapi.User{ID: fromID},
+	})
+}
+
+// keyboard returns the latest message to chatID that has buttons
+func (tb *testBot) keyboard(t *testing.T, chatID int64) sentMessage {
+	t.Helper()
+	tb.api.mu.Lock()
+	defer tb.api.mu.Unlock()
+
+	for i := len(tb.api.sent) - 1; i >= 0; i-- {
+		if msg := tb.api.sent[i]; msg.chatID == chatID && msg.keyboard != nil {
+			return msg
+		}
+	}
+	require.Fail(t, "no keyboard sent", "chat %d", chatID)
+	return sentMessage{}
+}
+
+func buttonData(t *testing.T, msg sentMessage, label string) string {
+	t.Helper()
+	var labels []string
+	for _, row := range msg.keyboard.InlineKeyboard {
+		for _, button := range row {
+			if button.Text == label {
+				return *button.CallbackData
+			}
+			labels = append(labels, button.Text)
+		}
+	}
+	require.Fail(t, "no button", "%q among %q", label, labels)
+	return ""
+}
+
+func (tb *testBot) callback(chatID, fromID int64, messageID int, data string) string {
+	tb.api.mu.Lock()
+	answers := len(tb.api.answers)
+	tb.api.mu.Unlock()
+
+	tb.handleCallback(&tgbotapi.CallbackQuery{
+		ID:      "cb",
+		From:    &tgbotapi.User{ID: fromID, UserName: "teacher"},
+		Message: &tgbotapi.Message{MessageID: messageID, Chat: &tgbotapi.Chat{ID: chatID, Type: "private"}},
+		Data:    data,
+	})
+
+	tb.api.mu.Lock()
+	defer tb.api.mu.Unlock()
+	if len(tb.api.answers) == answers {
+		return "<no answer>"
+	}
+	return tb.api.answers[len(tb.api.answers)-1]
+}
+
+// press presses the button with label on the latest keyboard of the admin chat
+func (tb *testBot) press(t *testing.T, label string) string {
+	t.Helper()
+	msg := tb.keyboard(t, testAdminID)
+	return tb.callback(testAdminID, testAdminID, msg.messageID, buttonData(t, msg, label))
+}
+
+func (tb *testBot) answer(text string) {
+	tb.say("private", testAdminID, testAdminID, text)
+}
+
+func TestLabAddWizard(t *testing.T) {
+	tb := newTestBot(t)
+
+	tb.admin("/lab add")
+	assert.Contains(t, tb.keyboard(t, testAdminID).text, "🧙 Новая лабораторная\n\nВыбери курс")
+	assert.Equal(t, "", tb.press(t, "DE15"))
+
+	prompt := tb.keyboard(t, testAdminID)
+	assert.Contains(t, prompt.text, "course: DE15\n\nНапиши название новой лабы")
+	tb.answer("02s")
+
+	assert.Contains(t, tb.keyboard(t, testAdminID).text, "lab: 02s\n\nСколько баллов")
+	tb.answer("ten")
+	tb.requireSent(t, testAdminID, `score должно быть целым числом, а не "ten", напиши ещё раз`)
+	tb.press(t, "10")
+
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	require.NoError(t, err)
+	now := time.Now().In(moscow)
+	next := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC).AddDate(0, 1, 0)
+
+	calendar := tb.keyboard(t, testAdminID)
+	assert.Contains(t, calendar.text, "Когда дедлайн?")
+	buttonData(t, calendar, "Пн")
+	tb.press(t, "›")
+	buttonData(t, tb.keyboard(t, testAdminID), next.Format("January 2006"))
+	tb.press(t, "15")
+	tb.press(t, "‹ Другой день")
+	tb.press(t, "15")
+	tb.press(t, "18:00")
+
+	deadline := next.Format("2006-01") + "-15T18:00"
+	confirm := tb.keyboard(t, testAdminID)
+	assert.Contains(t, confirm.text, "deadline: "+deadline+"\n\nВсё верно?\n/lab add DE15 02s score 10 deadline "+deadline)
+	tb.press(t, "✅ Выполнить")
+	tb.requireSent(t, testAdminID, "✅ Лабораторная 02s для курса DE15 добавлена", "Баллы: 10")
+
+	lab, err := tb.store.GetLabScore("DE15", "02s")
+	require.NoError(t, err)
+	require.NotNil(t, lab)
+	want := time.Date(next.Year(), next.Month(), 15, 18, 0, 0, 0, moscow)
+	assert.Equal(t, want.Unix(), lab.Deadline)
+
+	t.Run("conversation is over", func(t *testing.T) {
+		tb.api.reset()
+		tb.answer("02s")
+		tb.requireSent(t, testAdminID, "Отправьте /help для списка команд")
+	})
+
+	t.Run("typed date", func(t *testing.T) {
+		tb.admin("/lab add")
+		tb.press(t, "DE15")
+		tb.answer("03s")
+		tb.press(t, "15")
+		tb.answer("31.12.2099")
+		tb.requireSent(t, testAdminID, "некорректная дата")
+		tb.answer("2099-12-31")
+		tb.press(t, "✅ Выполнить")
+		tb.requireSent(t, testAdminID, "✅ Лабораторная 03s для курса DE15 добавлена", "Дедлайн: 2099-12-31 23:59")
+	})
+}
+
+func TestOverrideSetWizard(t *testing.T) {
+	tb := newTestBot(t)
+	tb.seedCourse(t)
+
+	tb.admin("/override set")
+	tb.press(t, "DE15")
+
+	tb.answer("09s")
+	tb.requireSent(t, testAdminID, "Такого варианта нет, выбери кнопкой")
+	tb.press(t, "01s")
+	tb.press(t, "bob.b")
+
+	// base score down to zero, the first page ends at 3
+	scores := tb.keyboard(t, testAdminID)
+	buttonData(t, scores, "10")
+	buttonData(t, scores, "3")
+	tb.press(t, "›")
+	tb.press(t, "0")
+
+	assert.Contains(t, tb.keyboard(t, testAdminID).text, "score: 0\n\nНапиши причину")
+	tb.answer(`Списал "всё"`)
+	assert.Contains(t, tb.keyboard(t, testAdminID).text, `/override set DE15 01s bob.b score 0 reason "Списал \"всё\""`)
+	tb.press(t, "✅ Выполнить")
+	tb.requireSent(t, testAdminID, "✅ Оверрайд для студента DE15/01s/bob.b добавлен", `Причина: Списал "всё"`)
+
+	override, err := tb.store.GetScoreOverride("DE15", "01s", "bob.b")
+	require.NoError(t, err)
+	require.NotNil(t, override)
+	assert.Equal(t, 0, override.Score)
+	assert.Equal(t, `Списал "всё"`, override.Reason)
+
+	t.Run("skip reason", func(t *testing.T) {
+		tb.admin("/override set")
+		tb.press(t, "DE15")
+		tb.press(t, "01s")
+		tb.answer("alice.a")
+		tb.answer("7")
+		tb.press(t, "Пропустить")
+		assert.True(t, strings.HasSuffix(tb.keyboard(t, testAdminID).text, "\n/override set DE15 01s alice.a score 7"))
+		tb.press(t, "✅ Выполнить")
+		tb.requireSent(t, testAdminID, "DE15/01s/alice.a добавлен", "Баллы: 7")
+	})
+
+	t.Run("course without labs", func(t *testing.T) {
+		tb.admin("/override set")
+		tb.answer("DE16")
+		tb.requireSent(t, testAdminID, "Error: не из чего выбрать lab")
+
+		tb.api.reset()
+		tb.answer("01s")
+		tb.requireSent(t, testAdminID, "Отправьте /help для списка команд")
+	})
+}
+
+func TestMapStudentWizard(t *testing.T) {
+	tb := newTestBot(t)
+	tb.seedCourse(t)
+
+	tb.admin("/map_student")
+	tb.press(t, "DE15")
+	tb.answer("@carol_tg")
+	tb.answer("carol.c")
+	assert.Contains(t, tb.keyboard(t, testAdminID).text, "/map_student @carol_tg carol.c course DE15")
+	tb.press(t, "✅ Выполнить")
+	tb.requireSent(t, testAdminID, "✅ Student mapping created", "Course: DE15", "Telegram: @carol_tg", "Student ID: carol.c")
+
+	// typed with the course keyword too
+	tb.admin("/map_student @dan_tg dan.d course DE15")
+	tb.requireSent(t, testAdminID, "Telegram: @dan_tg", "Course: DE15")
+}
+
+func TestWizardGuards(t *testing.T) {
+	tb := newTestBot(t)
+
+	tb.admin("/lab add")
+	first := tb.keyboard(t, testAdminID)
+	course := buttonData(t, first, "DE15")
+
+	assert.Equal(t, "Это не твой диалог", tb.callback(testAdminID, testStudentID, first.messageID, course))
+	assert.Equal(t, "", tb.callback(testAdminID, testAdminID, first.messageID, course))
+	assert.Equal(t, "Кнопка устарела", tb.callback(testAdminID, testAdminID, first.messageID, course))
+	assert.Equal(t, "", tb.callback(testAdminID, testAdminID, first.messageID, "something else"))
+
+	t.Run("others can't answer", func(t *testing.T) {
+		tb.say("private", testAdminID, testStudentID, "02s")
+		tb.requireSent(t, testAdminID, "Отправьте /help для списка команд")
+		assert.NotContains(t, tb.keyboard(t, testAdminID).text, "lab: 02s")
+	})
+
+	t.Run("cancel button", func(t *testing.T) {
+		tb.press(t, "✖ Отмена")
+		tb.requireSent(t, testAdminID, "edit: ✖ Отменено")
+		assert.Equal(t, "Кнопка устарела", tb.press(t, "✖ Отмена"))
+	})
+
+	t.Run("cancel command", func(t *testing.T) {
+		tb.admin("/cancel")
+		tb.requireSent(t, testAdminID, "Отменять нечего")
+
+		tb.admin("/lab add")
+		tb.admin("/cancel")
+		tb.requireSent(t, testAdminID, "✖ Отменено")
+
+		tb.api.reset()
+		tb.answer("DE15")
+		tb.requireSent(t, testAdminID, "Отправьте /help для списка команд")
+	})
+
+	t.Run("students get no wizard", func(t *testing.T) {
+		tb.api.reset()
+		tb.student("/lab add")
+		tb.requireSent(t, testStudentID, "Отправьте /help для списка команд")
+		assert.Len(t, tb.api.texts(testStudentID), 1)
+	})
+
+	t.Run("help mentions wizards", func(t *testing.T) {
+		tb.admin("/help")
+		help := tb.requireSent(t, testAdminID, "Доступные команды:")
+		assert.Equal(t, 3, strings.Count(help, "(без аргументов - по шагам)"))
+	})
+}
+
+func TestWizardSendsWithoutLock(t *testing.T) {
+	tb := newTestBot(t)
+	tb.seedCourse(t)
+
+	// a slow Telegram call in one chat must not hold up wizards in other chats
+	sends := 0
+	tb.api.onSend = func() {
+		sends++
+		if assert.True(t, tb.conversations.mu.TryLock(), "conversations are locked while sending") {
+			tb.conversations.mu.Unlock()
+		}
+	}
+
+	tb.admin("/override set")
+	tb.press(t, "DE15")
+	tb.press(t, "01s")
+	tb.answer("bob.b")
+	tb.answer("7")
+	tb.press(t, "Пропустить")
+	tb.press(t, "✅ Выполнить")
+	tb.requireSent(t, testAdminID, "DE15/01s/bob.b добавлен")
+	assert.Greater(t, sends, 6)
+}
+
+func TestCalendarRows(t *testing.T) {
+	conv := &conversation{id: 1, month: time.Date(2024, 12, 1, 0, 0, 0, 0, time.UTC)}
+	rows := conv.dateRows(time.UTC)
+
+	// title, weekdays, six weeks since december 2024 starts on sunday, navigation
+	require.Len(t, rows, 9)
+	assert.Equal(t, "December 2024", rows[0][0].Text)
+	for _, week := range rows[2:8] {
+		assert.Len(t, week, 7)
+	}
+	assert.Equal(t, " ", rows[2][5].Text)
+	assert.Equal(t, "1", rows[2][6].Text)
+	assert.Equal(t, "wz:1:0:d:2024-12-01", *rows[2][6].CallbackData)
+	assert.Equal(t, "31", rows[7][1].Text)
+	assert.Equal(t, "wz:1:0:m:2024-11", *rows[8][0].CallbackData)
+	assert.Equal(t, "wz:1:0:m:2025-01", *rows[8][1].CallbackData)
+
+	conv.date = "2024-12-01"
+	rows = conv.dateRows(time.UTC)
+	assert.Equal(t, "wz:1:0:t:", *rows[1][0].CallbackData)
+	assert.Equal(t, "wz:1:0:t:18:00", *rows[1][3].CallbackData)
+}
@@ -19,11 +19,12 @@ import (
 type GSheetExporter struct {
 	config        *app.Config
 	store         store.ScoreStore
+	snapshots     *app.Snapshots
 	scheduler     *gocron.Scheduler
 	sheetsService *sheets.Service
 }
 
-func NewGSheetExporter(config *app.Config, store store.ScoreStore) (*GSheetExporter, error) {
+func NewGSheetExporter(config *app.Config, store store.ScoreStore, snapshots *app.Snapshots) (*GSheetExporter, error) {
 	ctx := context.Background()
 	scheduler := gocron.NewScheduler(time.UTC)
 
@@ -37,6 +38,7 @@ func NewGSheetExporter(config *app.Config, store store.ScoreStore) (*GSheetExpor
 			exporter := &GSheetExporter{
 				config:        config,
 				store:         store,
+				snapshots:     snapshots,
 				scheduler:     scheduler,
 				sheetsService: svc,
 			}
@@ -83,6 +85,19 @@ func (e *GSheetExporter) Export(courseName string, cfg *app.GSheetConfig) error
 			studentRows[student] = startRow + i // Assuming start from row 4
 		}
 	}
+	var gradebook *app.Gradebook
+	if cfg.Snapshot != "" {
+		gradebook, err = e.snapshots.Get(courseName, cfg.Snapshot)
+		if err != nil {
+			return fmt.Errorf("failed to load snapshot %s: %w", cfg.Snapshot, err)
+		}
+	} else if cfg.Scoring {
+		gradebook, err = e.snapshots.Get(courseName, app.LiveGradebook)
+		if err != nil {
+			return fmt.Errorf("failed to build gradebook: %w", err)
+		}
+	}
+
 	// Prepare for batc hupdate
 	var valueRanges []*sheets.ValueRange
 
@@ -95,22 +110,17 @@ func (e *GSheetExporter) Export(courseName string, cfg *app.GSheetConfig) error
 		col := string(byte('A' + labColOffset + labIdx))
 
 		for student, row := range studentRows {
-			event, err := e.store.GetStudentFinishEvent(courseName, lab, student)
-			if err != nil {
-				continue
-			}
-
 			var value interface{} = ""
-			if !cfg.Scoring {
-				if event == nil {
-					value = ""
-				} else {
-					value = "✓"
-				}
+			if gradebook != nil {
+				// students missing from the gradebook haven't done anything yet
+				value = gradebook.Scores[student][lab]
 			} else {
-				score, err := e.store.GetLabScore(courseName, lab)
-				if err == nil && score != nil {
-					value = score.BaseScore
+				event, err := e.store.GetStudentFinishEvent(courseName, lab, student)
+				if err != nil {
+					continue
+				}
+				if event != nil {
+					value = "✓"
 				}
 			}
 
@@ -124,7 +134,14 @@ func (e *GSheetExporter) Export(courseName string, cfg *app.GSheetConfig) error
 
 	// Update timestamp
 	emoji := e.config.RandomEmoji()
-	timestamp := fmt.Sprintf("UPD: %s", time.Now().Format("2 January 15:04"))
+	timestamp := fmt.Sprintf("UPD: %s", time.Now().In(e.config.Courses.Location(courseName)).Format("2 January 15:04 MST"))
+	if cfg.Snapshot != "" {
+		timestamp = fmt.Sprintf(
+			"SNAPSHOT %s: %s",
+			gradebook.Name,
+			app.FormatTimestamp(gradebook.CreatedAt, e.config.Courses.Location(courseName), "2 January 15:04"),
+		)
+	}
 	timestampRange := fmt.Sprintf("%s!%s", cfg.SheetName, cfg.TimestampRange)
 	valueRanges = append(valueRanges, &sheets.ValueRange{
 		Range:  timestampRange,
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/shrimpsizemoose/trekker/logger"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/anomaly"
+)
+
+func (h *EntryHandler) HandleAnomalies(w http.ResponseWriter, r *http.Request) {
+	course := r.PathValue("course")
+	if course == "" {
+		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
+		http.Error(w, "Invalid course", http.StatusBadRequest)
+		return
+	}
+
+	found, err := h.service.DetectAnomalies(course)
+	if err != nil {
+		logger.Error.Printf("Failed to detect anomalies for course %s: %v", course, err)
+		http.Error(w, "Failed to detect anomalies", http.StatusInternalServerError)
+		return
+	}
+
+	if kind := r.URL.Query().Get("kind"); kind != "" {
+		filtered := []anomaly.Anomaly{}
+		for _, a := range found {
+			if string(a.Kind) == kind {
+				filtered = append(filtered, a)
+			}
+		}
+		found = filtered
+	}
+	if found == nil {
+		found = []anomaly.Anomaly{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"course":    course,
+		"anomalies": found,
+	}); err != nil {
+		logger.Error.Printf("Failed to encode anomalies response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
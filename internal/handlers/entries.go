@@ -2,7 +2,11 @@ package handlers
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"io"
+	"math"
+	"strconv"
 	"time"
 
 	"encoding/json"
@@ -13,6 +17,7 @@ import (
 	"github.com/shrimpsizemoose/kanelbulle/internal/app"
 	"github.com/shrimpsizemoose/kanelbulle/internal/metrics"
 	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+	"github.com/shrimpsizemoose/kanelbulle/internal/scoring"
 )
 
 type EntryHandler struct {
@@ -25,6 +30,73 @@ func NewEntryHandler(service *app.Service) *EntryHandler {
 	}
 }
 
+// authErrorMessage tells students why their token stopped working without leaking anything else
+func authErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, app.ErrTokenExpired):
+		return "Token expired, get a new one from the bot"
+	case errors.Is(err, app.ErrTokenRevoked):
+		return "Token revoked, get a new one from the bot"
+	case errors.Is(err, app.ErrStudentMismatch), errors.Is(err, app.ErrCourseMismatch):
+		// the token owner is told about their own token only
+		return err.Error()
+	case errors.Is(err, app.ErrStudentMissing):
+		return "Invalid student id specified"
+	default:
+		return "Unauthorized"
+	}
+}
+
+type apiKeyContextKey struct{}
+
+// Require wraps a route with an api key check for the scope, requests with
+// no usable credentials don't learn the route exists
+func (h *EntryHandler) Require(scope app.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := h.service.Authorize(r, scope, r.PathValue("course"))
+		switch {
+		case err == nil:
+			if key != nil {
+				r = r.WithContext(context.WithValue(r.Context(), apiKeyContextKey{}, key.Name))
+			}
+			next(w, r)
+		case errors.Is(err, app.ErrAPIKeyForbidden):
+			logger.Error.Printf("Api key rejected for %s: %v", r.URL.Path, err)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		case errors.Is(err, app.ErrAPIKeyMissing), errors.Is(err, app.ErrAPIKeyInvalid):
+			http.Error(w, "these are not the droids you are looking for", http.StatusNotFound)
+		default:
+			logger.Error.Printf("Api key check failed: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}
+	}
+}
+
+// callerName is the name of the api key the request came with, requests let in
+// by the required headers alone are just "api"
+func callerName(r *http.Request) string {
+	if name, ok := r.Context().Value(apiKeyContextKey{}).(string); ok {
+		return name
+	}
+	return "api"
+}
+
+// signatureErrorMessage helps checker authors debug signing without saying what the secret is
+func signatureErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, app.ErrSignatureMissing):
+		return "Signed request required"
+	case errors.Is(err, app.ErrSignatureStale):
+		return "Request timestamp too far from server time"
+	case errors.Is(err, app.ErrNonceReused):
+		return "Request already seen"
+	case app.IsSignatureError(err):
+		return "Unauthorized"
+	default:
+		return "Try again later"
+	}
+}
+
 func (h *EntryHandler) HandleLabEvent(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
 	defer func() {
@@ -40,11 +112,6 @@ func (h *EntryHandler) HandleLabEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.service.ValidateHeaders(r.Header) {
-		http.Error(w, "these are not the droids you are looking for", http.StatusForbidden)
-		return
-	}
-
 	course := r.PathValue("course")
 	if course == "" {
 		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
@@ -59,18 +126,6 @@ func (h *EntryHandler) HandleLabEvent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	student := r.Header.Get(h.service.Config.API.StudentIDHeader)
-	if student == "" {
-		http.Error(w, "Invalid student id specified", http.StatusUnauthorized)
-		return
-	}
-
-	if err := h.service.ValidateAuthAndStudent(r, course, student); err != nil {
-		logger.Error.Printf("Auth failed: %v", err)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
-		return
-	}
-
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
 		logger.Error.Printf("Failed to read request body: %v", err)
@@ -79,18 +134,51 @@ func (h *EntryHandler) HandleLabEvent(w http.ResponseWriter, r *http.Request) {
 	}
 	logger.Debug.Printf("Received request body: %s", string(body))
 
-	r.Body = io.NopCloser(bytes.NewBuffer(body))
-
 	var entry models.Entry
-	if err := json.NewDecoder(r.Body).Decode(&entry); err != nil {
+	if err := json.NewDecoder(bytes.NewReader(body)).Decode(&entry); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 	entry.Timestamp = time.Now().Unix()
 	entry.Lab = lab
-	entry.Student = student
+	// the body doesn't get to name the student, auth does below
+	entry.Student = ""
 	entry.Course = course
 	entry.Comment = string(body)
+
+	// the ip limit goes first so bad tokens and signatures don't reach the stores unthrottled
+	if !h.allowEvent(w, r, &entry, "", h.clientIP(r)) {
+		return
+	}
+
+	student, err := h.service.AuthenticateStudent(r, course, r.Header.Get(h.service.Config.API.StudentIDHeader))
+	if err != nil {
+		logger.Error.Printf("Auth failed: %v", err)
+		http.Error(w, authErrorMessage(err), http.StatusUnauthorized)
+		return
+	}
+
+	if err := h.service.VerifySignature(r, course, lab, student, body); err != nil {
+		logger.Error.Printf("Signature check failed for %s/%s/%s: %v", course, lab, student, err)
+		status := http.StatusUnauthorized
+		if !app.IsSignatureError(err) {
+			// nonces can't be checked, refusing is safer than accepting a replay
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, signatureErrorMessage(err), status)
+		return
+	}
+	entry.Student = student
+
+	if !h.allowEvent(w, r, &entry, student, "") {
+		return
+	}
+
+	if err := h.service.CheckChallenge(r, &entry); err != nil {
+		logger.Error.Printf("Rejected finish of %s/%s/%s: %v", entry.Course, entry.Lab, entry.Student, err)
+		http.Error(w, "Finish doesn't answer the challenge of a start: "+err.Error(), http.StatusForbidden)
+		return
+	}
 	logger.Debug.Printf("Saving entry %v", entry)
 
 	if err := h.service.Store.CreateEntry(&entry); err != nil {
@@ -103,22 +191,56 @@ func (h *EntryHandler) HandleLabEvent(w http.ResponseWriter, r *http.Request) {
 		entry.Lab,
 		entry.EventType,
 	).Inc()
+	h.service.RecordTokenUse(r, entry.Course, entry.Student, entry.Lab, entry.EventType)
+
+	if nonce := h.service.IssueChallenge(r.Context(), &entry); nonce != "" {
+		w.Header().Set(app.ChallengeHeader, nonce)
+	}
 
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("OK"))
 }
 
+// clientIP is the address the ip limit counts, empty without a limiter
+func (h *EntryHandler) clientIP(r *http.Request) string {
+	if h.service.RateLimiter == nil {
+		return ""
+	}
+	return h.service.RateLimiter.ClientIP(r)
+}
+
+// allowEvent applies the rate limit of the student or the ip, whichever is
+// given, and writes 429 when it is hit. A broken limiter lets events through
+// rather than losing them.
+func (h *EntryHandler) allowEvent(w http.ResponseWriter, r *http.Request, entry *models.Entry, student, ip string) bool {
+	limiter := h.service.RateLimiter
+	if limiter == nil {
+		return true
+	}
+
+	err := limiter.Allow(r.Context(), entry.Course, entry.EventType, student, ip)
+	var limited *app.RateLimited
+	switch {
+	case err == nil:
+		return true
+	case errors.As(err, &limited):
+		metrics.RateLimitedTotal.WithLabelValues(entry.Course, entry.EventType, limited.Scope).Inc()
+		logger.Debug.Printf("Rate limited %s/%s/%s by %s", entry.Course, entry.Student, entry.EventType, limited.Scope)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.RetryAfter.Seconds()))))
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+		return false
+	default:
+		logger.Error.Printf("Rate limiter failed, letting the event through: %v", err)
+		return true
+	}
+}
+
 func (h *EntryHandler) HandleLabInfo(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	if !h.service.ValidateHeaders(r.Header) {
-		http.Error(w, "these are not the droids you are looking for", http.StatusNotFound)
-		return
-	}
-
 	course := r.PathValue("course")
 	if course == "" {
 		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
@@ -149,12 +271,8 @@ func (h *EntryHandler) HandleLabFinishInfo(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	if !h.service.ValidateHeaders(r.Header) {
-		http.Error(w, "these are not the droids you are looking for", http.StatusNotFound)
-		return
-	}
-
 	includeHumanDttm := r.URL.Query().Get("human_dttm") == "true"
+	byTeam := r.URL.Query().Get("group_by") == "team"
 	course := r.PathValue("course")
 	if course == "" {
 		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
@@ -162,7 +280,7 @@ func (h *EntryHandler) HandleLabFinishInfo(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	stats, err := h.service.GetDetailedStats(course, includeHumanDttm)
+	stats, err := h.service.GetDetailedStats(course, includeHumanDttm, byTeam)
 	if err != nil {
 		logger.Error.Printf("Failed to fetch stats: %v", err)
 		http.Error(w, "Failed to fetch stats", http.StatusInternalServerError)
@@ -185,11 +303,6 @@ func (h *EntryHandler) HandleScoring(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !h.service.ValidateHeaders(r.Header) {
-		http.Error(w, "these are not the droids you are looking for", http.StatusNotFound)
-		return
-	}
-
 	course := r.PathValue("course")
 	if course == "" {
 		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
@@ -197,7 +310,7 @@ func (h *EntryHandler) HandleScoring(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	scores, err := h.service.GetScoring(course)
+	matrix, err := h.service.GetScoring(course)
 	if err != nil {
 		logger.Error.Printf("Failed to get scoring for course %s: %v", course, err)
 		http.Error(w, "Failed to fetch scoring", http.StatusInternalServerError)
@@ -206,10 +319,102 @@ func (h *EntryHandler) HandleScoring(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(map[string]interface{}{
-		"stats": scores,
+		"stats":    matrix.Scores(),
+		"statuses": matrix.Statuses(),
 	}); err != nil {
 		logger.Error.Printf("Failed to encode scoring response: %v", err)
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 		return
 	}
 }
+
+func (h *EntryHandler) HandleScoringExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	course := r.PathValue("course")
+	if course == "" {
+		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
+		http.Error(w, "Invalid course", http.StatusBadRequest)
+		return
+	}
+
+	student := r.URL.Query().Get("student")
+	lab := r.URL.Query().Get("lab")
+	if student == "" || lab == "" {
+		http.Error(w, "Both student and lab query parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	explanation, err := h.service.Grader.ExplainScore(course, lab, student)
+	if err != nil {
+		logger.Error.Printf("Failed to explain score for %s/%s/%s: %v", course, lab, student, err)
+		http.Error(w, "Failed to explain score", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"explanation": explanation,
+	}); err != nil {
+		logger.Error.Printf("Failed to encode explanation response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// simulationRequest takes deadlines as dates in the course timezone, same as /lab add
+type simulationRequest struct {
+	scoring.Scenario
+	Deadlines map[string]string `json:"deadlines"`
+}
+
+func (h *EntryHandler) HandleScoringSimulate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	course := r.PathValue("course")
+	if course == "" {
+		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
+		http.Error(w, "Invalid course", http.StatusBadRequest)
+		return
+	}
+
+	var req simulationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	scenario := req.Scenario
+	scenario.Deadlines = make(map[string]int64, len(req.Deadlines))
+	loc := h.service.Config.Courses.Location(course)
+	for lab, value := range req.Deadlines {
+		deadline, err := app.ParseDeadline(value, loc)
+		if err != nil {
+			http.Error(w, "Invalid deadline for lab "+lab+": "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		scenario.Deadlines[lab] = deadline.Unix()
+	}
+
+	result, err := h.service.Grader.Simulate(course, scenario)
+	if err != nil {
+		logger.Error.Printf("Failed to simulate scoring for course %s: %v", course, err)
+		http.Error(w, "Failed to simulate scoring", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"simulation": result,
+	}); err != nil {
+		logger.Error.Printf("Failed to encode simulation response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+	"github.com/shrimpsizemoose/kanelbulle/internal/store/sqlite"
+)
+
+type stubLimiter struct {
+	err error
+	// calls are the course/event/student/ip of every Allow
+	calls [][4]string
+}
+
+func (l *stubLimiter) Allow(_ context.Context, course, eventType, student, ip string) error {
+	l.calls = append(l.calls, [4]string{course, eventType, student, ip})
+	return l.err
+}
+
+func (l *stubLimiter) ClientIP(*http.Request) string { return "10.0.0.1" }
+
+func (l *stubLimiter) Close() error { return nil }
+
+func TestEntryHandler_AllowEvent(t *testing.T) {
+	entry := &models.Entry{Course: "DE15", Lab: "01s", Student: "alice.a", EventType: "100_lab_finish"}
+
+	tests := []struct {
+		name       string
+		err        error
+		allowed    bool
+		status     int
+		retryAfter string
+	}{
+		{"allowed", nil, true, http.StatusOK, ""},
+		{"limited by ip", &app.RateLimited{Scope: app.RateScopeIP, RetryAfter: 1500 * time.Millisecond}, false, http.StatusTooManyRequests, "2"},
+		{"limited by student", &app.RateLimited{Scope: app.RateScopeStudent, RetryAfter: 30 * time.Second}, false, http.StatusTooManyRequests, "30"},
+		{"wrapped limit", errors.Join(errors.New("ctx"), &app.RateLimited{Scope: app.RateScopeIP, RetryAfter: time.Millisecond}), false, http.StatusTooManyRequests, "1"},
+		{"broken limiter lets events through", errors.New("redis is down"), true, http.StatusOK, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &stubLimiter{err: tt.err}
+			h := NewEntryHandler(&app.Service{RateLimiter: limiter})
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("POST", "/api/v1/DE15/analytics", nil)
+
+			assert.Equal(t, tt.allowed, h.allowEvent(w, r, entry, entry.Student, "10.0.0.1"))
+			assert.Equal(t, tt.status, w.Code)
+			assert.Equal(t, tt.retryAfter, w.Header().Get("Retry-After"))
+			assert.Equal(t, [][4]string{{"DE15", "100_lab_finish", "alice.a", "10.0.0.1"}}, limiter.calls)
+		})
+	}
+
+	t.Run("no limiter", func(t *testing.T) {
+		h := NewEntryHandler(&app.Service{})
+		w := httptest.NewRecorder()
+		assert.True(t, h.allowEvent(w, httptest.NewRequest("POST", "/api/v1/DE15/analytics", nil), entry, entry.Student, ""))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestEntryHandler_HandleLabEventLimits(t *testing.T) {
+	s, err := sqlite.NewSQLiteStore(":memory:", "../../migrations")
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	s.DB.SetMaxOpenConns(1)
+
+	config := &app.Config{}
+	config.API.StudentIDHeader = "X-Student"
+	config.API.LabIDHeader = "X-Lab"
+
+	post := func(limiter *stubLimiter, student string) *httptest.ResponseRecorder {
+		h := NewEntryHandler(&app.Service{Config: config, Store: s, RateLimiter: limiter})
+		r := httptest.NewRequest("POST", "/api/v1/DE15/analytics", strings.NewReader(`{"event_type": "100_lab_finish"}`))
+		r.SetPathValue("course", "DE15")
+		r.Header.Set("X-Lab", "01s")
+		if student != "" {
+			r.Header.Set("X-Student", student)
+		}
+		w := httptest.NewRecorder()
+		h.HandleLabEvent(w, r)
+		return w
+	}
+
+	t.Run("ip limit comes before auth", func(t *testing.T) {
+		limiter := &stubLimiter{err: &app.RateLimited{Scope: app.RateScopeIP, RetryAfter: time.Second}}
+		w := post(limiter, "")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, [][4]string{{"DE15", "100_lab_finish", "", "10.0.0.1"}}, limiter.calls)
+	})
+
+	t.Run("failed auth is counted by ip", func(t *testing.T) {
+		limiter := &stubLimiter{}
+		w := post(limiter, "")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, [][4]string{{"DE15", "100_lab_finish", "", "10.0.0.1"}}, limiter.calls)
+	})
+
+	t.Run("student limit after auth", func(t *testing.T) {
+		limiter := &stubLimiter{}
+		w := post(limiter, "alice.a")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, [][4]string{
+			{"DE15", "100_lab_finish", "", "10.0.0.1"},
+			{"DE15", "100_lab_finish", "alice.a", ""},
+		}, limiter.calls)
+	})
+}
+
+func TestEntryHandler_RequireHeaderOnly(t *testing.T) {
+	config := &app.Config{}
+	config.API.KeyHeader = app.DefaultAPIKeyHeader
+	config.API.RequiredHeaders = []app.HeaderConfig{{Name: "X-Course-Secret", Value: "kanelbulle"}}
+	h := NewEntryHandler(&app.Service{Config: config})
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+
+	tests := []struct {
+		name   string
+		scope  app.Scope
+		secret string
+		status int
+	}{
+		{"ingest", app.ScopeIngest, "kanelbulle", http.StatusOK},
+		{"read stats", app.ScopeReadStats, "kanelbulle", http.StatusOK},
+		{"read scores", app.ScopeReadScores, "kanelbulle", http.StatusOK},
+		{"token rotate needs a key", app.ScopeManageTokens, "kanelbulle", http.StatusForbidden},
+		{"labs need a key", app.ScopeManageLabs, "kanelbulle", http.StatusForbidden},
+		{"overrides need a key", app.ScopeManageOverrides, "kanelbulle", http.StatusForbidden},
+		{"wrong secret", app.ScopeIngest, "nope", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/v1/DE15/tokens/rotate", nil)
+			r.SetPathValue("course", "DE15")
+			r.Header.Set("X-Course-Secret", tt.secret)
+			w := httptest.NewRecorder()
+
+			h.Require(tt.scope, ok)(w, r)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+
+	t.Run("require_keys", func(t *testing.T) {
+		config.API.RequireKeys = true
+		t.Cleanup(func() { config.API.RequireKeys = false })
+		r := httptest.NewRequest("GET", "/api/v1/DE15/scoring", nil)
+		r.Header.Set("X-Course-Secret", "kanelbulle")
+		w := httptest.NewRecorder()
+
+		h.Require(app.ScopeReadScores, ok)(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/shrimpsizemoose/trekker/logger"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+)
+
+func (h *EntryHandler) HandleSnapshotCreate(w http.ResponseWriter, r *http.Request) {
+	course := r.PathValue("course")
+	if course == "" {
+		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
+		http.Error(w, "Invalid course", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Name == "" {
+		http.Error(w, "Invalid request body, snapshot name is required", http.StatusBadRequest)
+		return
+	}
+
+	// snapshots are signed by the api key that froze them
+	gradebook, err := h.service.Snapshots.Create(course, req.Name, callerName(r))
+	switch {
+	case errors.Is(err, app.ErrSnapshotInvalidName):
+		http.Error(w, "Invalid snapshot name, use letters, digits, '.', '_' and '-'", http.StatusBadRequest)
+		return
+	case errors.Is(err, app.ErrSnapshotExists):
+		http.Error(w, "Snapshot already exists", http.StatusConflict)
+		return
+	case err != nil:
+		logger.Error.Printf("Failed to create snapshot %s for course %s: %v", req.Name, course, err)
+		http.Error(w, "Failed to create snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"snapshot": gradebook,
+	}); err != nil {
+		logger.Error.Printf("Failed to encode snapshot response: %v", err)
+	}
+}
+
+func (h *EntryHandler) HandleSnapshotList(w http.ResponseWriter, r *http.Request) {
+	course := r.PathValue("course")
+	if course == "" {
+		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
+		http.Error(w, "Invalid course", http.StatusBadRequest)
+		return
+	}
+
+	snapshots, err := h.service.Snapshots.List(course)
+	if err != nil {
+		logger.Error.Printf("Failed to list snapshots for course %s: %v", course, err)
+		http.Error(w, "Failed to list snapshots", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"snapshots": snapshots,
+	}); err != nil {
+		logger.Error.Printf("Failed to encode snapshots response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *EntryHandler) HandleSnapshotGet(w http.ResponseWriter, r *http.Request) {
+	course := r.PathValue("course")
+	name := r.PathValue("name")
+	if course == "" || name == "" {
+		logger.Error.Printf("Failed to extract course or snapshot from path: %s", r.URL.Path)
+		http.Error(w, "Invalid course or snapshot", http.StatusBadRequest)
+		return
+	}
+
+	gradebook, err := h.service.Snapshots.Get(course, name)
+	switch {
+	case errors.Is(err, app.ErrSnapshotNotFound):
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	case err != nil:
+		logger.Error.Printf("Failed to get snapshot %s for course %s: %v", name, course, err)
+		http.Error(w, "Failed to get snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Query().Get("download") == "true" {
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+course+"-"+name+".json\"")
+	}
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"snapshot": gradebook,
+	}); err != nil {
+		logger.Error.Printf("Failed to encode snapshot response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *EntryHandler) HandleSnapshotCompare(w http.ResponseWriter, r *http.Request) {
+	course := r.PathValue("course")
+	if course == "" {
+		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
+		http.Error(w, "Invalid course", http.StatusBadRequest)
+		return
+	}
+
+	from := r.URL.Query().Get("from")
+	to := r.URL.Query().Get("to")
+	if from == "" || to == "" {
+		http.Error(w, "Both from and to query parameters are required", http.StatusBadRequest)
+		return
+	}
+
+	diff, err := h.service.Snapshots.Compare(course, from, to)
+	switch {
+	case errors.Is(err, app.ErrSnapshotNotFound):
+		http.Error(w, "Snapshot not found", http.StatusNotFound)
+		return
+	case err != nil:
+		logger.Error.Printf("Failed to compare snapshots %s..%s for course %s: %v", from, to, course, err)
+		http.Error(w, "Failed to compare snapshots", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"diff": diff,
+	}); err != nil {
+		logger.Error.Printf("Failed to encode snapshot diff response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/shrimpsizemoose/trekker/logger"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/app"
+)
+
+func (h *EntryHandler) HandleTeamCreate(w http.ResponseWriter, r *http.Request) {
+	course := r.PathValue("course")
+	if course == "" {
+		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
+		http.Error(w, "Invalid course", http.StatusBadRequest)
+		return
+	}
+
+	var req struct {
+		Lab     string   `json:"lab"`
+		Team    string   `json:"team"`
+		Members []string `json:"members"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Lab == "" {
+		http.Error(w, "Invalid request body, lab, team and members are required", http.StatusBadRequest)
+		return
+	}
+	if err := app.ValidateTeam(req.Team, req.Members); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.Store.CreateTeam(course, req.Lab, req.Team, req.Members); err != nil {
+		logger.Error.Printf("Failed to create team %s for %s/%s: %v", req.Team, course, req.Lab, err)
+		http.Error(w, "Failed to create team", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"course":  course,
+		"lab":     req.Lab,
+		"team":    req.Team,
+		"members": req.Members,
+	}); err != nil {
+		logger.Error.Printf("Failed to encode team response: %v", err)
+	}
+}
+
+func (h *EntryHandler) HandleTeamList(w http.ResponseWriter, r *http.Request) {
+	course := r.PathValue("course")
+	if course == "" {
+		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
+		http.Error(w, "Invalid course", http.StatusBadRequest)
+		return
+	}
+	lab := r.URL.Query().Get("lab")
+
+	members, err := h.service.Store.ListTeamMembers(course)
+	if err != nil {
+		logger.Error.Printf("Failed to list teams for course %s: %v", course, err)
+		http.Error(w, "Failed to list teams", http.StatusInternalServerError)
+		return
+	}
+
+	// lab -> team -> students
+	teams := make(map[string]map[string][]string)
+	for _, m := range members {
+		if lab != "" && m.Lab != lab {
+			continue
+		}
+		if teams[m.Lab] == nil {
+			teams[m.Lab] = make(map[string][]string)
+		}
+		teams[m.Lab][m.Team] = append(teams[m.Lab][m.Team], m.Student)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"teams": teams,
+	}); err != nil {
+		logger.Error.Printf("Failed to encode teams response: %v", err)
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (h *EntryHandler) HandleTeamDelete(w http.ResponseWriter, r *http.Request) {
+	course, lab, team := r.PathValue("course"), r.PathValue("lab"), r.PathValue("team")
+	if course == "" || lab == "" || team == "" {
+		logger.Error.Printf("Failed to extract team from path: %s", r.URL.Path)
+		http.Error(w, "Invalid team", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.Store.DeleteTeam(course, lab, team); err != nil {
+		logger.Error.Printf("Failed to delete team %s for %s/%s: %v", team, course, lab, err)
+		http.Error(w, "Failed to delete team", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/shrimpsizemoose/trekker/logger"
+)
+
+// HandleTokenRotate lets a student swap their token using the current one
+func (h *EntryHandler) HandleTokenRotate(w http.ResponseWriter, r *http.Request) {
+	if h.service.Tokens == nil {
+		http.Error(w, "Tokens are not enabled", http.StatusNotFound)
+		return
+	}
+
+	course := r.PathValue("course")
+	if course == "" {
+		logger.Error.Printf("Failed to extract course from path: %s", r.URL.Path)
+		http.Error(w, "Invalid course", http.StatusBadRequest)
+		return
+	}
+
+	student, err := h.service.AuthenticateStudent(r, course, r.Header.Get(h.service.Config.API.StudentIDHeader))
+	if err != nil {
+		logger.Error.Printf("Auth failed: %v", err)
+		http.Error(w, authErrorMessage(err), http.StatusUnauthorized)
+		return
+	}
+
+	info, err := h.service.Tokens.RotateStudentToken(r.Context(), course, student, "api")
+	if err != nil {
+		logger.Error.Printf("Failed to rotate token for %s/%s: %v", course, student, err)
+		http.Error(w, "Failed to rotate token", http.StatusInternalServerError)
+		return
+	}
+	// rotation started the usage over, so the new token begins with this request
+	h.service.RecordTokenUse(r, course, student, "", "token_rotate")
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"course":  course,
+		"student": student,
+		"token":   info.Token,
+	}); err != nil {
+		logger.Error.Printf("Failed to encode token response: %v", err)
+	}
+}
+
+func (h *EntryHandler) HandleTokenRevoke(w http.ResponseWriter, r *http.Request) {
+	if h.service.Tokens == nil {
+		http.Error(w, "Tokens are not enabled", http.StatusNotFound)
+		return
+	}
+
+	course, student := r.PathValue("course"), r.PathValue("student")
+	if course == "" || student == "" {
+		logger.Error.Printf("Failed to extract course/student from path: %s", r.URL.Path)
+		http.Error(w, "Invalid course or student", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.Tokens.RevokeStudentToken(r.Context(), course, student, "api"); err != nil {
+		logger.Error.Printf("Failed to revoke token for %s/%s: %v", course, student, err)
+		http.Error(w, "Failed to revoke token: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandleTokenInfo shows the token of a student and what it was used for, never the token itself
+func (h *EntryHandler) HandleTokenInfo(w http.ResponseWriter, r *http.Request) {
+	if h.service.Tokens == nil {
+		http.Error(w, "Tokens are not enabled", http.StatusNotFound)
+		return
+	}
+
+	course, student := r.PathValue("course"), r.PathValue("student")
+	if course == "" || student == "" {
+		logger.Error.Printf("Failed to extract course/student from path: %s", r.URL.Path)
+		http.Error(w, "Invalid course or student", http.StatusBadRequest)
+		return
+	}
+
+	info, err := h.service.Tokens.FetchStudentToken(r.Context(), course, student)
+	if err != nil {
+		logger.Error.Printf("Failed to fetch token for %s/%s: %v", course, student, err)
+		http.Error(w, "Failed to fetch token: "+err.Error(), http.StatusNotFound)
+		return
+	}
+
+	usage, err := h.service.Tokens.FetchTokenUsage(r.Context(), course, student)
+	if err != nil {
+		logger.Error.Printf("Failed to fetch token usage for %s/%s: %v", course, student, err)
+		http.Error(w, "Failed to fetch token usage", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{
+		"course":                    course,
+		"student":                   student,
+		"hint":                      info.Hint,
+		"created_dttm_utc":          info.CreatedTime,
+		"issued_dttm_utc":           info.IssuedTime,
+		"revoked":                   info.Revoked,
+		"bot_request_count":         info.RequestCount,
+		"last_bot_request_dttm_utc": info.LastRequestTime,
+		"usage":                     usage,
+	}); err != nil {
+		logger.Error.Printf("Failed to encode token response: %v", err)
+	}
+}
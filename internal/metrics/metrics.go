@@ -24,6 +24,38 @@ var (
 		[]string{"course", "lab"},
 	)
 
+	LabAnomalies = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "lab_anomalies",
+			Help: "Suspicious lab activity found by the last anomaly check",
+		},
+		[]string{"course", "kind"},
+	)
+
+	AuthFailuresTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "auth_failures_total",
+			Help: "Rejected student tokens by reason",
+		},
+		[]string{"course", "reason"},
+	)
+
+	RateLimitedTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rate_limited_total",
+			Help: "Lab events rejected by rate limits",
+		},
+		[]string{"course", "event_type", "scope"},
+	)
+
+	ChallengeFailuresTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "challenge_failures_total",
+			Help: "Finish events that didn't answer the challenge of a start",
+		},
+		[]string{"course", "reason"},
+	)
+
 	APIRequestDuration = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "api_request_duration_seconds",
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// APIKey is a named key for the admin API, only its hash is stored.
+// Empty Courses means the key works for every course.
+type APIKey struct {
+	Name      string     `json:"name"`
+	Hint      string     `json:"hint"`
+	Scopes    []string   `json:"scopes"`
+	Courses   []string   `json:"courses,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	CreatedBy string     `json:"created_by"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
@@ -0,0 +1,10 @@
+package models
+
+// ChallengeFailure is a finish accepted without echoing the nonce of a start
+type ChallengeFailure struct {
+	Course    string `db:"course" json:"course"`
+	Lab       string `db:"lab" json:"lab"`
+	Student   string `db:"student" json:"student"`
+	Timestamp int64  `db:"timestamp" json:"timestamp"`
+	Reason    string `db:"reason" json:"reason"`
+}
@@ -11,6 +11,6 @@ type ChatCourseMapping struct {
 }
 
 type StudentCourseInfo struct {
-	StudentID string `redis:"student_id"`
-	Course    string `redis:"course"`
+	StudentID string `redis:"student_id" db:"student"`
+	Course    string `redis:"course" db:"course"`
 }
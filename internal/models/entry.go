@@ -21,7 +21,7 @@ type Entry struct {
 
 type LabScore struct {
 	Deadline  int64  `db:"deadline" json:"deadline"`
-	Lab       string `db":lab" json:"lab" validate:"required,max=3"`
+	Lab       string `db:"lab" json:"lab" validate:"required,max=3"`
 	BaseScore int    `db:"base_score" json:"base_score"`
 	Course    string `db:"course"`
 }
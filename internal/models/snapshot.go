@@ -0,0 +1,12 @@
+package models
+
+// GradebookSnapshot is a frozen scoring matrix, Data holds the serialized gradebook.
+// Snapshots are never updated, (course, name) is unique on DB level.
+type GradebookSnapshot struct {
+	Course    string `db:"course" json:"course"`
+	Name      string `db:"name" json:"name"`
+	CreatedAt int64  `db:"created_at" json:"created_at"`
+	CreatedBy string `db:"created_by" json:"created_by"`
+	InputHash string `db:"input_hash" json:"input_hash"`
+	Data      string `db:"data" json:"-"`
+}
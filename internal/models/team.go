@@ -0,0 +1,10 @@
+package models
+
+// TeamMember puts a student into a team for one lab, a student can be
+// in at most one team per lab
+type TeamMember struct {
+	Course  string `db:"course" json:"course"`
+	Lab     string `db:"lab" json:"lab"`
+	Team    string `db:"team" json:"team"`
+	Student string `db:"student" json:"student"`
+}
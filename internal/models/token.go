@@ -5,8 +5,70 @@ import (
 )
 
 type TokenInfo struct {
-	Token           string    `json:"token"`
+	// Token is the plaintext, only known right after the token is issued
+	Token           string    `json:"token,omitempty"`
+	Hint            string    `json:"hint"`
 	RequestCount    int       `json:"request_count"`
 	LastRequestTime time.Time `json:"last_request_dttm_utc"`
 	CreatedTime     time.Time `json:"created_dttm_utc"`
+	// IssuedTime is the last rotation, or creation for tokens never rotated
+	IssuedTime time.Time `json:"issued_dttm_utc"`
+	Revoked    bool      `json:"revoked"`
+}
+
+const (
+	TokenRotated = "rotated"
+	TokenRevoked = "revoked"
+)
+
+// TokenEvent is published whenever a token changes outside of normal creation,
+// Actor is whoever asked for it: the student, an admin or the api
+type TokenEvent struct {
+	// ID orders events of the SQL backend, redis pub/sub doesn't need it
+	ID      int64     `json:"-"`
+	Action  string    `json:"action"`
+	Course  string    `json:"course"`
+	Student string    `json:"student"`
+	Actor   string    `json:"actor"`
+	Time    time.Time `json:"time"`
+}
+
+// StudentToken is a token row of the SQL token backend, timestamps are unix seconds
+type StudentToken struct {
+	Course        string  `db:"course"`
+	Student       string  `db:"student"`
+	TokenHash     string  `db:"token_hash"`
+	TokenSalt     string  `db:"token_salt"`
+	TokenHint     string  `db:"token_hint"`
+	RequestCount  int     `db:"request_count"`
+	CreatedAt     int64   `db:"created_at"`
+	LastRequestAt int64   `db:"last_request_at"`
+	RotatedAt     *int64  `db:"rotated_at"`
+	RevokedAt     *int64  `db:"revoked_at"`
+	RevokedBy     *string `db:"revoked_by"`
+}
+
+// TokenUse is one accepted API request made with a student token
+type TokenUse struct {
+	Lab       string
+	EventType string
+	IP        string
+	UserAgent string
+	Time      time.Time
+}
+
+// TokenUsage sums up the API requests made with the current token,
+// rotation starts it over
+type TokenUsage struct {
+	Count         int64     `json:"count"`
+	LastUsed      time.Time `json:"last_used_dttm_utc"`
+	LastIP        string    `json:"last_ip"`
+	LastUserAgent string    `json:"last_user_agent"`
+	// Events counts requests by "lab/event_type"
+	Events map[string]int64 `json:"events"`
+}
+
+// UsageKey is the TokenUsage.Events key of a request
+func (u TokenUse) UsageKey() string {
+	return u.Lab + "/" + u.EventType
 }
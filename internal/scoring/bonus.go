@@ -0,0 +1,76 @@
+package scoring
+
+import "sort"
+
+// BonusRules reward finishing early. EarlyDays maps "finished at least N full
+// days before the deadline" to a bonus, the highest matching tier wins.
+// Rank maps finish order (1 is the first student to finish the lab) to a bonus.
+// Max caps the sum of both, zero means no cap.
+type BonusRules struct {
+	EarlyDays map[int]int `toml:"early_days" json:"early_days,omitempty"`
+	Rank      map[int]int `toml:"rank" json:"rank,omitempty"`
+	Max       int         `toml:"max" json:"max"`
+}
+
+type BonusExplanation struct {
+	DaysEarly  int  `json:"days_early"`
+	EarlyBonus int  `json:"early_bonus"`
+	Rank       int  `json:"rank,omitempty"`
+	RankBonus  int  `json:"rank_bonus"`
+	Max        int  `json:"max"`
+	Capped     bool `json:"capped"`
+	Total      int  `json:"total"`
+}
+
+// bonusRules returns lab specific rules if there are any, course wide rules otherwise
+func (p Policy) bonusRules(lab string) *BonusRules {
+	if rules, ok := p.LabBonus[lab]; ok {
+		return &rules
+	}
+	return p.Bonus
+}
+
+func (r *BonusRules) needsRank() bool {
+	return r != nil && len(r.Rank) > 0
+}
+
+// apply adds the bonus on top of the calculated score, only on time finishes get one
+func (r *BonusRules) apply(e *ScoreExplanation, rank int) {
+	if r == nil || e.FinishTimestamp == nil || e.Branch != BranchOnTime {
+		return
+	}
+
+	b := &BonusExplanation{
+		DaysEarly: int((e.Deadline - *e.FinishTimestamp) / (24 * 60 * 60)),
+		Rank:      rank,
+		Max:       r.Max,
+	}
+
+	tiers := make([]int, 0, len(r.EarlyDays))
+	for days := range r.EarlyDays {
+		tiers = append(tiers, days)
+	}
+	sort.Ints(tiers)
+	for _, days := range tiers {
+		if b.DaysEarly >= days {
+			b.EarlyBonus = r.EarlyDays[days]
+		}
+	}
+
+	if rank > 0 {
+		b.RankBonus = r.Rank[rank]
+	}
+
+	b.Total = b.EarlyBonus + b.RankBonus
+	if r.Max > 0 && b.Total > r.Max {
+		b.Total = r.Max
+		b.Capped = true
+	}
+
+	if b.Total == 0 {
+		return
+	}
+
+	e.Bonus = b
+	e.CalculatedScore += b.Total
+}
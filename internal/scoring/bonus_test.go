@@ -0,0 +1,107 @@
+package scoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+func TestGrader_Bonus(t *testing.T) {
+	store := new(MockStore)
+	grader, err := NewGraderWithPolicy(store, Policy{
+		LateDaysModifiers: map[int]int{1: -1},
+		Bonus: &BonusRules{
+			EarlyDays: map[int]int{3: 1, 7: 2},
+			Rank:      map[int]int{1: 2, 2: 1},
+			Max:       3,
+		},
+		LabBonus: map[string]BonusRules{
+			"lab2": {EarlyDays: map[int]int{1: 5}},
+		},
+	})
+	require.NoError(t, err)
+
+	deadline := time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC)
+	labScore := &models.LabScore{BaseScore: 10, Deadline: deadline.Unix()}
+
+	testCases := []struct {
+		name          string
+		lab           string
+		submitTime    time.Time
+		earlier       int
+		expectedBonus *BonusExplanation
+		expectedScore int
+	}{
+		{
+			name:          "no bonus for just in time",
+			lab:           "lab1",
+			submitTime:    deadline.Add(-time.Hour),
+			earlier:       10,
+			expectedScore: 10,
+		},
+		{
+			name:          "four days early",
+			lab:           "lab1",
+			submitTime:    deadline.Add(-4 * 24 * time.Hour),
+			earlier:       10,
+			expectedBonus: &BonusExplanation{DaysEarly: 4, EarlyBonus: 1, Rank: 11, Max: 3, Total: 1},
+			expectedScore: 11,
+		},
+		{
+			name:          "second finisher",
+			lab:           "lab1",
+			submitTime:    deadline.Add(-2 * 24 * time.Hour),
+			earlier:       1,
+			expectedBonus: &BonusExplanation{DaysEarly: 2, Rank: 2, RankBonus: 1, Max: 3, Total: 1},
+			expectedScore: 11,
+		},
+		{
+			name:          "first and very early is capped",
+			lab:           "lab1",
+			submitTime:    deadline.Add(-10 * 24 * time.Hour),
+			earlier:       0,
+			expectedBonus: &BonusExplanation{DaysEarly: 10, EarlyBonus: 2, Rank: 1, RankBonus: 2, Max: 3, Capped: true, Total: 3},
+			expectedScore: 13,
+		},
+		{
+			name:          "lab specific rules replace course rules",
+			lab:           "lab2",
+			submitTime:    deadline.Add(-10 * 24 * time.Hour),
+			earlier:       -1,
+			expectedBonus: &BonusExplanation{DaysEarly: 10, EarlyBonus: 5, Total: 5},
+			expectedScore: 15,
+		},
+		{
+			name:          "late finish gets no bonus",
+			lab:           "lab1",
+			submitTime:    deadline.Add(time.Hour),
+			earlier:       -1,
+			expectedScore: 9,
+		},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			student := string(rune('a'+i)) + ".student"
+			store.On("GetScoreOverride", "course1", tc.lab, student).Return(nil, nil).Once()
+			store.On("GetLabScore", "course1", tc.lab).Return(labScore, nil).Once()
+			store.On("GetStudentFinishEvent", "course1", tc.lab, student).
+				Return(&models.Entry{Timestamp: tc.submitTime.Unix()}, nil).Once()
+			if tc.earlier >= 0 {
+				store.On("CountEarlierFinishers", "course1", tc.lab, tc.submitTime.Unix()).
+					Return(tc.earlier, nil).Once()
+			}
+
+			e, err := grader.ExplainScore("course1", tc.lab, student)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expectedBonus, e.Bonus)
+			assert.Equal(t, tc.expectedScore, e.Score)
+		})
+	}
+
+	store.AssertExpectations(t)
+}
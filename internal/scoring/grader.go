@@ -5,50 +5,156 @@ import (
 	"fmt"
 	"math"
 
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
 	"github.com/shrimpsizemoose/kanelbulle/internal/store"
 )
 
+// ScoreBranch names the rule that produced the final score
+type ScoreBranch string
+
+const (
+	BranchOnTime         ScoreBranch = "on_time"
+	BranchLateModifier   ScoreBranch = "late_days_modifier"
+	BranchDefaultPenalty ScoreBranch = "default_late_penalty"
+	BranchExtraPenalty   ScoreBranch = "extra_late_penalty"
+	BranchNotFinished    ScoreBranch = "not_finished"
+	BranchUnknownLab     ScoreBranch = "unknown_lab"
+)
+
+// ScoreExplanation is a step by step trace of how a score was calculated
+type ScoreExplanation struct {
+	Course          string                `json:"course"`
+	Lab             string                `json:"lab"`
+	Student         string                `json:"student"`
+	Strategy        string                `json:"strategy"`
+	BaseScore       int                   `json:"base_score"`
+	Deadline        int64                 `json:"deadline"`
+	FinishTimestamp *int64                `json:"finish_timestamp,omitempty"`
+	FinishedBy      string                `json:"finished_by,omitempty"`
+	LateDays        int                   `json:"late_days"`
+	Branch          ScoreBranch           `json:"branch"`
+	Modifier        *int                  `json:"modifier,omitempty"`
+	PenaltyFactor   *float64              `json:"penalty_factor,omitempty"`
+	ExtraPenalty    *int                  `json:"extra_penalty,omitempty"`
+	RawScore        int                   `json:"raw_score"`
+	Clamped         bool                  `json:"clamped"`
+	Bonus           *BonusExplanation     `json:"bonus,omitempty"`
+	CalculatedScore int                   `json:"calculated_score"`
+	Override        *models.ScoreOverride `json:"override,omitempty"`
+	OverrideApplied bool                  `json:"override_applied"`
+	Score           int                   `json:"score"`
+}
+
+// Policy selects a scoring strategy and its parameters, the step table
+// fields are kept at the top level for backwards compatible configs
+type Policy struct {
+	Strategy           string                `toml:"strategy" json:"strategy"`
+	LateDaysModifiers  map[int]int           `toml:"late_days_modifiers" json:"late_days_modifiers"`
+	DefaultLatePenalty float64               `toml:"default_late_penalty" json:"default_late_penalty"`
+	MaxLateDays        int                   `toml:"max_late_days" json:"max_late_days"`
+	ExtraLatePenalty   int                   `toml:"extra_late_penalty" json:"extra_late_penalty"`
+	Params             map[string]float64    `toml:"params" json:"params,omitempty"`
+	Bonus              *BonusRules           `toml:"bonus" json:"bonus,omitempty"`
+	LabBonus           map[string]BonusRules `toml:"lab_bonus" json:"lab_bonus,omitempty"`
+}
+
+func (p Policy) StrategyName() string {
+	if p.Strategy == "" {
+		return StrategyStepTable
+	}
+	return p.Strategy
+}
+
+type coursePolicy struct {
+	policy   Policy
+	strategy Strategy
+}
+
 type Grader struct {
-	store              store.ScoreStore
-	lateDaysModifiers  map[int]int
-	defaultLatePenalty float64
-	maxLateDays        int
-	extraLatePenalty   int
+	store    store.ScoreStore
+	defaults coursePolicy
+	courses  map[string]coursePolicy
 }
 
 func NewGrader(store store.ScoreStore, lateDaysModifiers map[int]int, defaultPenalty float64, maxLateDays, extraPenalty int) *Grader {
-	return &Grader{
-		store:              store,
-		lateDaysModifiers:  lateDaysModifiers,
-		defaultLatePenalty: defaultPenalty,
-		maxLateDays:        maxLateDays,
-		extraLatePenalty:   extraPenalty,
-	}
+	// step table never fails to build
+	grader, _ := NewGraderWithPolicy(store, Policy{
+		Strategy:           StrategyStepTable,
+		LateDaysModifiers:  lateDaysModifiers,
+		DefaultLatePenalty: defaultPenalty,
+		MaxLateDays:        maxLateDays,
+		ExtraLatePenalty:   extraPenalty,
+	})
+	return grader
 }
 
-func (g *Grader) CalculateScore(baseScore int, deadline, submitTime int64) int {
-	if submitTime <= deadline {
-		return baseScore
+func NewGraderWithPolicy(store store.ScoreStore, policy Policy) (*Grader, error) {
+	strategy, err := NewStrategy(policy)
+	if err != nil {
+		return nil, err
 	}
 
-	lateDays := int(math.Ceil(float64(submitTime-deadline) / float64(24*60*60)))
+	return &Grader{
+		store:    store,
+		defaults: coursePolicy{policy: policy, strategy: strategy},
+		courses:  make(map[string]coursePolicy),
+	}, nil
+}
 
-	clamp := func(score int) int {
-		if score < 0 {
-			return 0
-		}
-		return score
+// SetCoursePolicy makes the course use its own policy instead of the default one
+func (g *Grader) SetCoursePolicy(course string, policy Policy) error {
+	strategy, err := NewStrategy(policy)
+	if err != nil {
+		return fmt.Errorf("course %s: %w", course, err)
 	}
+	g.courses[course] = coursePolicy{policy: policy, strategy: strategy}
+	return nil
+}
 
-	if modifier, exists := g.lateDaysModifiers[lateDays]; exists {
-		return clamp(baseScore + modifier)
+func (g *Grader) coursePolicy(course string) coursePolicy {
+	if cp, ok := g.courses[course]; ok {
+		return cp
 	}
+	return g.defaults
+}
+
+// Policy returns the policy used for the course
+func (g *Grader) Policy(course string) Policy {
+	return g.coursePolicy(course).policy
+}
 
-	if lateDays <= g.maxLateDays {
-		return clamp(int(float64(baseScore) * g.defaultLatePenalty))
+// CalculateScore uses the default policy
+func (g *Grader) CalculateScore(baseScore int, deadline, submitTime int64) int {
+	explanation := &ScoreExplanation{}
+	explainCalculation(g.defaults, explanation, baseScore, deadline, submitTime)
+	return explanation.CalculatedScore
+}
+
+// explainCalculation fills in the calculation part of the explanation,
+// it is the single source of truth for the late policy
+func explainCalculation(cp coursePolicy, e *ScoreExplanation, baseScore int, deadline, submitTime int64) {
+	e.Strategy = cp.policy.StrategyName()
+	e.BaseScore = baseScore
+	e.Deadline = deadline
+	e.FinishTimestamp = &submitTime
+
+	if submitTime <= deadline {
+		e.Branch = BranchOnTime
+		e.RawScore = baseScore
+		e.CalculatedScore = baseScore
+		return
 	}
 
-	return clamp(int(float64(baseScore)*g.defaultLatePenalty) - g.extraLatePenalty)
+	lateSeconds := submitTime - deadline
+	e.LateDays = int(math.Ceil(float64(lateSeconds) / float64(24*60*60)))
+
+	cp.strategy.Late(e, baseScore, lateSeconds)
+
+	e.CalculatedScore = e.RawScore
+	if e.CalculatedScore < 0 {
+		e.CalculatedScore = 0
+		e.Clamped = true
+	}
 }
 
 func (g *Grader) ScoreForStudent(course, lab, student string) (int, error) {
@@ -77,5 +183,84 @@ func (g *Grader) ScoreForStudent(course, lab, student string) (int, error) {
 		return 0, nil
 	}
 
-	return g.CalculateScore(labScore.BaseScore, labScore.Deadline, finishEvent.Timestamp), nil
+	explanation := &ScoreExplanation{Course: course, Lab: lab, Student: student}
+	if err := g.explainFinish(explanation, labScore.BaseScore, labScore.Deadline, finishEvent.Timestamp); err != nil {
+		return 0, err
+	}
+	return explanation.CalculatedScore, nil
+}
+
+// explainFinish runs the course policy for a finished lab, including bonuses
+func (g *Grader) explainFinish(e *ScoreExplanation, baseScore int, deadline, submitTime int64) error {
+	cp := g.coursePolicy(e.Course)
+	explainCalculation(cp, e, baseScore, deadline, submitTime)
+
+	rules := cp.policy.bonusRules(e.Lab)
+	rank := 0
+	if rules.needsRank() && e.Branch == BranchOnTime {
+		earlier, err := g.store.CountEarlierFinishers(e.Course, e.Lab, submitTime)
+		if err != nil {
+			return fmt.Errorf("failed to rank finish: %w", err)
+		}
+		rank = earlier + 1
+	}
+	rules.apply(e, rank)
+
+	return nil
+}
+
+// ExplainScore mirrors ScoreForStudent but records every decision along the way.
+// Unlike ScoreForStudent it doesn't stop at the override, so the calculated score
+// is visible even when an override wins.
+func (g *Grader) ExplainScore(course, lab, student string) (*ScoreExplanation, error) {
+	explanation := &ScoreExplanation{
+		Course:  course,
+		Lab:     lab,
+		Student: student,
+	}
+
+	override, err := g.store.GetScoreOverride(course, lab, student)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check score override: %w", err)
+	}
+
+	labScore, err := g.store.GetLabScore(course, lab)
+	if err != nil {
+		return nil, err
+	}
+
+	finishEvent, err := g.store.GetStudentFinishEvent(course, lab, student)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get finish events: %w", err)
+	}
+	if finishEvent != nil && finishEvent.Student != "" && finishEvent.Student != student {
+		explanation.FinishedBy = finishEvent.Student
+	}
+
+	switch {
+	case labScore == nil:
+		explanation.Strategy = g.coursePolicy(course).policy.StrategyName()
+		explanation.Branch = BranchUnknownLab
+		if finishEvent != nil {
+			explanation.FinishTimestamp = &finishEvent.Timestamp
+		}
+	case finishEvent == nil:
+		explanation.Strategy = g.coursePolicy(course).policy.StrategyName()
+		explanation.Branch = BranchNotFinished
+		explanation.BaseScore = labScore.BaseScore
+		explanation.Deadline = labScore.Deadline
+	default:
+		if err := g.explainFinish(explanation, labScore.BaseScore, labScore.Deadline, finishEvent.Timestamp); err != nil {
+			return nil, err
+		}
+	}
+
+	explanation.Score = explanation.CalculatedScore
+	if override != nil {
+		explanation.Override = override
+		explanation.OverrideApplied = true
+		explanation.Score = override.Score
+	}
+
+	return explanation, nil
 }
@@ -36,7 +36,8 @@ func (m *MockStore) GetStudentFinishEvent(course, lab, student string) (*models.
 }
 
 func (m *MockStore) ListEntries(course string) ([]models.Entry, error) {
-	return nil, nil
+	args := m.Called(course)
+	return args.Get(0).([]models.Entry), args.Error(1)
 }
 
 func (m *MockStore) GetScoreOverride(course, lab, student string) (*models.ScoreOverride, error) {
@@ -51,8 +52,13 @@ func (m *MockStore) CreateScoreOverride(override models.ScoreOverride) error {
 	return nil
 }
 
-func (m *MockStore) ListScoreOverrides() ([]models.ScoreOverride, error) {
-	return nil, nil
+func (m *MockStore) ListCourseScoreOverrides(course string) ([]models.ScoreOverride, error) {
+	args := m.Called(course)
+	return args.Get(0).([]models.ScoreOverride), args.Error(1)
+}
+
+func (m *MockStore) CreateLabScore(labScore models.LabScore) error {
+	return nil
 }
 
 func (m *MockStore) GetLabScore(course, lab string) (*models.LabScore, error) {
@@ -63,14 +69,50 @@ func (m *MockStore) GetLabScore(course, lab string) (*models.LabScore, error) {
 	return args.Get(0).(*models.LabScore), args.Error(1)
 }
 
+func (m *MockStore) ListLabScores(course string) ([]models.LabScore, error) {
+	args := m.Called(course)
+	return args.Get(0).([]models.LabScore), args.Error(1)
+}
+
 func (m *MockStore) GetCourseEventsByType(course, eventType string) ([]models.Entry, error) {
+	args := m.Called(course, eventType)
+	return args.Get(0).([]models.Entry), args.Error(1)
+}
+
+func (m *MockStore) CountEarlierFinishers(course, lab string, timestamp int64) (int, error) {
+	args := m.Called(course, lab, timestamp)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockStore) GetDetailedStats(course, startEventType, finishEventType string) ([]store.StatResult, error) {
 	return nil, nil
 }
 
-func (m *MockStore) GetDetailedStats(course, startEventType, finishEventType string, timestampFormat string, includeHumanDttm bool) ([]store.StatResult, error) {
+func (m *MockStore) CreateGradebookSnapshot(snapshot models.GradebookSnapshot) error {
+	return nil
+}
+
+func (m *MockStore) GetGradebookSnapshot(course, name string) (*models.GradebookSnapshot, error) {
 	return nil, nil
 }
 
+func (m *MockStore) ListGradebookSnapshots(course string) ([]models.GradebookSnapshot, error) {
+	return nil, nil
+}
+
+func (m *MockStore) CreateTeam(course, lab, team string, students []string) error {
+	return nil
+}
+
+func (m *MockStore) DeleteTeam(course, lab, team string) error {
+	return nil
+}
+
+func (m *MockStore) ListTeamMembers(course string) ([]models.TeamMember, error) {
+	args := m.Called(course)
+	return args.Get(0).([]models.TeamMember), args.Error(1)
+}
+
 func TestGrader_CalculateScore(t *testing.T) {
 
 	deadline := time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC)
@@ -219,3 +261,75 @@ func TestGrader_ScoreForStudent(t *testing.T) {
 	store.AssertExpectations(t)
 
 }
+
+func TestGrader_ExplainScore(t *testing.T) {
+	store := new(MockStore)
+	grader := NewGrader(
+		store,
+		map[int]int{1: -1, 2: -2, 3: -3},
+		0.5,
+		5,
+		8,
+	)
+
+	deadline := time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC)
+	labScore := &models.LabScore{BaseScore: 10, Deadline: deadline.Unix()}
+
+	t.Run("late modifier branch", func(t *testing.T) {
+		store.On("GetScoreOverride", "course1", "lab1", "student1").Return(nil, nil).Once()
+		store.On("GetLabScore", "course1", "lab1").Return(labScore, nil).Once()
+		store.On("GetStudentFinishEvent", "course1", "lab1", "student1").
+			Return(&models.Entry{Timestamp: deadline.Add(25 * time.Hour).Unix()}, nil).Once()
+
+		e, err := grader.ExplainScore("course1", "lab1", "student1")
+		assert.NoError(t, err)
+		assert.Equal(t, BranchLateModifier, e.Branch)
+		assert.Equal(t, 2, e.LateDays)
+		assert.Equal(t, -2, *e.Modifier)
+		assert.Equal(t, 8, e.Score)
+		assert.False(t, e.OverrideApplied)
+	})
+
+	t.Run("extra penalty gets clamped", func(t *testing.T) {
+		store.On("GetScoreOverride", "course1", "lab1", "student2").Return(nil, nil).Once()
+		store.On("GetLabScore", "course1", "lab1").Return(labScore, nil).Once()
+		store.On("GetStudentFinishEvent", "course1", "lab1", "student2").
+			Return(&models.Entry{Timestamp: deadline.Add(10 * 24 * time.Hour).Unix()}, nil).Once()
+
+		e, err := grader.ExplainScore("course1", "lab1", "student2")
+		assert.NoError(t, err)
+		assert.Equal(t, BranchExtraPenalty, e.Branch)
+		assert.Equal(t, -3, e.RawScore)
+		assert.True(t, e.Clamped)
+		assert.Equal(t, 0, e.Score)
+	})
+
+	t.Run("override wins over calculated score", func(t *testing.T) {
+		store.On("GetScoreOverride", "course1", "lab1", "student3").
+			Return(&models.ScoreOverride{Score: 7, Reason: "sick leave"}, nil).Once()
+		store.On("GetLabScore", "course1", "lab1").Return(labScore, nil).Once()
+		store.On("GetStudentFinishEvent", "course1", "lab1", "student3").
+			Return(&models.Entry{Timestamp: deadline.Add(-time.Hour).Unix()}, nil).Once()
+
+		e, err := grader.ExplainScore("course1", "lab1", "student3")
+		assert.NoError(t, err)
+		assert.Equal(t, BranchOnTime, e.Branch)
+		assert.Equal(t, 10, e.CalculatedScore)
+		assert.True(t, e.OverrideApplied)
+		assert.Equal(t, 7, e.Score)
+	})
+
+	t.Run("not finished", func(t *testing.T) {
+		store.On("GetScoreOverride", "course1", "lab1", "student4").Return(nil, nil).Once()
+		store.On("GetLabScore", "course1", "lab1").Return(labScore, nil).Once()
+		store.On("GetStudentFinishEvent", "course1", "lab1", "student4").Return(nil, nil).Once()
+
+		e, err := grader.ExplainScore("course1", "lab1", "student4")
+		assert.NoError(t, err)
+		assert.Equal(t, BranchNotFinished, e.Branch)
+		assert.Nil(t, e.FinishTimestamp)
+		assert.Equal(t, 0, e.Score)
+	})
+
+	store.AssertExpectations(t)
+}
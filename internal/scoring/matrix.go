@@ -0,0 +1,154 @@
+package scoring
+
+import (
+	"fmt"
+
+	"github.com/shrimpsizemoose/trekker/logger"
+)
+
+type LabStatus string
+
+const (
+	StatusNotStarted LabStatus = "not_started"
+	StatusInProgress LabStatus = "in_progress"
+	StatusFinished   LabStatus = "finished"
+	StatusOverridden LabStatus = "overridden"
+)
+
+type LabResult struct {
+	Status LabStatus `json:"status"`
+	Score  int       `json:"score"`
+	// Late is set for finished labs when the first finish of the student or
+	// their team came after the deadline
+	Late bool `json:"late,omitempty"`
+}
+
+// Matrix is student -> lab -> result, every student has every lab
+type Matrix map[string]map[string]LabResult
+
+func (m Matrix) Scores() map[string]map[string]int {
+	scores := make(map[string]map[string]int, len(m))
+	for student, labs := range m {
+		scores[student] = make(map[string]int, len(labs))
+		for lab, result := range labs {
+			scores[student][lab] = result.Score
+		}
+	}
+	return scores
+}
+
+func (m Matrix) Statuses() map[string]map[string]LabStatus {
+	statuses := make(map[string]map[string]LabStatus, len(m))
+	for student, labs := range m {
+		statuses[student] = make(map[string]LabStatus, len(labs))
+		for lab, result := range labs {
+			statuses[student][lab] = result.Status
+		}
+	}
+	return statuses
+}
+
+// CourseMatrix builds the full roster x labs matrix. Students come from the roster
+// plus anyone who sent events or got an override, labs are the registered ones
+// plus anything somebody finished. Team activity counts for every member.
+func (g *Grader) CourseMatrix(course string, roster []string) (Matrix, error) {
+	labScores, err := g.store.ListLabScores(course)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list lab scores: %w", err)
+	}
+
+	entries, err := g.store.ListEntries(course)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get entries: %w", err)
+	}
+
+	overrides, err := g.store.ListCourseScoreOverrides(course)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list overrides: %w", err)
+	}
+
+	teams, err := g.loadTeams(course)
+	if err != nil {
+		return nil, err
+	}
+
+	students := make(map[string]bool)
+	labs := make(map[string]bool)
+	deadlines := make(map[string]int64, len(labScores))
+	for _, student := range roster {
+		students[student] = true
+	}
+	for _, ls := range labScores {
+		labs[ls.Lab] = true
+		deadlines[ls.Lab] = ls.Deadline
+	}
+
+	status := make(map[string]map[string]LabStatus)
+	mark := func(student, lab string, s LabStatus) {
+		students[student] = true
+		if status[student] == nil {
+			status[student] = make(map[string]LabStatus)
+		}
+		status[student][lab] = s
+	}
+
+	// the earliest finish of the student or their team
+	finishedAt := make(map[string]map[string]int64)
+	for _, e := range entries {
+		if e.EventType == "100_lab_finish" {
+			labs[e.Lab] = true
+		}
+		for _, student := range teams.mates(e.Lab, e.Student) {
+			if e.EventType == "100_lab_finish" {
+				mark(student, e.Lab, StatusFinished)
+				if finishedAt[student] == nil {
+					finishedAt[student] = make(map[string]int64)
+				}
+				if at, ok := finishedAt[student][e.Lab]; !ok || e.Timestamp < at {
+					finishedAt[student][e.Lab] = e.Timestamp
+				}
+			} else if status[student][e.Lab] == "" {
+				mark(student, e.Lab, StatusInProgress)
+			}
+		}
+	}
+	overrideScores := make(map[string]map[string]int)
+	for _, o := range overrides {
+		labs[o.Lab] = true
+		mark(o.Student, o.Lab, StatusOverridden)
+		if overrideScores[o.Student] == nil {
+			overrideScores[o.Student] = make(map[string]int)
+		}
+		overrideScores[o.Student][o.Lab] = o.Score
+	}
+
+	matrix := make(Matrix, len(students))
+	for student := range students {
+		matrix[student] = make(map[string]LabResult, len(labs))
+		for lab := range labs {
+			result := LabResult{Status: status[student][lab]}
+			switch result.Status {
+			case "":
+				result.Status = StatusNotStarted
+			case StatusOverridden:
+				result.Score = overrideScores[student][lab]
+			case StatusFinished:
+				score, err := g.ScoreForStudent(course, lab, student)
+				if err != nil {
+					logger.Error.Printf("failed to calculate score for student %s lab %s: %v",
+						student,
+						lab,
+						err,
+					)
+				}
+				result.Score = score
+				if deadline, ok := deadlines[lab]; ok {
+					result.Late = finishedAt[student][lab] > deadline
+				}
+			}
+			matrix[student][lab] = result
+		}
+	}
+
+	return matrix, nil
+}
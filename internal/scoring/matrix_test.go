@@ -0,0 +1,93 @@
+package scoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+func TestGrader_CourseMatrix(t *testing.T) {
+	store := new(MockStore)
+	grader := NewGrader(store, map[int]int{1: -1}, 0.5, 7, 1)
+
+	deadline := time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC)
+	labScore := &models.LabScore{Lab: "l1", Course: "course1", BaseScore: 10, Deadline: deadline.Unix()}
+
+	store.On("ListLabScores", "course1").Return([]models.LabScore{
+		*labScore,
+		{Lab: "l2", Course: "course1", BaseScore: 10, Deadline: deadline.Unix()},
+	}, nil)
+	store.On("ListEntries", "course1").Return([]models.Entry{
+		{Student: "alice.a", Lab: "l1", EventType: "000_lab_start", Timestamp: deadline.Add(-2 * time.Hour).Unix()},
+		{Student: "alice.a", Lab: "l1", EventType: "100_lab_finish", Timestamp: deadline.Add(-time.Hour).Unix()},
+		{Student: "alice.a", Lab: "l2", EventType: "000_lab_start", Timestamp: deadline.Add(-time.Hour).Unix()},
+		{Student: "dave.d", Lab: "l1", EventType: "000_lab_start", Timestamp: deadline.Add(-time.Hour).Unix()},
+		{Student: "gus.g", Lab: "l2", EventType: "100_lab_finish", Timestamp: deadline.Add(30 * time.Hour).Unix()},
+		{Student: "fay.f", Lab: "l2", EventType: "100_lab_finish", Timestamp: deadline.Add(26 * time.Hour).Unix()},
+	}, nil)
+	store.On("ListCourseScoreOverrides", "course1").Return([]models.ScoreOverride{
+		{Student: "bob.b", Lab: "l2", Course: "course1", Score: 7},
+	}, nil)
+	store.On("ListTeamMembers", "course1").Return([]models.TeamMember{
+		{Course: "course1", Lab: "l1", Team: "t1", Student: "alice.a"},
+		{Course: "course1", Lab: "l1", Team: "t1", Student: "erin.e"},
+		{Course: "course1", Lab: "l2", Team: "t2", Student: "fay.f"},
+		{Course: "course1", Lab: "l2", Team: "t2", Student: "gus.g"},
+	}, nil)
+
+	finish := &models.Entry{Student: "alice.a", Timestamp: deadline.Add(-time.Hour).Unix()}
+	store.On("GetScoreOverride", "course1", "l1", "alice.a").Return(nil, nil).Once()
+	store.On("GetStudentFinishEvent", "course1", "l1", "alice.a").Return(finish, nil).Once()
+	store.On("GetScoreOverride", "course1", "l1", "erin.e").Return(nil, nil).Once()
+	store.On("GetStudentFinishEvent", "course1", "l1", "erin.e").Return(finish, nil).Once()
+	store.On("GetLabScore", "course1", "l1").Return(labScore, nil).Twice()
+
+	lateFinish := &models.Entry{Student: "fay.f", Timestamp: deadline.Add(26 * time.Hour).Unix()}
+	for _, student := range []string{"fay.f", "gus.g"} {
+		store.On("GetScoreOverride", "course1", "l2", student).Return(nil, nil).Once()
+		store.On("GetStudentFinishEvent", "course1", "l2", student).Return(lateFinish, nil).Once()
+	}
+	store.On("GetLabScore", "course1", "l2").Return(&models.LabScore{Lab: "l2", Course: "course1", BaseScore: 10, Deadline: deadline.Unix()}, nil).Twice()
+
+	matrix, err := grader.CourseMatrix("course1", []string{"alice.a", "bob.b", "carol.c"})
+	require.NoError(t, err)
+
+	assert.Equal(t, Matrix{
+		"alice.a": {
+			"l1": {Status: StatusFinished, Score: 10},
+			"l2": {Status: StatusInProgress},
+		},
+		"bob.b": {
+			"l1": {Status: StatusNotStarted},
+			"l2": {Status: StatusOverridden, Score: 7},
+		},
+		"carol.c": {
+			"l1": {Status: StatusNotStarted},
+			"l2": {Status: StatusNotStarted},
+		},
+		"dave.d": {
+			"l1": {Status: StatusInProgress},
+			"l2": {Status: StatusNotStarted},
+		},
+		"erin.e": {
+			"l1": {Status: StatusFinished, Score: 10},
+			"l2": {Status: StatusNotStarted},
+		},
+		"fay.f": {
+			"l1": {Status: StatusNotStarted},
+			"l2": {Status: StatusFinished, Score: 5, Late: true},
+		},
+		// late through the earlier finish of fay.f
+		"gus.g": {
+			"l1": {Status: StatusNotStarted},
+			"l2": {Status: StatusFinished, Score: 5, Late: true},
+		},
+	}, matrix)
+
+	assert.Equal(t, 0, matrix.Scores()["carol.c"]["l1"])
+	store.AssertExpectations(t)
+}
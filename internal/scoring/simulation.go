@@ -0,0 +1,232 @@
+package scoring
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+// Scenario is a proposed grading change, unset fields keep current values.
+// Params and LateDaysModifiers are merged into the current ones key by key.
+type Scenario struct {
+	Strategy           *string            `json:"strategy,omitempty"`
+	Params             map[string]float64 `json:"params,omitempty"`
+	LateDaysModifiers  map[int]int        `json:"late_days_modifiers,omitempty"`
+	DefaultLatePenalty *float64           `json:"default_late_penalty,omitempty"`
+	MaxLateDays        *int               `json:"max_late_days,omitempty"`
+	ExtraLatePenalty   *int               `json:"extra_late_penalty,omitempty"`
+	Deadlines          map[string]int64   `json:"deadlines,omitempty"`
+}
+
+type LabDiff struct {
+	Lab    string `json:"lab"`
+	Before int    `json:"before"`
+	After  int    `json:"after"`
+}
+
+type StudentDiff struct {
+	Student     string    `json:"student"`
+	BeforeTotal int       `json:"before_total"`
+	AfterTotal  int       `json:"after_total"`
+	Labs        []LabDiff `json:"labs"`
+}
+
+type SimulationResult struct {
+	Course     string           `json:"course"`
+	Policy     Policy           `json:"policy"`
+	Deadlines  map[string]int64 `json:"deadlines"`
+	Affected   []StudentDiff    `json:"affected"`
+	Unaffected int              `json:"unaffected"`
+}
+
+func (p Policy) withScenario(s Scenario) Policy {
+	if s.Strategy != nil {
+		p.Strategy = *s.Strategy
+	}
+	if s.Params != nil {
+		params := maps.Clone(p.Params)
+		if params == nil {
+			params = make(map[string]float64, len(s.Params))
+		}
+		maps.Copy(params, s.Params)
+		p.Params = params
+	}
+	if s.LateDaysModifiers != nil {
+		modifiers := maps.Clone(p.LateDaysModifiers)
+		if modifiers == nil {
+			modifiers = make(map[int]int, len(s.LateDaysModifiers))
+		}
+		maps.Copy(modifiers, s.LateDaysModifiers)
+		p.LateDaysModifiers = modifiers
+	}
+	if s.DefaultLatePenalty != nil {
+		p.DefaultLatePenalty = *s.DefaultLatePenalty
+	}
+	if s.MaxLateDays != nil {
+		p.MaxLateDays = *s.MaxLateDays
+	}
+	if s.ExtraLatePenalty != nil {
+		p.ExtraLatePenalty = *s.ExtraLatePenalty
+	}
+	return p
+}
+
+// Simulate recomputes the whole course under the scenario without persisting anything
+// and reports students whose scores would change. Overrides still win in both runs.
+func (g *Grader) Simulate(course string, scenario Scenario) (*SimulationResult, error) {
+	currentPolicy := g.coursePolicy(course)
+	simPolicy := currentPolicy.policy.withScenario(scenario)
+	simStrategy, err := NewStrategy(simPolicy)
+	if err != nil {
+		return nil, err
+	}
+	sim := coursePolicy{policy: simPolicy, strategy: simStrategy}
+
+	labScores, err := g.store.ListLabScores(course)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list lab scores: %w", err)
+	}
+
+	current := make(map[string]models.LabScore, len(labScores))
+	simulated := make(map[string]models.LabScore, len(labScores))
+	deadlines := make(map[string]int64, len(labScores))
+	for _, ls := range labScores {
+		current[ls.Lab] = ls
+		if deadline, ok := scenario.Deadlines[ls.Lab]; ok {
+			ls.Deadline = deadline
+		}
+		simulated[ls.Lab] = ls
+		deadlines[ls.Lab] = ls.Deadline
+	}
+	for lab := range scenario.Deadlines {
+		if _, ok := current[lab]; !ok {
+			return nil, fmt.Errorf("lab %s is not registered for course %s", lab, course)
+		}
+	}
+
+	overrides, err := g.store.ListCourseScoreOverrides(course)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list overrides: %w", err)
+	}
+	overridden := make(map[string]map[string]int)
+	for _, o := range overrides {
+		if overridden[o.Student] == nil {
+			overridden[o.Student] = make(map[string]int)
+		}
+		overridden[o.Student][o.Lab] = o.Score
+	}
+
+	teams, err := g.loadTeams(course)
+	if err != nil {
+		return nil, err
+	}
+
+	finishEvents, err := g.store.GetCourseEventsByType(course, "100_lab_finish")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get finish events: %w", err)
+	}
+
+	result := &SimulationResult{
+		Course:    course,
+		Policy:    simPolicy,
+		Deadlines: deadlines,
+	}
+
+	// events are ordered by timestamp within a student, only the first finish counts
+	var firstFinishes []models.Entry
+	seen := make(map[string]bool)
+	for _, e := range finishEvents {
+		key := e.Student + "/" + e.Lab
+		if !seen[key] {
+			seen[key] = true
+			firstFinishes = append(firstFinishes, e)
+		}
+	}
+
+	ranks := make(map[string]int, len(firstFinishes))
+	for _, e := range firstFinishes {
+		rank := 1
+		for _, other := range firstFinishes {
+			if other.Lab == e.Lab && other.Timestamp < e.Timestamp {
+				rank++
+			}
+		}
+		ranks[e.Student+"/"+e.Lab] = rank
+	}
+
+	// the first finish of a team credits every member with the same rank, a
+	// member's own later finish is ignored just like in live grading. Ranks
+	// above only count students who actually finished. Events come sorted by
+	// student, so walk them by time to find who in the team was first.
+	byTime := slices.Clone(firstFinishes)
+	sort.SliceStable(byTime, func(i, j int) bool { return byTime[i].Timestamp < byTime[j].Timestamp })
+	var credited []models.Entry
+	creditedKeys := make(map[string]bool)
+	for _, e := range byTime {
+		for _, student := range teams.mates(e.Lab, e.Student) {
+			key := student + "/" + e.Lab
+			if creditedKeys[key] {
+				continue
+			}
+			creditedKeys[key] = true
+			ranks[key] = ranks[e.Student+"/"+e.Lab]
+			mate := e
+			mate.Student = student
+			credited = append(credited, mate)
+		}
+	}
+
+	score := func(cp coursePolicy, labs map[string]models.LabScore, e models.Entry) int {
+		if o, ok := overridden[e.Student][e.Lab]; ok {
+			return o
+		}
+		ls, ok := labs[e.Lab]
+		if !ok {
+			return 0
+		}
+		explanation := &ScoreExplanation{Course: course, Lab: e.Lab, Student: e.Student}
+		explainCalculation(cp, explanation, ls.BaseScore, ls.Deadline, e.Timestamp)
+		cp.policy.bonusRules(e.Lab).apply(explanation, ranks[e.Student+"/"+e.Lab])
+		return explanation.CalculatedScore
+	}
+
+	sort.SliceStable(credited, func(i, j int) bool {
+		if credited[i].Student != credited[j].Student {
+			return credited[i].Student < credited[j].Student
+		}
+		return credited[i].Lab < credited[j].Lab
+	})
+
+	diffs := make(map[string]*StudentDiff)
+	var students []string
+	for _, e := range credited {
+		diff, ok := diffs[e.Student]
+		if !ok {
+			diff = &StudentDiff{Student: e.Student}
+			diffs[e.Student] = diff
+			students = append(students, e.Student)
+		}
+
+		before := score(currentPolicy, current, e)
+		after := score(sim, simulated, e)
+		diff.BeforeTotal += before
+		diff.AfterTotal += after
+		if before != after {
+			diff.Labs = append(diff.Labs, LabDiff{Lab: e.Lab, Before: before, After: after})
+		}
+	}
+
+	sort.Strings(students)
+	for _, student := range students {
+		if len(diffs[student].Labs) == 0 {
+			result.Unaffected++
+			continue
+		}
+		result.Affected = append(result.Affected, *diffs[student])
+	}
+
+	return result, nil
+}
@@ -0,0 +1,169 @@
+package scoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+func TestGrader_Simulate(t *testing.T) {
+	store := new(MockStore)
+	grader := NewGrader(
+		store,
+		map[int]int{1: -1, 2: -2, 3: -3},
+		0.5,
+		7,
+		1,
+	)
+
+	deadline := time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC)
+
+	store.On("ListLabScores", "course1").Return([]models.LabScore{
+		{Lab: "l1", Course: "course1", BaseScore: 10, Deadline: deadline.Unix()},
+		{Lab: "l2", Course: "course1", BaseScore: 10, Deadline: deadline.Unix()},
+	}, nil)
+	store.On("ListCourseScoreOverrides", "course1").Return([]models.ScoreOverride{
+		{Student: "carol.c", Lab: "l1", Course: "course1", Score: 3},
+	}, nil)
+	store.On("ListTeamMembers", "course1").Return([]models.TeamMember{
+		{Course: "course1", Lab: "l1", Team: "t1", Student: "bob.b"},
+		{Course: "course1", Lab: "l1", Team: "t1", Student: "erin.e"},
+	}, nil)
+	store.On("GetCourseEventsByType", "course1", "100_lab_finish").Return([]models.Entry{
+		{Student: "alice.a", Lab: "l1", Timestamp: deadline.Add(-time.Hour).Unix()},
+		{Student: "alice.a", Lab: "l2", Timestamp: deadline.Add(-time.Hour).Unix()},
+		{Student: "bob.b", Lab: "l1", Timestamp: deadline.Add(30 * time.Hour).Unix()},
+		{Student: "bob.b", Lab: "l1", Timestamp: deadline.Add(90 * time.Hour).Unix()},
+		{Student: "carol.c", Lab: "l1", Timestamp: deadline.Add(30 * time.Hour).Unix()},
+	}, nil)
+
+	t.Run("no changes", func(t *testing.T) {
+		result, err := grader.Simulate("course1", Scenario{})
+		require.NoError(t, err)
+		assert.Empty(t, result.Affected)
+		assert.Equal(t, 4, result.Unaffected)
+	})
+
+	t.Run("deadline moved", func(t *testing.T) {
+		result, err := grader.Simulate("course1", Scenario{
+			Deadlines: map[string]int64{"l1": deadline.Add(48 * time.Hour).Unix()},
+		})
+		require.NoError(t, err)
+		require.Len(t, result.Affected, 2)
+		assert.Equal(t, "bob.b", result.Affected[0].Student)
+		assert.Equal(t, 8, result.Affected[0].BeforeTotal)
+		assert.Equal(t, 10, result.Affected[0].AfterTotal)
+		assert.Equal(t, []LabDiff{{Lab: "l1", Before: 8, After: 10}}, result.Affected[0].Labs)
+		assert.Equal(t, "erin.e", result.Affected[1].Student, "teammate shares the credit")
+		assert.Equal(t, result.Affected[0].Labs, result.Affected[1].Labs)
+	})
+
+	t.Run("policy changed, override still wins", func(t *testing.T) {
+		modifiers := map[int]int{1: -5, 2: -5}
+		result, err := grader.Simulate("course1", Scenario{LateDaysModifiers: modifiers})
+		require.NoError(t, err)
+		require.Len(t, result.Affected, 2)
+		assert.Equal(t, "bob.b", result.Affected[0].Student)
+		assert.Equal(t, 5, result.Affected[0].AfterTotal)
+		assert.Equal(t, map[int]int{1: -5, 2: -5, 3: -3}, result.Policy.LateDaysModifiers, "merged into the course policy")
+		assert.Equal(t, map[int]int{1: -1, 2: -2, 3: -3}, grader.Policy("course1").LateDaysModifiers)
+	})
+
+	t.Run("teammate finished late on their own", func(t *testing.T) {
+		store := new(MockStore)
+		grader := NewGrader(store, map[int]int{1: -1, 2: -2, 3: -3}, 0.5, 7, 1)
+		store.On("ListLabScores", "course2").Return([]models.LabScore{
+			{Lab: "l1", Course: "course2", BaseScore: 10, Deadline: deadline.Unix()},
+		}, nil)
+		store.On("ListCourseScoreOverrides", "course2").Return([]models.ScoreOverride{}, nil)
+		store.On("ListTeamMembers", "course2").Return([]models.TeamMember{
+			{Course: "course2", Lab: "l1", Team: "t1", Student: "alice.a"},
+			{Course: "course2", Lab: "l1", Team: "t1", Student: "bob.b"},
+		}, nil)
+		store.On("GetCourseEventsByType", "course2", "100_lab_finish").Return([]models.Entry{
+			{Student: "alice.a", Lab: "l1", Timestamp: deadline.Add(-time.Hour).Unix()},
+			{Student: "bob.b", Lab: "l1", Timestamp: deadline.Add(30 * time.Hour).Unix()},
+		}, nil)
+
+		// bob.b is credited with the on-time team finish, not with their own late one
+		result, err := grader.Simulate("course2", Scenario{
+			Deadlines: map[string]int64{"l1": deadline.Add(48 * time.Hour).Unix()},
+		})
+		require.NoError(t, err)
+		assert.Empty(t, result.Affected)
+		assert.Equal(t, 2, result.Unaffected)
+
+		result, err = grader.Simulate("course2", Scenario{
+			Deadlines: map[string]int64{"l1": deadline.Add(-2 * time.Hour).Unix()},
+		})
+		require.NoError(t, err)
+		require.Len(t, result.Affected, 2)
+		for _, diff := range result.Affected {
+			assert.Equal(t, []LabDiff{{Lab: "l1", Before: 10, After: 9}}, diff.Labs, diff.Student)
+		}
+	})
+
+	t.Run("later named teammate finished first", func(t *testing.T) {
+		store := new(MockStore)
+		grader := NewGrader(store, map[int]int{1: -1, 2: -2, 3: -3}, 0.5, 7, 1)
+		store.On("ListLabScores", "course3").Return([]models.LabScore{
+			{Lab: "l1", Course: "course3", BaseScore: 10, Deadline: deadline.Unix()},
+		}, nil)
+		store.On("ListCourseScoreOverrides", "course3").Return([]models.ScoreOverride{}, nil)
+		store.On("ListTeamMembers", "course3").Return([]models.TeamMember{
+			{Course: "course3", Lab: "l1", Team: "t1", Student: "alice.a"},
+			{Course: "course3", Lab: "l1", Team: "t1", Student: "bob.b"},
+		}, nil)
+		// ordered by student like the store returns them, bob.b finished first
+		store.On("GetCourseEventsByType", "course3", "100_lab_finish").Return([]models.Entry{
+			{Student: "alice.a", Lab: "l1", Timestamp: deadline.Add(30 * time.Hour).Unix()},
+			{Student: "bob.b", Lab: "l1", Timestamp: deadline.Add(-time.Hour).Unix()},
+		}, nil)
+
+		result, err := grader.Simulate("course3", Scenario{
+			Deadlines: map[string]int64{"l1": deadline.Add(-2 * time.Hour).Unix()},
+		})
+		require.NoError(t, err)
+		require.Len(t, result.Affected, 2)
+		for _, diff := range result.Affected {
+			assert.Equal(t, []LabDiff{{Lab: "l1", Before: 10, After: 9}}, diff.Labs, diff.Student)
+		}
+	})
+
+	t.Run("unknown lab", func(t *testing.T) {
+		_, err := grader.Simulate("course1", Scenario{Deadlines: map[string]int64{"l9": 0}})
+		assert.Error(t, err)
+	})
+}
+
+func TestPolicy_WithScenario(t *testing.T) {
+	policy := Policy{
+		Strategy:           StrategyStepTable,
+		Params:             map[string]float64{"rate": 0.1, "floor": 0.5},
+		LateDaysModifiers:  map[int]int{1: -1, 2: -2, 3: -3},
+		DefaultLatePenalty: 0.5,
+		MaxLateDays:        7,
+	}
+
+	penalty := 0.25
+	merged := policy.withScenario(Scenario{
+		Params:             map[string]float64{"rate": 0.2},
+		LateDaysModifiers:  map[int]int{2: -5, 4: -4},
+		DefaultLatePenalty: &penalty,
+	})
+	assert.Equal(t, map[string]float64{"rate": 0.2, "floor": 0.5}, merged.Params)
+	assert.Equal(t, map[int]int{1: -1, 2: -5, 3: -3, 4: -4}, merged.LateDaysModifiers)
+	assert.Equal(t, 0.25, merged.DefaultLatePenalty)
+	assert.Equal(t, 7, merged.MaxLateDays)
+
+	// the course policy itself is left alone
+	assert.Equal(t, map[int]int{1: -1, 2: -2, 3: -3}, policy.LateDaysModifiers)
+	assert.Equal(t, 0.1, policy.Params["rate"])
+
+	assert.Equal(t, map[int]int{1: -9}, Policy{}.withScenario(Scenario{LateDaysModifiers: map[int]int{1: -9}}).LateDaysModifiers)
+	assert.Equal(t, policy, policy.withScenario(Scenario{}))
+}
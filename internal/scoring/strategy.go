@@ -0,0 +1,190 @@
+package scoring
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+const (
+	StrategyStepTable        = "step_table"
+	StrategyLinearDecay      = "linear_decay"
+	StrategyExponentialDecay = "exponential_decay"
+	StrategyPassFail         = "pass_fail"
+	StrategyNoLate           = "no_late"
+)
+
+const (
+	BranchLinearDecay      ScoreBranch = "linear_decay"
+	BranchExponentialDecay ScoreBranch = "exponential_decay"
+	BranchPassed           ScoreBranch = "passed"
+	BranchFailed           ScoreBranch = "failed"
+	BranchLateRejected     ScoreBranch = "late_rejected"
+)
+
+// Strategy decides what a late finish is worth. On time finishes always get
+// the base score and clamping to zero is done by the Grader, so strategies
+// only need to fill Branch, RawScore and whatever parameters they used.
+type Strategy interface {
+	Late(e *ScoreExplanation, baseScore int, lateSeconds int64)
+}
+
+type StrategyFactory func(p Policy) (Strategy, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]StrategyFactory{
+		StrategyStepTable:        newStepTable,
+		StrategyLinearDecay:      newLinearDecay,
+		StrategyExponentialDecay: newExponentialDecay,
+		StrategyPassFail:         newPassFail,
+		StrategyNoLate:           newNoLate,
+	}
+)
+
+// RegisterStrategy makes a strategy selectable by name in the config
+func RegisterStrategy(name string, factory StrategyFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = factory
+}
+
+func Strategies() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewStrategy builds the strategy named in the policy, step table if none is set
+func NewStrategy(p Policy) (Strategy, error) {
+	name := p.StrategyName()
+
+	registryMu.RLock()
+	factory, ok := registry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown scoring strategy %q, available: %v", name, Strategies())
+	}
+
+	return factory(p)
+}
+
+func (p Policy) param(name string, fallback float64) float64 {
+	if v, ok := p.Params[name]; ok {
+		return v
+	}
+	return fallback
+}
+
+// stepTable is the original policy: fixed modifiers for the first days,
+// then a multiplier, then a multiplier with an extra penalty
+type stepTable struct {
+	policy Policy
+}
+
+func newStepTable(p Policy) (Strategy, error) {
+	return &stepTable{policy: p}, nil
+}
+
+func (s *stepTable) Late(e *ScoreExplanation, baseScore int, lateSeconds int64) {
+	penalty, extra := s.policy.DefaultLatePenalty, s.policy.ExtraLatePenalty
+
+	if modifier, exists := s.policy.LateDaysModifiers[e.LateDays]; exists {
+		e.Branch = BranchLateModifier
+		e.Modifier = &modifier
+		e.RawScore = baseScore + modifier
+	} else if e.LateDays <= s.policy.MaxLateDays {
+		e.Branch = BranchDefaultPenalty
+		e.PenaltyFactor = &penalty
+		e.RawScore = int(float64(baseScore) * penalty)
+	} else {
+		e.Branch = BranchExtraPenalty
+		e.PenaltyFactor = &penalty
+		e.ExtraPenalty = &extra
+		e.RawScore = int(float64(baseScore)*penalty) - extra
+	}
+}
+
+// linearDecay loses per_hour of the base score for every started hour, down to floor
+type linearDecay struct {
+	perHour float64
+	floor   float64
+}
+
+func newLinearDecay(p Policy) (Strategy, error) {
+	s := &linearDecay{perHour: p.param("per_hour", 0.01), floor: p.param("floor", 0)}
+	if s.perHour < 0 || s.floor < 0 || s.floor > 1 {
+		return nil, fmt.Errorf("%s: per_hour must be positive and floor within [0, 1]", StrategyLinearDecay)
+	}
+	return s, nil
+}
+
+func (s *linearDecay) Late(e *ScoreExplanation, baseScore int, lateSeconds int64) {
+	hours := math.Ceil(float64(lateSeconds) / 3600)
+	factor := math.Max(s.floor, 1-s.perHour*hours)
+
+	e.Branch = BranchLinearDecay
+	e.PenaltyFactor = &factor
+	e.RawScore = int(float64(baseScore) * factor)
+}
+
+// exponentialDecay halves the score every half_life_hours, down to floor
+type exponentialDecay struct {
+	halfLife float64
+	floor    float64
+}
+
+func newExponentialDecay(p Policy) (Strategy, error) {
+	s := &exponentialDecay{halfLife: p.param("half_life_hours", 72), floor: p.param("floor", 0)}
+	if s.halfLife <= 0 || s.floor < 0 || s.floor > 1 {
+		return nil, fmt.Errorf("%s: half_life_hours must be positive and floor within [0, 1]", StrategyExponentialDecay)
+	}
+	return s, nil
+}
+
+func (s *exponentialDecay) Late(e *ScoreExplanation, baseScore int, lateSeconds int64) {
+	hours := float64(lateSeconds) / 3600
+	factor := math.Max(s.floor, math.Pow(0.5, hours/s.halfLife))
+
+	e.Branch = BranchExponentialDecay
+	e.PenaltyFactor = &factor
+	e.RawScore = int(float64(baseScore) * factor)
+}
+
+// passFail gives the full score to anyone who finished within max_late_days,
+// zero max_late_days means any finish passes
+type passFail struct {
+	maxLateDays int
+}
+
+func newPassFail(p Policy) (Strategy, error) {
+	return &passFail{maxLateDays: p.MaxLateDays}, nil
+}
+
+func (s *passFail) Late(e *ScoreExplanation, baseScore int, lateSeconds int64) {
+	if s.maxLateDays == 0 || e.LateDays <= s.maxLateDays {
+		e.Branch = BranchPassed
+		e.RawScore = baseScore
+		return
+	}
+	e.Branch = BranchFailed
+	e.RawScore = 0
+}
+
+type noLate struct{}
+
+func newNoLate(p Policy) (Strategy, error) {
+	return noLate{}, nil
+}
+
+func (noLate) Late(e *ScoreExplanation, baseScore int, lateSeconds int64) {
+	e.Branch = BranchLateRejected
+	e.RawScore = 0
+}
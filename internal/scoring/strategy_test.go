@@ -0,0 +1,117 @@
+package scoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+func TestStrategies(t *testing.T) {
+	deadline := time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		policy        Policy
+		submitTime    time.Time
+		expectedScore int
+	}{
+		{
+			name:          "linear decay: 10 hours late",
+			policy:        Policy{Strategy: StrategyLinearDecay, Params: map[string]float64{"per_hour": 0.02}},
+			submitTime:    deadline.Add(10 * time.Hour),
+			expectedScore: 80,
+		},
+		{
+			name:          "linear decay: started hour counts as a full one",
+			policy:        Policy{Strategy: StrategyLinearDecay, Params: map[string]float64{"per_hour": 0.02}},
+			submitTime:    deadline.Add(1 * time.Second),
+			expectedScore: 98,
+		},
+		{
+			name:          "linear decay: never below floor",
+			policy:        Policy{Strategy: StrategyLinearDecay, Params: map[string]float64{"per_hour": 0.02, "floor": 0.3}},
+			submitTime:    deadline.Add(100 * time.Hour),
+			expectedScore: 30,
+		},
+		{
+			name:          "exponential decay: one half life",
+			policy:        Policy{Strategy: StrategyExponentialDecay, Params: map[string]float64{"half_life_hours": 24}},
+			submitTime:    deadline.Add(24 * time.Hour),
+			expectedScore: 50,
+		},
+		{
+			name:          "exponential decay: two half lives",
+			policy:        Policy{Strategy: StrategyExponentialDecay, Params: map[string]float64{"half_life_hours": 24}},
+			submitTime:    deadline.Add(48 * time.Hour),
+			expectedScore: 25,
+		},
+		{
+			name:          "pass/fail: late but within max late days",
+			policy:        Policy{Strategy: StrategyPassFail, MaxLateDays: 3},
+			submitTime:    deadline.Add(49 * time.Hour),
+			expectedScore: 100,
+		},
+		{
+			name:          "pass/fail: too late",
+			policy:        Policy{Strategy: StrategyPassFail, MaxLateDays: 3},
+			submitTime:    deadline.Add(73 * time.Hour),
+			expectedScore: 0,
+		},
+		{
+			name:          "no late: on time",
+			policy:        Policy{Strategy: StrategyNoLate},
+			submitTime:    deadline,
+			expectedScore: 100,
+		},
+		{
+			name:          "no late: one second late",
+			policy:        Policy{Strategy: StrategyNoLate},
+			submitTime:    deadline.Add(time.Second),
+			expectedScore: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			grader, err := NewGraderWithPolicy(&MockStore{}, tc.policy)
+			require.NoError(t, err)
+			score := grader.CalculateScore(100, deadline.Unix(), tc.submitTime.Unix())
+			assert.Equal(t, tc.expectedScore, score)
+		})
+	}
+}
+
+func TestNewStrategy_Unknown(t *testing.T) {
+	_, err := NewStrategy(Policy{Strategy: "vibes"})
+	assert.Error(t, err)
+
+	_, err = NewStrategy(Policy{Strategy: StrategyExponentialDecay, Params: map[string]float64{"half_life_hours": 0}})
+	assert.Error(t, err)
+}
+
+func TestGrader_CoursePolicy(t *testing.T) {
+	store := new(MockStore)
+	grader := NewGrader(store, map[int]int{1: -1}, 0.5, 7, 1)
+	require.NoError(t, grader.SetCoursePolicy("strict", Policy{Strategy: StrategyNoLate}))
+
+	deadline := time.Date(2024, 4, 1, 23, 59, 59, 0, time.UTC)
+	submitTime := deadline.Add(time.Hour)
+
+	for course, expected := range map[string]int{"strict": 0, "relaxed": 9} {
+		store.On("GetScoreOverride", course, "lab1", "student1").Return(nil, nil).Once()
+		store.On("GetStudentFinishEvent", course, "lab1", "student1").
+			Return(&models.Entry{Timestamp: submitTime.Unix()}, nil).Once()
+		store.On("GetLabScore", course, "lab1").
+			Return(&models.LabScore{BaseScore: 10, Deadline: deadline.Unix()}, nil).Once()
+
+		score, err := grader.ScoreForStudent(course, "lab1", "student1")
+		assert.NoError(t, err)
+		assert.Equal(t, expected, score, course)
+	}
+
+	store.AssertExpectations(t)
+}
@@ -0,0 +1,37 @@
+package scoring
+
+import "fmt"
+
+// teams is lab -> student -> everyone in the student's team, the student included
+type teams map[string]map[string][]string
+
+func (g *Grader) loadTeams(course string) (teams, error) {
+	members, err := g.store.ListTeamMembers(course)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list teams: %w", err)
+	}
+
+	byTeam := make(map[string][]string)
+	for _, m := range members {
+		key := m.Lab + "/" + m.Team
+		byTeam[key] = append(byTeam[key], m.Student)
+	}
+
+	t := make(teams)
+	for _, m := range members {
+		if t[m.Lab] == nil {
+			t[m.Lab] = make(map[string][]string)
+		}
+		t[m.Lab][m.Student] = byTeam[m.Lab+"/"+m.Team]
+	}
+	return t, nil
+}
+
+// mates returns the students sharing credit with student on lab,
+// a student without a team only shares with themselves
+func (t teams) mates(lab, student string) []string {
+	if mates, ok := t[lab][student]; ok {
+		return mates
+	}
+	return []string{student}
+}
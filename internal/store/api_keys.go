@@ -0,0 +1,127 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+// APIKeyStore keeps named admin API keys by the hash of the key
+type APIKeyStore interface {
+	CreateAPIKey(key models.APIKey, keyHash string) error
+	GetAPIKeyByHash(keyHash string) (*models.APIKey, error)
+	ListAPIKeys() ([]models.APIKey, error)
+	RevokeAPIKey(name string, at int64) (bool, error)
+}
+
+type apiKeyRow struct {
+	Name      string `db:"name"`
+	KeyHash   string `db:"key_hash"`
+	KeyHint   string `db:"key_hint"`
+	Scopes    string `db:"scopes"`
+	Courses   string `db:"courses"`
+	CreatedAt int64  `db:"created_at"`
+	CreatedBy string `db:"created_by"`
+	RevokedAt *int64 `db:"revoked_at"`
+}
+
+func (r apiKeyRow) key() models.APIKey {
+	key := models.APIKey{
+		Name:      r.Name,
+		Hint:      r.KeyHint,
+		Scopes:    splitList(r.Scopes),
+		Courses:   splitList(r.Courses),
+		CreatedAt: time.Unix(r.CreatedAt, 0).UTC(),
+		CreatedBy: r.CreatedBy,
+	}
+	if r.RevokedAt != nil {
+		revoked := time.Unix(*r.RevokedAt, 0).UTC()
+		key.RevokedAt = &revoked
+	}
+	return key
+}
+
+// scopes and courses are short, a comma separated column keeps both dialects happy
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// CreateAPIKey fails when a key with the same name exists, revoked or not
+func (s *BaseStore) CreateAPIKey(key models.APIKey, keyHash string) error {
+	_, err := s.DB.NamedExec(`
+		INSERT INTO api_keys (name, key_hash, key_hint, scopes, courses, created_at, created_by)
+		VALUES (:name, :key_hash, :key_hint, :scopes, :courses, :created_at, :created_by)
+	`, apiKeyRow{
+		Name:      key.Name,
+		KeyHash:   keyHash,
+		KeyHint:   key.Hint,
+		Scopes:    strings.Join(key.Scopes, ","),
+		Courses:   strings.Join(key.Courses, ","),
+		CreatedAt: key.CreatedAt.Unix(),
+		CreatedBy: key.CreatedBy,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create api key: %w", err)
+	}
+	return nil
+}
+
+// GetAPIKeyByHash returns nil for unknown keys
+func (s *BaseStore) GetAPIKeyByHash(keyHash string) (*models.APIKey, error) {
+	var row apiKeyRow
+	err := s.DB.Get(&row, s.Converter(`
+		SELECT name, key_hash, key_hint, scopes, courses, created_at, created_by, revoked_at
+		FROM api_keys
+		WHERE key_hash = ?
+	`), keyHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+	key := row.key()
+	return &key, nil
+}
+
+func (s *BaseStore) ListAPIKeys() ([]models.APIKey, error) {
+	var rows []apiKeyRow
+	err := s.DB.Select(&rows, `
+		SELECT name, key_hash, key_hint, scopes, courses, created_at, created_by, revoked_at
+		FROM api_keys
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+
+	keys := make([]models.APIKey, 0, len(rows))
+	for _, r := range rows {
+		keys = append(keys, r.key())
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey returns false when there is no active key with that name
+func (s *BaseStore) RevokeAPIKey(name string, at int64) (bool, error) {
+	result, err := s.DB.Exec(s.Converter(`
+		UPDATE api_keys
+		SET revoked_at = ?
+		WHERE name = ? AND revoked_at IS NULL
+	`), at, name)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke api key: %w", err)
+	}
+	return revoked > 0, nil
+}
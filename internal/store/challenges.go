@@ -0,0 +1,38 @@
+package store
+
+import (
+	"fmt"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+// ChallengeStore keeps finishes that were flagged by the start/finish challenge
+type ChallengeStore interface {
+	CreateChallengeFailure(failure models.ChallengeFailure) error
+	ListChallengeFailures(course string) ([]models.ChallengeFailure, error)
+}
+
+func (s *BaseStore) CreateChallengeFailure(failure models.ChallengeFailure) error {
+	_, err := s.DB.NamedExec(`
+		INSERT INTO challenge_failures (course, lab, student, timestamp, reason)
+		VALUES (:course, :lab, :student, :timestamp, :reason)
+	`, failure)
+	if err != nil {
+		return fmt.Errorf("failed to create challenge failure: %w", err)
+	}
+	return nil
+}
+
+func (s *BaseStore) ListChallengeFailures(course string) ([]models.ChallengeFailure, error) {
+	var failures []models.ChallengeFailure
+	err := s.DB.Select(&failures, s.Converter(`
+		SELECT course, lab, student, timestamp, reason
+		FROM challenge_failures
+		WHERE course = ?
+		ORDER BY timestamp
+	`), course)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list challenge failures: %w", err)
+	}
+	return failures, nil
+}
@@ -26,7 +26,16 @@ type ScoreStore interface {
 	GetLabScore(course, lab string) (*models.LabScore, error)
 	ListLabScores(course string) ([]models.LabScore, error)
 	GetCourseEventsByType(course, eventType string) ([]models.Entry, error)
-	GetDetailedStats(course, startEventType, finishEventType string, timestampFormat string, includeHumanDttm bool) ([]StatResult, error)
+	CountEarlierFinishers(course, lab string, timestamp int64) (int, error)
+	GetDetailedStats(course, startEventType, finishEventType string) ([]StatResult, error)
+
+	CreateGradebookSnapshot(snapshot models.GradebookSnapshot) error
+	GetGradebookSnapshot(course, name string) (*models.GradebookSnapshot, error)
+	ListGradebookSnapshots(course string) ([]models.GradebookSnapshot, error)
+
+	CreateTeam(course, lab, team string, students []string) error
+	DeleteTeam(course, lab, team string) error
+	ListTeamMembers(course string) ([]models.TeamMember, error)
 }
 
 // BaseStore provides common functionality for different DB implementations
@@ -83,6 +92,8 @@ func (s *BaseStore) CreateEntry(entry *models.Entry) error {
 	return nil
 }
 
+// GetStudentFinishEvent returns the first finish of the lab by the student or
+// any of their teammates, Student of the returned entry is whoever finished
 func (s *BaseStore) GetStudentFinishEvent(course, lab, student string) (*models.Entry, error) {
 	var entry models.Entry
 	query := s.Converter(`
@@ -90,13 +101,21 @@ func (s *BaseStore) GetStudentFinishEvent(course, lab, student string) (*models.
         FROM entries
         WHERE course = ?
 	        AND lab = ?
-	        AND student = ?
+	        AND (student = ? OR student IN (
+	            SELECT mate.student
+	            FROM teams me
+	            JOIN teams mate
+	                ON mate.course = me.course
+	                AND mate.lab = me.lab
+	                AND mate.team = me.team
+	            WHERE me.course = ? AND me.lab = ? AND me.student = ?
+	        ))
         AND event_type = '100_lab_finish'
         ORDER BY timestamp ASC
         LIMIT 1
     `)
 
-	err := s.DB.Get(&entry, query, course, lab, student)
+	err := s.DB.Get(&entry, query, course, lab, student, course, lab, student)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -249,3 +268,128 @@ func (s *BaseStore) GetCourseEventsByType(course, eventType string) ([]models.En
 
 	return entries, nil
 }
+
+func (s *BaseStore) CreateGradebookSnapshot(snapshot models.GradebookSnapshot) error {
+	_, err := s.DB.NamedExec(`
+		INSERT INTO gradebook_snapshots (course, name, created_at, created_by, input_hash, data)
+		VALUES (:course, :name, :created_at, :created_by, :input_hash, :data)
+	`, snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to create gradebook snapshot: %w", err)
+	}
+	return nil
+}
+
+func (s *BaseStore) GetGradebookSnapshot(course, name string) (*models.GradebookSnapshot, error) {
+	var snapshot models.GradebookSnapshot
+	query := s.Converter(`
+		SELECT course, name, created_at, created_by, input_hash, data
+		FROM gradebook_snapshots
+		WHERE course = ? AND name = ?
+	`)
+	err := s.DB.Get(&snapshot, query, course, name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gradebook snapshot: %w", err)
+	}
+	return &snapshot, nil
+}
+
+// ListGradebookSnapshots returns snapshot metadata only, Data is left empty
+func (s *BaseStore) ListGradebookSnapshots(course string) ([]models.GradebookSnapshot, error) {
+	var snapshots []models.GradebookSnapshot
+	query := s.Converter(`
+		SELECT course, name, created_at, created_by, input_hash
+		FROM gradebook_snapshots
+		WHERE course = ?
+		ORDER BY created_at ASC
+	`)
+	err := s.DB.Select(&snapshots, query, course)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list gradebook snapshots: %w", err)
+	}
+	return snapshots, nil
+}
+
+// CountEarlierFinishers counts students whose first finish of the lab happened before timestamp
+func (s *BaseStore) CountEarlierFinishers(course, lab string, timestamp int64) (int, error) {
+	var count int
+	query := s.Converter(`
+		SELECT COUNT(*)
+		FROM (
+			SELECT student, MIN(timestamp) AS first_finish
+			FROM entries
+			WHERE course = ?
+				AND lab = ?
+				AND event_type = '100_lab_finish'
+			GROUP BY student
+		) finishes
+		WHERE first_finish < ?
+	`)
+
+	if err := s.DB.Get(&count, query, course, lab, timestamp); err != nil {
+		return 0, fmt.Errorf("failed to count earlier finishers: %w", err)
+	}
+	return count, nil
+}
+
+// CreateTeam replaces the team roster, students are moved out of their previous team for this lab
+func (s *BaseStore) CreateTeam(course, lab, team string, students []string) error {
+	tx, err := s.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(s.Converter(`
+		DELETE FROM teams
+		WHERE course = ? AND lab = ? AND team = ?
+	`), course, lab, team)
+	if err != nil {
+		return fmt.Errorf("failed to clear team: %w", err)
+	}
+
+	for _, student := range students {
+		_, err := tx.NamedExec(`
+			INSERT INTO teams (course, lab, team, student)
+			VALUES (:course, :lab, :team, :student)
+			ON CONFLICT(course, lab, student) DO UPDATE SET
+			team = :team
+		`, models.TeamMember{Course: course, Lab: lab, Team: team, Student: student})
+		if err != nil {
+			return fmt.Errorf("failed to add %s to team: %w", student, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save team: %w", err)
+	}
+	return nil
+}
+
+func (s *BaseStore) DeleteTeam(course, lab, team string) error {
+	_, err := s.DB.Exec(s.Converter(`
+		DELETE FROM teams
+		WHERE course = ? AND lab = ? AND team = ?
+	`), course, lab, team)
+	if err != nil {
+		return fmt.Errorf("failed to delete team: %w", err)
+	}
+	return nil
+}
+
+func (s *BaseStore) ListTeamMembers(course string) ([]models.TeamMember, error) {
+	var members []models.TeamMember
+	query := s.Converter(`
+		SELECT course, lab, team, student
+		FROM teams
+		WHERE course = ?
+		ORDER BY lab, team, student
+	`)
+	if err := s.DB.Select(&members, query, course); err != nil {
+		return nil, fmt.Errorf("failed to list teams: %w", err)
+	}
+	return members, nil
+}
@@ -43,7 +43,7 @@ func (s *PostgresStore) ApplyMigrations(dir string) error {
 	return s.BaseStore.ApplyMigrations(dir, nil)
 }
 
-func (s *PostgresStore) GetDetailedStats(course, startEventType, finishEventType string, timestampFormat string, includeHumanDttm bool) ([]store.StatResult, error) {
+func (s *PostgresStore) GetDetailedStats(course, startEventType, finishEventType string) ([]store.StatResult, error) {
 	query := `
 		WITH start_events AS (
             SELECT
@@ -80,15 +80,7 @@ func (s *PostgresStore) GetDetailedStats(course, startEventType, finishEventType
             CASE
                 WHEN fe.first_finish IS NOT NULL 
                 THEN fe.first_finish - se.first_run
-            END as delta_seconds,
-            CASE WHEN $4 THEN
-                to_char(to_timestamp(se.first_run), $5)
-            ELSE NULL
-            END as human_first_run,
-            CASE WHEN $4 AND fe.first_finish IS NOT NULL THEN
-                to_char(to_timestamp(fe.first_finish), $5)
-            ELSE NULL
-            END as human_first_finish
+            END as delta_seconds
         FROM start_events se
         LEFT JOIN finish_events fe
             ON se.student = fe.student
@@ -102,8 +94,6 @@ func (s *PostgresStore) GetDetailedStats(course, startEventType, finishEventType
 		course,
 		startEventType,
 		finishEventType,
-		includeHumanDttm,
-		timestampFormat,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch stats: %w", err)
@@ -67,7 +67,7 @@ func (s *SQLiteStore) ApplyMigrations(dir string) error {
 	return s.BaseStore.ApplyMigrations(dir, translateToSQLite)
 }
 
-func (s *SQLiteStore) GetDetailedStats(course, startEventType, finishEventType string, timestampFormat string, includeHumanDttm bool) ([]store.StatResult, error) {
+func (s *SQLiteStore) GetDetailedStats(course, startEventType, finishEventType string) ([]store.StatResult, error) {
 
 	query := `
 		WITH start_events AS (
@@ -105,15 +105,7 @@ func (s *SQLiteStore) GetDetailedStats(course, startEventType, finishEventType s
             CASE
                 WHEN fe.first_finish IS NOT NULL
                 THEN fe.first_finish - se.first_run
-            END as delta_seconds,
-            CASE WHEN ? THEN
-                datetime(se.first_run, 'unixepoch', 'localtime')
-            ELSE NULL
-            END as human_first_run,
-            CASE WHEN ? AND fe.first_finish IS NOT NULL THEN
-                datetime(fe.first_finish, 'unixepoch', 'localtime')
-            ELSE NULL
-            END as human_first_finish
+            END as delta_seconds
         FROM start_events se
         LEFT JOIN finish_events fe
             ON se.student = fe.student
@@ -128,8 +120,6 @@ func (s *SQLiteStore) GetDetailedStats(course, startEventType, finishEventType s
 		startEventType,
 		course,
 		finishEventType,
-		includeHumanDttm,
-		includeHumanDttm,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch stats: %w", err)
@@ -190,7 +190,7 @@ func TestScoreOverrideOperations(t *testing.T) {
 	})
 
 	t.Run("list overrides", func(t *testing.T) {
-		overrides, err := td.store.ListScoreOverrides()
+		overrides, err := td.store.ListCourseScoreOverrides(override.Course)
 		require.NoError(t, err)
 		assert.Len(t, overrides, 1)
 		assert.Equal(t, override.Student, overrides[0].Student)
@@ -214,3 +214,365 @@ func TestLabScoreOperations(t *testing.T) {
 		assert.Nil(t, score)
 	})
 }
+
+func TestGradebookSnapshotOperations(t *testing.T) {
+	td, cleanup := setupTestData(t)
+	defer cleanup()
+
+	snapshot := models.GradebookSnapshot{
+		Course:    "cs101",
+		Name:      "final",
+		CreatedAt: td.now.Unix(),
+		CreatedBy: "@admin",
+		InputHash: "abc",
+		Data:      `{"scores":{}}`,
+	}
+
+	t.Run("create snapshot", func(t *testing.T) {
+		err := td.store.CreateGradebookSnapshot(snapshot)
+		require.NoError(t, err)
+	})
+
+	t.Run("snapshots are immutable", func(t *testing.T) {
+		err := td.store.CreateGradebookSnapshot(snapshot)
+		assert.Error(t, err)
+	})
+
+	t.Run("get snapshot", func(t *testing.T) {
+		got, err := td.store.GetGradebookSnapshot("cs101", "final")
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.Equal(t, snapshot, *got)
+	})
+
+	t.Run("get non-existent snapshot", func(t *testing.T) {
+		got, err := td.store.GetGradebookSnapshot("cs101", "midterm")
+		require.NoError(t, err)
+		assert.Nil(t, got)
+	})
+
+	t.Run("list snapshots", func(t *testing.T) {
+		snapshots, err := td.store.ListGradebookSnapshots("cs101")
+		require.NoError(t, err)
+		require.Len(t, snapshots, 1)
+		assert.Equal(t, "final", snapshots[0].Name)
+		assert.Empty(t, snapshots[0].Data)
+	})
+}
+
+func TestCountEarlierFinishers(t *testing.T) {
+	td, cleanup := setupTestData(t)
+	defer cleanup()
+
+	entries := []models.Entry{
+		{Timestamp: td.now.Add(-3 * time.Hour).Unix(), EventType: "100_lab_finish", Lab: "l1", Student: "alice.a", Course: "cs101"},
+		{Timestamp: td.now.Add(-1 * time.Hour).Unix(), EventType: "100_lab_finish", Lab: "l1", Student: "alice.a", Course: "cs101"},
+		{Timestamp: td.now.Add(-2 * time.Hour).Unix(), EventType: "100_lab_finish", Lab: "l1", Student: "bob.b", Course: "cs101"},
+		{Timestamp: td.now.Add(-4 * time.Hour).Unix(), EventType: "000_lab_start", Lab: "l1", Student: "carol.c", Course: "cs101"},
+		{Timestamp: td.now.Add(-4 * time.Hour).Unix(), EventType: "100_lab_finish", Lab: "l2", Student: "carol.c", Course: "cs101"},
+	}
+	for _, e := range entries {
+		require.NoError(t, td.store.CreateEntry(&e))
+	}
+
+	count, err := td.store.CountEarlierFinishers("cs101", "l1", td.now.Unix())
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	count, err = td.store.CountEarlierFinishers("cs101", "l1", td.now.Add(-2*time.Hour).Unix())
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestTeamOperations(t *testing.T) {
+	td, cleanup := setupTestData(t)
+	defer cleanup()
+
+	require.NoError(t, td.store.CreateTeam("cs101", "l1", "owls", []string{"alice.a", "bob.b"}))
+	require.NoError(t, td.store.CreateTeam("cs101", "l1", "cats", []string{"carol.c"}))
+
+	finish := models.Entry{Timestamp: td.now.Unix(), EventType: "100_lab_finish", Lab: "l1", Student: "alice.a", Course: "cs101"}
+	require.NoError(t, td.store.CreateEntry(&finish))
+
+	t.Run("teammate gets the finish", func(t *testing.T) {
+		entry, err := td.store.GetStudentFinishEvent("cs101", "l1", "bob.b")
+		require.NoError(t, err)
+		require.NotNil(t, entry)
+		assert.Equal(t, "alice.a", entry.Student)
+
+		entry, err = td.store.GetStudentFinishEvent("cs101", "l1", "carol.c")
+		require.NoError(t, err)
+		assert.Nil(t, entry)
+	})
+
+	t.Run("recreating a team moves students", func(t *testing.T) {
+		require.NoError(t, td.store.CreateTeam("cs101", "l1", "cats", []string{"carol.c", "bob.b"}))
+
+		members, err := td.store.ListTeamMembers("cs101")
+		require.NoError(t, err)
+		assert.Equal(t, []models.TeamMember{
+			{Course: "cs101", Lab: "l1", Team: "cats", Student: "bob.b"},
+			{Course: "cs101", Lab: "l1", Team: "cats", Student: "carol.c"},
+			{Course: "cs101", Lab: "l1", Team: "owls", Student: "alice.a"},
+		}, members)
+
+		entry, err := td.store.GetStudentFinishEvent("cs101", "l1", "carol.c")
+		require.NoError(t, err)
+		assert.Nil(t, entry)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, td.store.DeleteTeam("cs101", "l1", "cats"))
+
+		members, err := td.store.ListTeamMembers("cs101")
+		require.NoError(t, err)
+		assert.Len(t, members, 1)
+	})
+}
+
+func TestTokenStoreOperations(t *testing.T) {
+	td, cleanup := setupTestData(t)
+	defer cleanup()
+
+	now := td.now.Unix()
+	token := models.StudentToken{
+		Course: "cs101", Student: "alice.a",
+		TokenHash: "hash1", TokenSalt: "salt1", TokenHint: "kb_…abcd",
+		RequestCount: 1, CreatedAt: now, LastRequestAt: now,
+	}
+
+	t.Run("create once", func(t *testing.T) {
+		created, err := td.store.CreateStudentToken(token)
+		require.NoError(t, err)
+		assert.True(t, created)
+
+		token.TokenHash = "other"
+		created, err = td.store.CreateStudentToken(token)
+		require.NoError(t, err)
+		assert.False(t, created)
+
+		got, err := td.store.GetStudentToken("cs101", "alice.a")
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.Equal(t, "hash1", got.TokenHash)
+		assert.Nil(t, got.RotatedAt)
+
+		missing, err := td.store.GetStudentToken("cs101", "bob.b")
+		require.NoError(t, err)
+		assert.Nil(t, missing)
+	})
+
+	t.Run("touch, revoke and replace", func(t *testing.T) {
+		require.NoError(t, td.store.TouchStudentToken("cs101", "alice.a", now+10))
+
+		revoked, err := td.store.RevokeStudentToken("cs101", "alice.a", "admin", now+20)
+		require.NoError(t, err)
+		assert.True(t, revoked)
+
+		revoked, err = td.store.RevokeStudentToken("cs101", "bob.b", "admin", now+20)
+		require.NoError(t, err)
+		assert.False(t, revoked)
+
+		got, err := td.store.GetStudentToken("cs101", "alice.a")
+		require.NoError(t, err)
+		assert.Equal(t, 2, got.RequestCount)
+		assert.Equal(t, now+10, got.LastRequestAt)
+		require.NotNil(t, got.RevokedBy)
+		assert.Equal(t, "admin", *got.RevokedBy)
+
+		rotated := now + 30
+		require.NoError(t, td.store.ReplaceStudentToken(models.StudentToken{
+			Course: "cs101", Student: "alice.a",
+			TokenHash: "hash2", TokenSalt: "salt2", TokenHint: "kb_…efgh",
+			RequestCount: 1, CreatedAt: rotated, LastRequestAt: rotated, RotatedAt: &rotated,
+		}))
+
+		got, err = td.store.GetStudentToken("cs101", "alice.a")
+		require.NoError(t, err)
+		assert.Equal(t, "hash2", got.TokenHash)
+		assert.Equal(t, now, got.CreatedAt)
+		assert.Equal(t, 3, got.RequestCount)
+		assert.Nil(t, got.RevokedAt)
+		require.NotNil(t, got.RotatedAt)
+		assert.Equal(t, rotated, *got.RotatedAt)
+
+		tokens, err := td.store.ListStudentTokens("cs101")
+		require.NoError(t, err)
+		assert.Len(t, tokens, 1)
+	})
+
+	t.Run("token events", func(t *testing.T) {
+		for i, action := range []string{models.TokenRevoked, models.TokenRotated} {
+			require.NoError(t, td.store.CreateTokenEvent(models.TokenEvent{
+				ID: int64(i + 1), Action: action, Course: "cs101", Student: "alice.a", Actor: "admin", Time: td.now,
+			}))
+		}
+
+		events, err := td.store.ListTokenEventsAfter(1)
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, models.TokenRotated, events[0].Action)
+		assert.Equal(t, td.now, events[0].Time)
+	})
+
+	t.Run("telegram mappings", func(t *testing.T) {
+		require.NoError(t, td.store.SaveCourseStudent("cs101", "alice_tg", "alice.a"))
+		require.NoError(t, td.store.SaveCourseStudent("cs101", "alice_tg", "alice.b"))
+
+		student, err := td.store.GetCourseStudent("cs101", "alice_tg")
+		require.NoError(t, err)
+		assert.Equal(t, "alice.b", student)
+
+		student, err = td.store.GetCourseStudent("cs101", "nobody")
+		require.NoError(t, err)
+		assert.Empty(t, student)
+
+		students, err := td.store.ListCourseStudents("cs101")
+		require.NoError(t, err)
+		assert.Equal(t, map[string]string{"alice_tg": "alice.b"}, students)
+
+		require.NoError(t, td.store.SaveStudentCourseInfo("alice_tg", models.StudentCourseInfo{StudentID: "alice.b", Course: "cs101"}))
+		info, err := td.store.GetStudentCourseInfo("alice_tg")
+		require.NoError(t, err)
+		assert.Equal(t, &models.StudentCourseInfo{StudentID: "alice.b", Course: "cs101"}, info)
+
+		info, err = td.store.GetStudentCourseInfo("nobody")
+		require.NoError(t, err)
+		assert.Nil(t, info)
+	})
+
+	t.Run("chat courses", func(t *testing.T) {
+		mapping := models.ChatCourseMapping{Course: "cs101", Name: "main", Comment: "", AssociationTime: td.now, RegisteredBy: 42}
+		require.NoError(t, td.store.SaveChatCourse(-100, mapping))
+
+		got, err := td.store.GetChatCourse(-100)
+		require.NoError(t, err)
+		assert.Equal(t, &mapping, got)
+
+		got, err = td.store.GetChatCourse(-200)
+		require.NoError(t, err)
+		assert.Nil(t, got)
+
+		other := models.ChatCourseMapping{Course: "cs102", Name: "other", AssociationTime: td.now, RegisteredBy: 42}
+		require.NoError(t, td.store.SaveChatCourse(-300, other))
+
+		chats, err := td.store.ListChatCourses("cs101")
+		require.NoError(t, err)
+		assert.Equal(t, map[int64]*models.ChatCourseMapping{-100: &mapping}, chats)
+
+		chats, err = td.store.ListChatCourses("")
+		require.NoError(t, err)
+		assert.Len(t, chats, 2)
+
+		deleted, err := td.store.DeleteChatCourse(-100)
+		require.NoError(t, err)
+		assert.True(t, deleted)
+		deleted, err = td.store.DeleteChatCourse(-100)
+		require.NoError(t, err)
+		assert.False(t, deleted)
+
+		chats, err = td.store.ListChatCourses("cs101")
+		require.NoError(t, err)
+		assert.Empty(t, chats)
+	})
+
+	t.Run("telegram users", func(t *testing.T) {
+		userID, err := td.store.GetTelegramUserID("alice")
+		require.NoError(t, err)
+		assert.Zero(t, userID)
+
+		require.NoError(t, td.store.SaveTelegramUser("alice", 42))
+		require.NoError(t, td.store.SaveTelegramUser("alice", 43))
+		userID, err = td.store.GetTelegramUserID("alice")
+		require.NoError(t, err)
+		assert.Equal(t, int64(43), userID)
+	})
+}
+
+func TestAPIKeyOperations(t *testing.T) {
+	td, cleanup := setupTestData(t)
+	defer cleanup()
+
+	key := models.APIKey{
+		Name:      "grafana",
+		Hint:      "kb-key-…abcd",
+		Scopes:    []string{"read-stats", "read-scores"},
+		Courses:   []string{"cs101"},
+		CreatedAt: td.now,
+		CreatedBy: "@admin",
+	}
+	require.NoError(t, td.store.CreateAPIKey(key, "hash1"))
+	assert.Error(t, td.store.CreateAPIKey(key, "hash2"), "names are unique")
+
+	got, err := td.store.GetAPIKeyByHash("hash1")
+	require.NoError(t, err)
+	assert.Equal(t, &key, got)
+
+	got, err = td.store.GetAPIKeyByHash("nope")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	revoked, err := td.store.RevokeAPIKey("grafana", td.now.Unix())
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	revoked, err = td.store.RevokeAPIKey("grafana", td.now.Unix())
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	keys, err := td.store.ListAPIKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.NotNil(t, keys[0].RevokedAt)
+	assert.Equal(t, td.now, *keys[0].RevokedAt)
+}
+
+func TestChallengeFailureOperations(t *testing.T) {
+	td, cleanup := setupTestData(t)
+	defer cleanup()
+
+	failures := []models.ChallengeFailure{
+		{Course: "cs101", Lab: "l1", Student: "bob.b", Timestamp: td.now.Unix() + 60, Reason: "mismatch"},
+		{Course: "cs101", Lab: "l1", Student: "alice.a", Timestamp: td.now.Unix(), Reason: "no_start"},
+		{Course: "cs102", Lab: "l1", Student: "alice.a", Timestamp: td.now.Unix(), Reason: "missing"},
+	}
+	for _, f := range failures {
+		require.NoError(t, td.store.CreateChallengeFailure(f))
+	}
+
+	got, err := td.store.ListChallengeFailures("cs101")
+	require.NoError(t, err)
+	assert.Equal(t, []models.ChallengeFailure{failures[1], failures[0]}, got)
+}
+
+func TestTokenUsageOperations(t *testing.T) {
+	td, cleanup := setupTestData(t)
+	defer cleanup()
+
+	uses := []models.TokenUse{
+		{Lab: "l1", EventType: "start", IP: "10.0.0.1", UserAgent: "checker/1", Time: td.now},
+		{Lab: "l1", EventType: "start", IP: "10.0.0.1", UserAgent: "checker/1", Time: td.now.Add(time.Minute)},
+		{Lab: "l1", EventType: "finish", IP: "10.0.0.2", UserAgent: "checker/2", Time: td.now.Add(2 * time.Minute)},
+	}
+	for _, use := range uses {
+		require.NoError(t, td.store.RecordTokenUse("cs101", "alice.a", use))
+	}
+	require.NoError(t, td.store.RecordTokenUse("cs101", "bob.b", uses[0]))
+
+	usage, err := td.store.GetTokenUsage("cs101", "alice.a")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), usage.Count)
+	assert.Equal(t, td.now.Add(2*time.Minute).Unix(), usage.LastUsed.Unix())
+	assert.Equal(t, "10.0.0.2", usage.LastIP)
+	assert.Equal(t, "checker/2", usage.LastUserAgent)
+	assert.Equal(t, map[string]int64{"l1/start": 2, "l1/finish": 1}, usage.Events)
+
+	require.NoError(t, td.store.DeleteTokenUsage("cs101", "alice.a"))
+	usage, err = td.store.GetTokenUsage("cs101", "alice.a")
+	require.NoError(t, err)
+	assert.Zero(t, usage.Count)
+
+	usage, err = td.store.GetTokenUsage("cs101", "bob.b")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), usage.Count)
+}
@@ -0,0 +1,532 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/shrimpsizemoose/kanelbulle/internal/models"
+)
+
+// TokenStore keeps student tokens, telegram mappings and chat associations
+// next to the scores, for deployments that don't run redis
+type TokenStore interface {
+	GetStudentToken(course, student string) (*models.StudentToken, error)
+	CreateStudentToken(token models.StudentToken) (bool, error)
+	TouchStudentToken(course, student string, at int64) error
+	ReplaceStudentToken(token models.StudentToken) error
+	RevokeStudentToken(course, student, by string, at int64) (bool, error)
+	ListStudentTokens(course string) ([]models.StudentToken, error)
+	SaveTokenLookup(lookup, course, student string) error
+	GetTokenLookup(lookup string) (*models.StudentCourseInfo, error)
+	RecordTokenUse(course, student string, use models.TokenUse) error
+	GetTokenUsage(course, student string) (*models.TokenUsage, error)
+	DeleteTokenUsage(course, student string) error
+
+	CreateTokenEvent(event models.TokenEvent) error
+	ListTokenEventsAfter(id int64) ([]models.TokenEvent, error)
+
+	SaveCourseStudent(course, tgUsername, student string) error
+	GetCourseStudent(course, tgUsername string) (string, error)
+	ListCourseStudents(course string) (map[string]string, error)
+	SaveStudentCourseInfo(tgUsername string, info models.StudentCourseInfo) error
+	GetStudentCourseInfo(tgUsername string) (*models.StudentCourseInfo, error)
+
+	SaveChatCourse(chatID int64, mapping models.ChatCourseMapping) error
+	GetChatCourse(chatID int64) (*models.ChatCourseMapping, error)
+	ListChatCourses(course string) (map[int64]*models.ChatCourseMapping, error)
+	SaveTelegramUser(tgUsername string, userID int64) error
+	GetTelegramUserID(tgUsername string) (int64, error)
+	DeleteChatCourse(chatID int64) (bool, error)
+}
+
+const studentTokenColumns = `course, student, token_hash, token_salt, token_hint, request_count,
+	created_at, last_request_at, rotated_at, revoked_at, revoked_by`
+
+// GetStudentToken returns nil when the student has no token
+func (s *BaseStore) GetStudentToken(course, student string) (*models.StudentToken, error) {
+	var token models.StudentToken
+	query := s.Converter(`
+		SELECT ` + studentTokenColumns + `
+		FROM student_tokens
+		WHERE course = ? AND student = ?
+	`)
+	err := s.DB.Get(&token, query, course, student)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get student token: %w", err)
+	}
+	return &token, nil
+}
+
+// CreateStudentToken inserts the token unless the student already has one
+func (s *BaseStore) CreateStudentToken(token models.StudentToken) (bool, error) {
+	result, err := s.DB.NamedExec(`
+		INSERT INTO student_tokens (`+studentTokenColumns+`)
+		VALUES (:course, :student, :token_hash, :token_salt, :token_hint, :request_count,
+			:created_at, :last_request_at, :rotated_at, :revoked_at, :revoked_by)
+		ON CONFLICT(course, student) DO NOTHING
+	`, token)
+	if err != nil {
+		return false, fmt.Errorf("failed to create student token: %w", err)
+	}
+	created, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to create student token: %w", err)
+	}
+	return created > 0, nil
+}
+
+func (s *BaseStore) TouchStudentToken(course, student string, at int64) error {
+	_, err := s.DB.Exec(s.Converter(`
+		UPDATE student_tokens
+		SET request_count = request_count + 1, last_request_at = ?
+		WHERE course = ? AND student = ?
+	`), at, course, student)
+	if err != nil {
+		return fmt.Errorf("failed to update token stats: %w", err)
+	}
+	return nil
+}
+
+// ReplaceStudentToken stores a new secret for the student, clearing any revocation
+func (s *BaseStore) ReplaceStudentToken(token models.StudentToken) error {
+	_, err := s.DB.NamedExec(`
+		INSERT INTO student_tokens (`+studentTokenColumns+`)
+		VALUES (:course, :student, :token_hash, :token_salt, :token_hint, :request_count,
+			:created_at, :last_request_at, :rotated_at, NULL, NULL)
+		ON CONFLICT(course, student) DO UPDATE SET
+		token_hash = :token_hash,
+		token_salt = :token_salt,
+		token_hint = :token_hint,
+		request_count = student_tokens.request_count + 1,
+		last_request_at = :last_request_at,
+		rotated_at = :rotated_at,
+		revoked_at = NULL,
+		revoked_by = NULL
+	`, token)
+	if err != nil {
+		return fmt.Errorf("failed to replace student token: %w", err)
+	}
+	return nil
+}
+
+// RevokeStudentToken returns false when the student has no token
+func (s *BaseStore) RevokeStudentToken(course, student, by string, at int64) (bool, error) {
+	result, err := s.DB.Exec(s.Converter(`
+		UPDATE student_tokens
+		SET revoked_at = ?, revoked_by = ?
+		WHERE course = ? AND student = ?
+	`), at, by, course, student)
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke student token: %w", err)
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to revoke student token: %w", err)
+	}
+	return revoked > 0, nil
+}
+
+func (s *BaseStore) ListStudentTokens(course string) ([]models.StudentToken, error) {
+	var tokens []models.StudentToken
+	query := s.Converter(`
+		SELECT ` + studentTokenColumns + `
+		FROM student_tokens
+		WHERE course = ?
+		ORDER BY student
+	`)
+	if err := s.DB.Select(&tokens, query, course); err != nil {
+		return nil, fmt.Errorf("failed to list student tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// SaveTokenLookup points the lookup hash at the student, replacing the
+// lookup of their previous token
+func (s *BaseStore) SaveTokenLookup(lookup, course, student string) error {
+	tx, err := s.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(s.Converter(`
+		DELETE FROM token_lookups
+		WHERE course = ? AND student = ?
+	`), course, student)
+	if err != nil {
+		return fmt.Errorf("failed to clear token lookup: %w", err)
+	}
+
+	_, err = tx.Exec(s.Converter(`
+		INSERT INTO token_lookups (lookup_hash, course, student)
+		VALUES (?, ?, ?)
+	`), lookup, course, student)
+	if err != nil {
+		return fmt.Errorf("failed to save token lookup: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to save token lookup: %w", err)
+	}
+	return nil
+}
+
+// GetTokenLookup returns nil for tokens that aren't indexed
+func (s *BaseStore) GetTokenLookup(lookup string) (*models.StudentCourseInfo, error) {
+	var info models.StudentCourseInfo
+	err := s.DB.Get(&info, s.Converter(`
+		SELECT course, student
+		FROM token_lookups
+		WHERE lookup_hash = ?
+	`), lookup)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token lookup: %w", err)
+	}
+	return &info, nil
+}
+
+type tokenUsageRow struct {
+	Course        string `db:"course"`
+	Student       string `db:"student"`
+	Lab           string `db:"lab"`
+	EventType     string `db:"event_type"`
+	Count         int64  `db:"count"`
+	LastUsedAt    int64  `db:"last_used_at"`
+	LastIP        string `db:"last_ip"`
+	LastUserAgent string `db:"last_user_agent"`
+}
+
+func (s *BaseStore) RecordTokenUse(course, student string, use models.TokenUse) error {
+	_, err := s.DB.NamedExec(`
+		INSERT INTO token_usage (course, student, lab, event_type, count, last_used_at, last_ip, last_user_agent)
+		VALUES (:course, :student, :lab, :event_type, 1, :last_used_at, :last_ip, :last_user_agent)
+		ON CONFLICT(course, student, lab, event_type) DO UPDATE SET
+		count = token_usage.count + 1,
+		last_used_at = :last_used_at,
+		last_ip = :last_ip,
+		last_user_agent = :last_user_agent
+	`, tokenUsageRow{
+		Course:        course,
+		Student:       student,
+		Lab:           use.Lab,
+		EventType:     use.EventType,
+		LastUsedAt:    use.Time.Unix(),
+		LastIP:        use.IP,
+		LastUserAgent: use.UserAgent,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record token use: %w", err)
+	}
+	return nil
+}
+
+// GetTokenUsage returns empty usage for tokens never used with the API
+func (s *BaseStore) GetTokenUsage(course, student string) (*models.TokenUsage, error) {
+	var rows []tokenUsageRow
+	err := s.DB.Select(&rows, s.Converter(`
+		SELECT course, student, lab, event_type, count, last_used_at, last_ip, last_user_agent
+		FROM token_usage
+		WHERE course = ? AND student = ?
+		ORDER BY last_used_at
+	`), course, student)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get token usage: %w", err)
+	}
+
+	usage := &models.TokenUsage{Events: make(map[string]int64, len(rows))}
+	for _, r := range rows {
+		use := models.TokenUse{Lab: r.Lab, EventType: r.EventType}
+		usage.Events[use.UsageKey()] = r.Count
+		usage.Count += r.Count
+		// rows are ordered, the last one is the latest request
+		usage.LastUsed = time.Unix(r.LastUsedAt, 0).UTC()
+		usage.LastIP = r.LastIP
+		usage.LastUserAgent = r.LastUserAgent
+	}
+	return usage, nil
+}
+
+func (s *BaseStore) DeleteTokenUsage(course, student string) error {
+	_, err := s.DB.Exec(s.Converter(`
+		DELETE FROM token_usage
+		WHERE course = ? AND student = ?
+	`), course, student)
+	if err != nil {
+		return fmt.Errorf("failed to delete token usage: %w", err)
+	}
+	return nil
+}
+
+type tokenEventRow struct {
+	ID        int64  `db:"id"`
+	Action    string `db:"action"`
+	Course    string `db:"course"`
+	Student   string `db:"student"`
+	Actor     string `db:"actor"`
+	CreatedAt int64  `db:"created_at"`
+}
+
+func (s *BaseStore) CreateTokenEvent(event models.TokenEvent) error {
+	_, err := s.DB.NamedExec(`
+		INSERT INTO token_events (id, action, course, student, actor, created_at)
+		VALUES (:id, :action, :course, :student, :actor, :created_at)
+	`, tokenEventRow{
+		ID:        event.ID,
+		Action:    event.Action,
+		Course:    event.Course,
+		Student:   event.Student,
+		Actor:     event.Actor,
+		CreatedAt: event.Time.Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create token event: %w", err)
+	}
+	return nil
+}
+
+func (s *BaseStore) ListTokenEventsAfter(id int64) ([]models.TokenEvent, error) {
+	var rows []tokenEventRow
+	query := s.Converter(`
+		SELECT id, action, course, student, actor, created_at
+		FROM token_events
+		WHERE id > ?
+		ORDER BY id
+	`)
+	if err := s.DB.Select(&rows, query, id); err != nil {
+		return nil, fmt.Errorf("failed to list token events: %w", err)
+	}
+
+	events := make([]models.TokenEvent, 0, len(rows))
+	for _, r := range rows {
+		events = append(events, models.TokenEvent{
+			ID:      r.ID,
+			Action:  r.Action,
+			Course:  r.Course,
+			Student: r.Student,
+			Actor:   r.Actor,
+			Time:    time.Unix(r.CreatedAt, 0).UTC(),
+		})
+	}
+	return events, nil
+}
+
+func (s *BaseStore) SaveCourseStudent(course, tgUsername, student string) error {
+	_, err := s.DB.Exec(s.Converter(`
+		INSERT INTO course_students (course, tg_username, student)
+		VALUES (?, ?, ?)
+		ON CONFLICT(course, tg_username) DO UPDATE SET
+		student = excluded.student
+	`), course, tgUsername, student)
+	if err != nil {
+		return fmt.Errorf("failed to save course student: %w", err)
+	}
+	return nil
+}
+
+func (s *BaseStore) SaveTelegramUser(tgUsername string, userID int64) error {
+	_, err := s.DB.Exec(s.Converter(`
+		INSERT INTO telegram_users (tg_username, user_id)
+		VALUES (?, ?)
+		ON CONFLICT(tg_username) DO UPDATE SET
+		user_id = excluded.user_id
+	`), tgUsername, userID)
+	if err != nil {
+		return fmt.Errorf("failed to save telegram user: %w", err)
+	}
+	return nil
+}
+
+// GetTelegramUserID returns 0 for users who never wrote to the bot
+func (s *BaseStore) GetTelegramUserID(tgUsername string) (int64, error) {
+	var userID int64
+	err := s.DB.Get(&userID, s.Converter(`
+		SELECT user_id
+		FROM telegram_users
+		WHERE tg_username = ?
+	`), tgUsername)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get telegram user: %w", err)
+	}
+	return userID, nil
+}
+
+// GetCourseStudent returns an empty string for unknown telegram users
+func (s *BaseStore) GetCourseStudent(course, tgUsername string) (string, error) {
+	var student string
+	err := s.DB.Get(&student, s.Converter(`
+		SELECT student
+		FROM course_students
+		WHERE course = ? AND tg_username = ?
+	`), course, tgUsername)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get course student: %w", err)
+	}
+	return student, nil
+}
+
+// ListCourseStudents returns telegram username -> student ID
+func (s *BaseStore) ListCourseStudents(course string) (map[string]string, error) {
+	var rows []struct {
+		TgUsername string `db:"tg_username"`
+		Student    string `db:"student"`
+	}
+	err := s.DB.Select(&rows, s.Converter(`
+		SELECT tg_username, student
+		FROM course_students
+		WHERE course = ?
+	`), course)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list course students: %w", err)
+	}
+
+	students := make(map[string]string, len(rows))
+	for _, r := range rows {
+		students[r.TgUsername] = r.Student
+	}
+	return students, nil
+}
+
+func (s *BaseStore) SaveStudentCourseInfo(tgUsername string, info models.StudentCourseInfo) error {
+	_, err := s.DB.Exec(s.Converter(`
+		INSERT INTO telegram_students (tg_username, course, student)
+		VALUES (?, ?, ?)
+		ON CONFLICT(tg_username) DO UPDATE SET
+		course = excluded.course,
+		student = excluded.student
+	`), tgUsername, info.Course, info.StudentID)
+	if err != nil {
+		return fmt.Errorf("failed to save student course info: %w", err)
+	}
+	return nil
+}
+
+// GetStudentCourseInfo returns nil for unknown telegram users
+func (s *BaseStore) GetStudentCourseInfo(tgUsername string) (*models.StudentCourseInfo, error) {
+	var info models.StudentCourseInfo
+	err := s.DB.Get(&info, s.Converter(`
+		SELECT course, student
+		FROM telegram_students
+		WHERE tg_username = ?
+	`), tgUsername)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get student course info: %w", err)
+	}
+	return &info, nil
+}
+
+type chatCourseRow struct {
+	ChatID       int64  `db:"chat_id"`
+	Course       string `db:"course"`
+	Name         string `db:"name"`
+	Comment      string `db:"comment"`
+	AssociatedAt int64  `db:"associated_at"`
+	RegisteredBy int64  `db:"registered_by"`
+}
+
+func (r chatCourseRow) mapping() *models.ChatCourseMapping {
+	return &models.ChatCourseMapping{
+		Course:          r.Course,
+		Name:            r.Name,
+		Comment:         r.Comment,
+		AssociationTime: time.Unix(r.AssociatedAt, 0).UTC(),
+		RegisteredBy:    r.RegisteredBy,
+	}
+}
+
+func (s *BaseStore) SaveChatCourse(chatID int64, mapping models.ChatCourseMapping) error {
+	_, err := s.DB.NamedExec(`
+		INSERT INTO chat_courses (chat_id, course, name, comment, associated_at, registered_by)
+		VALUES (:chat_id, :course, :name, :comment, :associated_at, :registered_by)
+		ON CONFLICT(chat_id) DO UPDATE SET
+		course = :course,
+		name = :name,
+		comment = :comment,
+		associated_at = :associated_at,
+		registered_by = :registered_by
+	`, chatCourseRow{
+		ChatID:       chatID,
+		Course:       mapping.Course,
+		Name:         mapping.Name,
+		Comment:      mapping.Comment,
+		AssociatedAt: mapping.AssociationTime.Unix(),
+		RegisteredBy: mapping.RegisteredBy,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save chat course: %w", err)
+	}
+	return nil
+}
+
+// GetChatCourse returns nil for chats not associated with any course
+func (s *BaseStore) GetChatCourse(chatID int64) (*models.ChatCourseMapping, error) {
+	var row chatCourseRow
+	err := s.DB.Get(&row, s.Converter(`
+		SELECT chat_id, course, name, comment, associated_at, registered_by
+		FROM chat_courses
+		WHERE chat_id = ?
+	`), chatID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chat course: %w", err)
+	}
+	return row.mapping(), nil
+}
+
+// ListChatCourses returns chat ID -> mapping for chats of the course, or for every chat when course is empty
+func (s *BaseStore) ListChatCourses(course string) (map[int64]*models.ChatCourseMapping, error) {
+	query := `
+		SELECT chat_id, course, name, comment, associated_at, registered_by
+		FROM chat_courses
+	`
+	var args []interface{}
+	if course != "" {
+		query += " WHERE course = ?"
+		args = append(args, course)
+	}
+
+	var rows []chatCourseRow
+	if err := s.DB.Select(&rows, s.Converter(query), args...); err != nil {
+		return nil, fmt.Errorf("failed to list chat courses: %w", err)
+	}
+
+	mappings := make(map[int64]*models.ChatCourseMapping, len(rows))
+	for _, row := range rows {
+		mappings[row.ChatID] = row.mapping()
+	}
+	return mappings, nil
+}
+
+// DeleteChatCourse is false when the chat wasn't associated with a course
+func (s *BaseStore) DeleteChatCourse(chatID int64) (bool, error) {
+	res, err := s.DB.Exec(s.Converter(`
+		DELETE FROM chat_courses
+		WHERE chat_id = ?
+	`), chatID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete chat course: %w", err)
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to delete chat course: %w", err)
+	}
+	return affected > 0, nil
+}
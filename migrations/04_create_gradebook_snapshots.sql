@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS gradebook_snapshots (
+    course VARCHAR(6) NOT NULL,
+    name TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    created_by TEXT NOT NULL,
+    input_hash TEXT NOT NULL,
+    data TEXT NOT NULL,
+    CONSTRAINT gradebook_snapshots_pkey PRIMARY KEY (course, name)
+);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS teams (
+    course VARCHAR(6) NOT NULL,
+    lab VARCHAR(3) NOT NULL,
+    team TEXT NOT NULL,
+    student TEXT NOT NULL,
+    CONSTRAINT teams_pkey PRIMARY KEY (course, lab, student)
+);
@@ -0,0 +1,48 @@
+CREATE TABLE IF NOT EXISTS student_tokens (
+    course VARCHAR(6) NOT NULL,
+    student TEXT NOT NULL,
+    token_hash TEXT NOT NULL,
+    token_salt TEXT NOT NULL,
+    token_hint TEXT NOT NULL,
+    request_count INTEGER NOT NULL DEFAULT 0,
+    created_at BIGINT NOT NULL,
+    last_request_at BIGINT NOT NULL,
+    rotated_at BIGINT,
+    revoked_at BIGINT,
+    revoked_by TEXT,
+    CONSTRAINT student_tokens_pkey PRIMARY KEY (course, student)
+);
+
+CREATE TABLE IF NOT EXISTS token_events (
+    id BIGINT NOT NULL,
+    action TEXT NOT NULL,
+    course VARCHAR(6) NOT NULL,
+    student TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    CONSTRAINT token_events_pkey PRIMARY KEY (id)
+);
+
+CREATE TABLE IF NOT EXISTS course_students (
+    course VARCHAR(6) NOT NULL,
+    tg_username TEXT NOT NULL,
+    student TEXT NOT NULL,
+    CONSTRAINT course_students_pkey PRIMARY KEY (course, tg_username)
+);
+
+CREATE TABLE IF NOT EXISTS telegram_students (
+    tg_username TEXT NOT NULL,
+    course VARCHAR(6) NOT NULL,
+    student TEXT NOT NULL,
+    CONSTRAINT telegram_students_pkey PRIMARY KEY (tg_username)
+);
+
+CREATE TABLE IF NOT EXISTS chat_courses (
+    chat_id BIGINT NOT NULL,
+    course VARCHAR(6) NOT NULL,
+    name TEXT NOT NULL,
+    comment TEXT NOT NULL,
+    associated_at BIGINT NOT NULL,
+    registered_by BIGINT NOT NULL,
+    CONSTRAINT chat_courses_pkey PRIMARY KEY (chat_id)
+);
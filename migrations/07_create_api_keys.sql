@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    name TEXT NOT NULL,
+    key_hash TEXT NOT NULL,
+    key_hint TEXT NOT NULL,
+    scopes TEXT NOT NULL,
+    courses TEXT NOT NULL,
+    created_at BIGINT NOT NULL,
+    created_by TEXT NOT NULL,
+    revoked_at BIGINT,
+    CONSTRAINT api_keys_pkey PRIMARY KEY (name)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS api_keys_key_hash_idx ON api_keys (key_hash);
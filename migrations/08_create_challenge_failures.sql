@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS challenge_failures (
+    course VARCHAR(6) NOT NULL,
+    lab TEXT NOT NULL,
+    student TEXT NOT NULL,
+    timestamp BIGINT NOT NULL,
+    reason TEXT NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS challenge_failures_course_idx ON challenge_failures (course);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS token_lookups (
+    lookup_hash TEXT NOT NULL,
+    course VARCHAR(6) NOT NULL,
+    student TEXT NOT NULL,
+    CONSTRAINT token_lookups_pkey PRIMARY KEY (lookup_hash)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS token_lookups_student_idx ON token_lookups (course, student);
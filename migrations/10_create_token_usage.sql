@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS token_usage (
+    course VARCHAR(6) NOT NULL,
+    student TEXT NOT NULL,
+    lab TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    count BIGINT NOT NULL DEFAULT 0,
+    last_used_at BIGINT NOT NULL,
+    last_ip TEXT NOT NULL,
+    last_user_agent TEXT NOT NULL,
+    CONSTRAINT token_usage_pkey PRIMARY KEY (course, student, lab, event_type)
+);
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS chat_courses_course_idx ON chat_courses (course);
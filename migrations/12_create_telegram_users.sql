@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS telegram_users (
+    tg_username TEXT NOT NULL,
+    user_id BIGINT NOT NULL,
+    CONSTRAINT telegram_users_pkey PRIMARY KEY (tg_username)
+);